@@ -0,0 +1,70 @@
+package api
+
+import "time"
+
+// Health summarizes how a process is doing, from its status and recent
+// exits.
+type Health string
+
+const (
+	// HealthHealthy processes run and did not crash recently.
+	HealthHealthy Health = "healthy"
+	// HealthUnstable processes run, but were restarted after a crash only
+	// moments ago.
+	HealthUnstable Health = "unstable"
+	// HealthRestarting processes exited and wait to be restarted.
+	HealthRestarting Health = "restarting"
+	// HealthExited processes ended successfully and are not restarted.
+	HealthExited Health = "exited"
+	// HealthFailed processes could not be started, or ended with an error
+	// and are not restarted.
+	HealthFailed Health = "failed"
+	// HealthStopped processes were stopped on request.
+	HealthStopped Health = "stopped"
+)
+
+// ExitReason tells why a run of a process ended.
+type ExitReason string
+
+const (
+	// ExitStopped runs were stopped on request.
+	ExitStopped ExitReason = "stopped"
+	// ExitExited runs ended on their own with an exit code.
+	ExitExited ExitReason = "exited"
+	// ExitSignaled runs were ended by a signal.
+	ExitSignaled ExitReason = "signaled"
+	// ExitLost runs were of a process the service re-adopted, so how they
+	// ended is unknown.
+	ExitLost ExitReason = "lost"
+)
+
+// ProcessExit records how a run of a process ended.
+type ProcessExit struct {
+	Time     time.Time  `json:"time"`
+	Reason   ExitReason `json:"reason"`
+	ExitCode int        `json:"exitCode"`
+	// Signal is the signal that ended the run, like SIGKILL.
+	Signal string `json:"signal,omitempty"`
+	// Uptime is how long the run lasted, in milliseconds.
+	Uptime int `json:"uptime"`
+}
+
+// ProcessDetail is everything the service knows about a process. The values
+// of its secret environment variables are masked.
+type ProcessDetail struct {
+	Process
+	Pid int `json:"pid,omitempty"`
+	// Pgid is the process group the process belongs to, it leads it unless
+	// it moved to another one.
+	Pgid        int       `json:"pgid,omitempty"`
+	LastStarted time.Time `json:"lastStarted,omitzero"`
+	LastExited  time.Time `json:"lastExited,omitzero"`
+	// RespawnDelay is how long the service waits before restarting the
+	// process after its next crash, in milliseconds.
+	RespawnDelay int `json:"respawnDelay,omitempty"`
+	// Exits are the last runs that ended, latest first.
+	Exits []ProcessExit `json:"exits"`
+	// LogFiles are the log files of the process, oldest first.
+	LogFiles []string `json:"logFiles"`
+	Health   Health   `json:"health"`
+}
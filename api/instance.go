@@ -0,0 +1,104 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// DefaultInstance is the name of the service instance used without
+// --instance or JPM_INSTANCE.
+const DefaultInstance = "default"
+
+// DefaultPort is the HTTP port of the default instance. Other instances get a
+// port derived from their name above it.
+const DefaultPort = 3000
+
+var instanceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+var instance = DefaultInstance
+
+// SetInstance selects the service instance the files, socket and port
+// belong to, DefaultInstance if name is empty.
+func SetInstance(name string) error {
+	if name == "" {
+		name = DefaultInstance
+	}
+	if !instanceName.MatchString(name) {
+		return fmt.Errorf("invalid instance name %q, use letters, digits, '.', '_' and '-'", name)
+	}
+	instance = name
+	return nil
+}
+
+// Instance is the name of the selected service instance.
+func Instance() string {
+	return instance
+}
+
+// Home is the directory of the configuration, state and logs of the
+// instance: JPM_HOME, or ~/.jpm if not set, for the default instance and
+// instances/<name> in it for others.
+func Home() string {
+	if instance == DefaultInstance {
+		return baseHome()
+	}
+	return filepath.Join(baseHome(), "instances", instance)
+}
+
+// baseHome is JPM_HOME if set, ~/.jpm otherwise.
+func baseHome() string {
+	if home := os.Getenv("JPM_HOME"); home != "" {
+		if abs, err := filepath.Abs(home); err == nil {
+			return abs
+		}
+		return home
+	}
+
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, ".jpm")
+}
+
+// IsDefaultInstance tells if the default instance in the default home is
+// selected, the only one earlier versions knew.
+func IsDefaultInstance() bool {
+	return instanceKey() == ""
+}
+
+// instanceKey tells instances apart in the names of sockets and in ports.
+// It is empty for the default instance, and includes a hash of JPM_HOME
+// when set, so instances with the same name in different homes don't meet.
+func instanceKey() string {
+	key := ""
+	if instance != DefaultInstance {
+		key = instance
+	}
+	if home := os.Getenv("JPM_HOME"); home != "" {
+		sum := sha256.Sum256([]byte(baseHome()))
+		if key != "" {
+			key += "-"
+		}
+		key += hex.EncodeToString(sum[:4])
+	}
+	return key
+}
+
+// DefaultListen is the address the HTTP API of the instance listens on.
+// Ports of other instances are derived from their names, so two of them may
+// get the same one.
+func DefaultListen() string {
+	key := instanceKey()
+	if key == "" {
+		return fmt.Sprintf("127.0.0.1:%d", DefaultPort)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("127.0.0.1:%d", DefaultPort+1+int(h.Sum32()%1000))
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstance(t *testing.T) {
+	t.Setenv("JPM_HOME", "")
+	t.Setenv("HOME", "/home/user")
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	defer SetInstance("")
+
+	if err := SetInstance(""); err != nil {
+		t.Fatalf("SetInstance: %v", err)
+	}
+	if Home() != "/home/user/.jpm" || DefaultSocketPath() != "/run/user/1000/jpm/jpm.sock" || DefaultListen() != "127.0.0.1:3000" {
+		t.Errorf("default instance: home %s, socket %s, listen %s", Home(), DefaultSocketPath(), DefaultListen())
+	}
+	if !IsDefaultInstance() {
+		t.Errorf("default instance is not the default")
+	}
+
+	if err := SetInstance("ci"); err != nil {
+		t.Fatalf("SetInstance: %v", err)
+	}
+	if Home() != "/home/user/.jpm/instances/ci" || DefaultSocketPath() != "/run/user/1000/jpm/ci.sock" {
+		t.Errorf("instance ci: home %s, socket %s", Home(), DefaultSocketPath())
+	}
+	if DefaultListen() == "127.0.0.1:3000" || DefaultListen() != DefaultListen() {
+		t.Errorf("instance ci: listen %s", DefaultListen())
+	}
+	if IsDefaultInstance() {
+		t.Errorf("instance ci is the default")
+	}
+
+	for _, name := range []string{"a/b", "..", "-x", strings.Repeat("a", 65)} {
+		if err := SetInstance(name); err == nil {
+			t.Errorf("SetInstance(%q) succeeded", name)
+		}
+	}
+}
+
+func TestInstance_Home(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("JPM_HOME", home)
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	defer SetInstance("")
+	SetInstance("")
+
+	if Home() != home {
+		t.Errorf("home = %s, want %s", Home(), home)
+	}
+
+	// Instances in the same home have homes of their own in it.
+	SetInstance("a")
+	homeA, listenA := Home(), DefaultListen()
+	SetInstance("b")
+	if homeA != filepath.Join(home, "instances", "a") || Home() != filepath.Join(home, "instances", "b") {
+		t.Errorf("homes of instances a and b = %s, %s", homeA, Home())
+	}
+	if listenA == DefaultListen() {
+		t.Errorf("instances a and b both listen on %s", listenA)
+	}
+	SetInstance("")
+	// Another home is another instance, even with the default name.
+	if IsDefaultInstance() || DefaultSocketPath() == "/run/user/1000/jpm/jpm.sock" {
+		t.Errorf("socket of JPM_HOME = %s", DefaultSocketPath())
+	}
+	if filepath.Dir(DefaultSocketPath()) != "/run/user/1000/jpm" {
+		t.Errorf("socket of JPM_HOME = %s", DefaultSocketPath())
+	}
+}
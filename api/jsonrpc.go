@@ -0,0 +1,217 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// ID identifies a request and its response: a JSON string or number, or
+// null for responses to requests whose id could not be read.
+type ID struct {
+	raw json.RawMessage
+}
+
+func NumberID(n int) ID {
+	return ID{raw: json.RawMessage(strconv.Itoa(n))}
+}
+
+func StringID(s string) ID {
+	raw, _ := json.Marshal(s)
+	return ID{raw: raw}
+}
+
+// String returns the id as it appears in JSON.
+func (id ID) String() string {
+	if len(id.raw) == 0 {
+		return "null"
+	}
+	return string(id.raw)
+}
+
+func (id ID) Equal(other ID) bool {
+	return id.String() == other.String()
+}
+
+func (id ID) MarshalJSON() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *ID) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || !json.Valid(data) {
+		return fmt.Errorf("invalid id %q", data)
+	}
+
+	switch c := data[0]; {
+	case c == 'n' && string(data) == "null":
+		*id = ID{}
+	case c == '"' || c == '-' || (c >= '0' && c <= '9'):
+		*id = ID{raw: bytes.Clone(data)}
+	default:
+		return fmt.Errorf("id must be a string or a number, got %s", data)
+	}
+	return nil
+}
+
+// UnmarshalJSON tells a notification, without an id, apart from a request
+// with a null id.
+func (r *Request) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Header string          `json:"jsonrpc"`
+		Method MethodName      `json:"method"`
+		MsgID  json.RawMessage `json:"id"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*r = Request{Header: v.Header, Method: v.Method, Params: v.Params}
+	if v.MsgID != nil {
+		var id ID
+		if err := id.UnmarshalJSON(v.MsgID); err != nil {
+			return err
+		}
+		r.MsgID = &id
+	}
+	return nil
+}
+
+// validate checks the request against the JSON-RPC 2.0 specification.
+func (r Request) validate() error {
+	if r.Header != "2.0" {
+		return fmt.Errorf(`jsonrpc must be "2.0"`)
+	}
+	if r.Method == "" {
+		return fmt.Errorf("method is missing")
+	}
+	if params := bytes.TrimSpace(r.Params); len(params) > 0 && params[0] != '{' && params[0] != '[' && string(params) != "null" {
+		return fmt.Errorf("params must be an object or an array")
+	}
+	return nil
+}
+
+// MarshalJSON includes "result" in a successful response even when it is
+// empty, as the specification requires.
+func (r Response) MarshalJSON() ([]byte, error) {
+	header := r.Header
+	if header == "" {
+		header = "2.0"
+	}
+
+	if r.Error != nil {
+		return json.Marshal(struct {
+			Header string         `json:"jsonrpc"`
+			Error  *ResponseError `json:"error"`
+			MsgID  ID             `json:"id"`
+		}{header, r.Error, r.MsgID})
+	}
+	return json.Marshal(struct {
+		Header string          `json:"jsonrpc"`
+		Result *ResponseResult `json:"result"`
+		MsgID  ID              `json:"id"`
+	}{header, r.Result, r.MsgID})
+}
+
+// UnmarshalJSON also reads errors of services before JSON-RPC 2.0
+// compliance, which sent them as "params".
+func (r *Response) UnmarshalJSON(data []byte) error {
+	type response Response
+	var v struct {
+		response
+		Legacy *ResponseError `json:"params"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*r = Response(v.response)
+	if r.Error == nil && v.Legacy != nil {
+		r.Error = v.Legacy
+	}
+	return nil
+}
+
+// UnmarshalJSON also reads the message of errors of services before
+// JSON-RPC 2.0 compliance, which sent it as "string".
+func (e *ResponseError) UnmarshalJSON(data []byte) error {
+	type responseError ResponseError
+	var v struct {
+		responseError
+		Legacy *string `json:"string"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*e = ResponseError(v.responseError)
+	if e.Message == "" && v.Legacy != nil {
+		e.Message = *v.Legacy
+	}
+	return nil
+}
+
+// HandleMessage answers a JSON-RPC message, a single request or a batch of
+// them, calling handle for every valid request. Notifications are handled
+// but not answered. It returns nil when there is nothing to answer.
+func HandleMessage(data []byte, handle func(req Request) Response) []byte {
+	data = bytes.TrimSpace(data)
+	if !json.Valid(data) {
+		res, _ := json.Marshal(ErrorResponse(ID{}, int(ParseError), "Parse error"))
+		return res
+	}
+
+	if data[0] != '[' {
+		res, ok := handleRequest(data, handle)
+		if !ok {
+			return nil
+		}
+		out, _ := json.Marshal(res)
+		return out
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil || len(batch) == 0 {
+		res, _ := json.Marshal(ErrorResponse(ID{}, int(InvalidRequest), "Invalid Request: empty batch"))
+		return res
+	}
+
+	responses := []Response{}
+	for _, item := range batch {
+		if res, ok := handleRequest(item, handle); ok {
+			responses = append(responses, res)
+		}
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	out, _ := json.Marshal(responses)
+	return out
+}
+
+// handleRequest answers a single request. ok is false for notifications.
+func handleRequest(data json.RawMessage, handle func(req Request) Response) (res Response, ok bool) {
+	var req Request
+	err := json.Unmarshal(data, &req)
+	if err == nil {
+		err = req.validate()
+	}
+	if err != nil {
+		// Answer with the id of the request, if it can be made out.
+		var v struct {
+			MsgID ID `json:"id"`
+		}
+		json.Unmarshal(data, &v)
+		return ErrorResponse(v.MsgID, int(InvalidRequest), fmt.Sprintf("Invalid Request: %v", err)), true
+	}
+
+	res = handle(req)
+	if req.MsgID == nil {
+		return res, false
+	}
+	res.Header = "2.0"
+	res.MsgID = *req.MsgID
+	return res, true
+}
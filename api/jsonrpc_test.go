@@ -0,0 +1,239 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// echo answers every request with the name of its method.
+func echo(req Request) Response {
+	if req.Method == "fail" {
+		return ErrorResponse(ID{}, int(ServerError), "failed")
+	}
+	method := string(req.Method)
+	return SuccessResponse(ID{}, &ResponseResult{Success: &method})
+}
+
+func handle(t *testing.T, message string) []map[string]any {
+	t.Helper()
+
+	out := HandleMessage([]byte(message), echo)
+	if out == nil {
+		return nil
+	}
+	if out[0] == '[' {
+		var responses []map[string]any
+		if err := json.Unmarshal(out, &responses); err != nil {
+			t.Fatalf("response %s: %v", out, err)
+		}
+		return responses
+	}
+	var res map[string]any
+	if err := json.Unmarshal(out, &res); err != nil {
+		t.Fatalf("response %s: %v", out, err)
+	}
+	return []map[string]any{res}
+}
+
+func errorCode(res map[string]any) int {
+	e, _ := res["error"].(map[string]any)
+	code, _ := e["code"].(float64)
+	return int(code)
+}
+
+func TestHandleMessage_Request(t *testing.T) {
+	res := handle(t, `{"jsonrpc": "2.0", "method": "listProcesses", "id": 7}`)
+	if len(res) != 1 {
+		t.Fatalf("got %d responses, want 1", len(res))
+	}
+	if res[0]["jsonrpc"] != "2.0" || res[0]["id"] != float64(7) {
+		t.Errorf("response = %v", res[0])
+	}
+	if _, ok := res[0]["error"]; ok {
+		t.Errorf("successful response has an error: %v", res[0])
+	}
+	if result, _ := res[0]["result"].(map[string]any); result["success"] != "listProcesses" {
+		t.Errorf("result = %v", res[0]["result"])
+	}
+}
+
+func TestHandleMessage_IDs(t *testing.T) {
+	tests := []struct {
+		id   string
+		want any
+	}{
+		{`1`, float64(1)},
+		{`-3`, float64(-3)},
+		{`"abc"`, "abc"},
+		{`null`, nil},
+	}
+	for _, tt := range tests {
+		res := handle(t, `{"jsonrpc": "2.0", "method": "m", "id": `+tt.id+`}`)
+		if len(res) != 1 {
+			t.Fatalf("id %s: got %d responses, want 1", tt.id, len(res))
+		}
+		id, ok := res[0]["id"]
+		if !ok || id != tt.want {
+			t.Errorf("id %s: response id = %v", tt.id, id)
+		}
+	}
+
+	res := handle(t, `{"jsonrpc": "2.0", "method": "m", "id": {"a": 1}}`)
+	if errorCode(res[0]) != int(InvalidRequest) || res[0]["id"] != nil {
+		t.Errorf("object id: response = %v", res[0])
+	}
+}
+
+func TestHandleMessage_Notification(t *testing.T) {
+	called := false
+	out := HandleMessage([]byte(`{"jsonrpc": "2.0", "method": "m"}`), func(req Request) Response {
+		called = true
+		if req.MsgID != nil {
+			t.Errorf("notification has id %v", req.MsgID)
+		}
+		return echo(req)
+	})
+	if !called {
+		t.Error("notification was not handled")
+	}
+	if out != nil {
+		t.Errorf("notification was answered: %s", out)
+	}
+
+	// Errors of notifications are not answered either.
+	if res := handle(t, `{"jsonrpc": "2.0", "method": "fail"}`); res != nil {
+		t.Errorf("failed notification was answered: %v", res)
+	}
+}
+
+func TestHandleMessage_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		code    JSONRPCErrors
+		id      any
+	}{
+		{"parse error", `{"jsonrpc": "2.0", "method": "m", "id": 1`, ParseError, nil},
+		{"wrong version", `{"jsonrpc": "1.0", "method": "m", "id": 1}`, InvalidRequest, float64(1)},
+		{"no version", `{"method": "m", "id": 1}`, InvalidRequest, float64(1)},
+		{"no method", `{"jsonrpc": "2.0", "id": "x"}`, InvalidRequest, "x"},
+		{"method not a string", `{"jsonrpc": "2.0", "method": 1, "id": 1}`, InvalidRequest, float64(1)},
+		{"params not structured", `{"jsonrpc": "2.0", "method": "m", "params": "x", "id": 1}`, InvalidRequest, float64(1)},
+		{"not an object", `"m"`, InvalidRequest, nil},
+		{"empty batch", `[]`, InvalidRequest, nil},
+		{"handler error", `{"jsonrpc": "2.0", "method": "fail", "id": 2}`, ServerError, float64(2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := handle(t, tt.message)
+			if len(res) != 1 {
+				t.Fatalf("got %d responses, want 1", len(res))
+			}
+			if code := errorCode(res[0]); code != int(tt.code) {
+				t.Errorf("code = %d, want %d", code, tt.code)
+			}
+			if id, ok := res[0]["id"]; !ok || id != tt.id {
+				t.Errorf("id = %v, want %v", id, tt.id)
+			}
+			if _, ok := res[0]["result"]; ok {
+				t.Errorf("error response has a result: %v", res[0])
+			}
+			e, _ := res[0]["error"].(map[string]any)
+			if msg, _ := e["message"].(string); msg == "" {
+				t.Errorf("error has no message: %v", res[0])
+			}
+		})
+	}
+}
+
+func TestHandleMessage_Batch(t *testing.T) {
+	res := handle(t, `[
+		{"jsonrpc": "2.0", "method": "a", "id": 1},
+		{"jsonrpc": "2.0", "method": "notify"},
+		1,
+		{"jsonrpc": "2.0", "method": "b", "id": "2"}
+	]`)
+	if len(res) != 3 {
+		t.Fatalf("got %d responses, want 3: %v", len(res), res)
+	}
+	if res[0]["id"] != float64(1) {
+		t.Errorf("first response = %v", res[0])
+	}
+	if errorCode(res[1]) != int(InvalidRequest) || res[1]["id"] != nil {
+		t.Errorf("invalid element response = %v", res[1])
+	}
+	if res[2]["id"] != "2" {
+		t.Errorf("last response = %v", res[2])
+	}
+
+	if out := HandleMessage([]byte(`[{"jsonrpc": "2.0", "method": "a"}, {"jsonrpc": "2.0", "method": "b"}]`), echo); out != nil {
+		t.Errorf("batch of notifications was answered: %s", out)
+	}
+}
+
+func TestResponse_ResultAlwaysPresent(t *testing.T) {
+	out, err := json.Marshal(Response{Header: "2.0", MsgID: NumberID(1)})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if !strings.Contains(string(out), `"result":null`) {
+		t.Errorf("response without result = %s", out)
+	}
+
+	out, _ = NewSuccessResponse(NumberID(1), nil)
+	if !strings.Contains(string(out), `"result":{}`) {
+		t.Errorf("success response = %s", out)
+	}
+}
+
+func TestResponse_ErrorKeys(t *testing.T) {
+	out, err := NewErrorResponse(StringID("a"), int(MethodNotFound), "Method not found")
+	if err != nil {
+		t.Fatalf("NewErrorResponse: %v", err)
+	}
+	want := `{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":"a"}`
+	if string(out) != want {
+		t.Errorf("response = %s, want %s", out, want)
+	}
+
+	res, err := UnmarshalResponse(out)
+	if err != nil {
+		t.Fatalf("UnmarshalResponse: %v", err)
+	}
+	if res.Error == nil || res.Error.Message != "Method not found" || !res.MsgID.Equal(StringID("a")) {
+		t.Errorf("decoded response = %+v", res)
+	}
+}
+
+func TestResponse_LegacyError(t *testing.T) {
+	res, err := UnmarshalResponse([]byte(`{"jsonrpc":"2.0","params":{"code":501,"string":"Could not stop process"},"id":0}`))
+	if err != nil {
+		t.Fatalf("UnmarshalResponse: %v", err)
+	}
+	if res.Error == nil || res.Error.Code != 501 || res.Error.Message != "Could not stop process" {
+		t.Errorf("decoded error = %+v", res.Error)
+	}
+	if !res.MsgID.Equal(NumberID(0)) {
+		t.Errorf("id = %s, want 0", res.MsgID)
+	}
+}
+
+func TestNewNotification(t *testing.T) {
+	out, err := NewNotification(&RequestListProcessesParams{})
+	if err != nil {
+		t.Fatalf("NewNotification: %v", err)
+	}
+	if strings.Contains(string(out), `"id"`) {
+		t.Errorf("notification has an id: %s", out)
+	}
+
+	out, _ = NewRequest(5, &RequestListProcessesParams{})
+	var req Request
+	if err := json.Unmarshal(out, &req); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if req.MsgID == nil || !req.MsgID.Equal(NumberID(5)) {
+		t.Errorf("request id = %v, want 5", req.MsgID)
+	}
+}
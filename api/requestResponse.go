@@ -20,8 +20,34 @@ const (
 	RequestStopService MethodName = "requestStopService"
 	SaveProcessList    MethodName = "saveProcessList"
 	RestoreProcessList MethodName = "restoreProcessList"
+	ReloadService      MethodName = "reloadService"
+	UpgradeService     MethodName = "upgradeService"
+	QueryLogs          MethodName = "queryLogs"
+	PruneLogs          MethodName = "pruneLogs"
+	GetServiceInfo     MethodName = "getServiceInfo"
+	SignalProcess      MethodName = "signalProcess"
+	DescribeProcess    MethodName = "describeProcess"
 )
 
+// Methods are all methods the service supports.
+var Methods = []MethodName{
+	ListProcesses,
+	StartProcess,
+	StopProcess,
+	RestartProcess,
+	DeleteProcess,
+	RequestStopService,
+	SaveProcessList,
+	RestoreProcessList,
+	ReloadService,
+	UpgradeService,
+	QueryLogs,
+	PruneLogs,
+	GetServiceInfo,
+	SignalProcess,
+	DescribeProcess,
+}
+
 type JSONRPCErrors int
 
 const (
@@ -39,6 +65,25 @@ func (r RequestListProcessesParams) Type() MethodName {
 	return ListProcesses
 }
 
+// LogOptions configure the log files of a single process and what is
+// masked in its output.
+type LogOptions struct {
+	// LogFormat overrides the log format of the service: text, raw or json.
+	LogFormat string `json:"logFormat,omitempty" yaml:"log_format,omitempty"`
+	// LogSplit writes stdout and stderr to separate files.
+	LogSplit bool `json:"logSplit,omitempty" yaml:"log_split,omitempty"`
+	// LogPath is the directory for the log files instead of the one of the
+	// service. Relative paths are relative to the working directory of the process.
+	LogPath string `json:"logPath,omitempty" yaml:"log_path,omitempty"`
+	// LogDisabled turns off file logging for the process.
+	LogDisabled bool `json:"logDisabled,omitempty" yaml:"log_disabled,omitempty"`
+	// LogRetentionDays overrides how many days the log files are kept.
+	LogRetentionDays int `json:"logRetentionDays,omitempty" yaml:"log_retention_days,omitempty"`
+	// SecretEnv names environment variables, wildcards allowed, whose values
+	// are masked in the output, in addition to the ones of the service.
+	SecretEnv []string `json:"secretEnv,omitempty" yaml:"secret_env,omitempty"`
+}
+
 type RequestStartProcessParams struct {
 	Name      string   `json:"name,omitempty"`
 	Namespace string   `json:"namespace,omitempty"`
@@ -46,6 +91,7 @@ type RequestStartProcessParams struct {
 	Arg       []string `json:"args"`
 	Env       []string `json:"env"`
 	Dir       string   `json:"cwd"`
+	LogOptions
 }
 
 func (r RequestStartProcessParams) Type() MethodName {
@@ -79,6 +125,33 @@ func (r RequestDeleteProcessParams) Type() MethodName {
 	return DeleteProcess
 }
 
+type RequestSignalProcessParams struct {
+	Id string `json:"id,omitempty"`
+	// Query selects the processes by name or namespace, or all of them with
+	// "all". Selected processes that are not running are skipped.
+	Query string `json:"query,omitempty"`
+	// Signal is a signal name like SIGHUP or HUP, or its number.
+	Signal string `json:"signal"`
+	// Group sends the signal to the process group of each process, which
+	// includes its children.
+	Group bool `json:"group,omitempty"`
+}
+
+func (r RequestSignalProcessParams) Type() MethodName {
+	return SignalProcess
+}
+
+type RequestDescribeProcessParams struct {
+	Id string `json:"id,omitempty"`
+	// Query selects the processes by name or namespace, or all of them with
+	// "all".
+	Query string `json:"query,omitempty"`
+}
+
+func (r RequestDescribeProcessParams) Type() MethodName {
+	return DescribeProcess
+}
+
 type RequestStopServiceParams struct {
 }
 
@@ -86,6 +159,70 @@ func (r RequestStopServiceParams) Type() MethodName {
 	return RequestStopService
 }
 
+type RequestReloadServiceParams struct{}
+
+func (r RequestReloadServiceParams) Type() MethodName {
+	return ReloadService
+}
+
+type RequestUpgradeServiceParams struct {
+	Exec string `json:"exec,omitempty"`
+}
+
+func (r RequestUpgradeServiceParams) Type() MethodName {
+	return UpgradeService
+}
+
+type RequestQueryLogsParams struct {
+	Id string `json:"id"`
+	// Since and Until limit the lines to a period of time. Each is an RFC 3339
+	// time, a date or a duration before now, like "2h".
+	Since string `json:"since,omitempty"`
+	Until string `json:"until,omitempty"`
+	// Grep is a regular expression lines must match.
+	Grep   string     `json:"grep,omitempty"`
+	Stream StreamType `json:"stream,omitempty"`
+	Limit  int        `json:"limit,omitempty"`
+	// After is the Next value of the previous page of results.
+	After string `json:"after,omitempty"`
+}
+
+func (r RequestQueryLogsParams) Type() MethodName {
+	return QueryLogs
+}
+
+type RequestPruneLogsParams struct {
+	// OlderThan removes all log files of days before it, instead of applying
+	// the retention settings. It is a date or a duration before now, like "7d".
+	OlderThan string `json:"olderThan,omitempty"`
+	// DryRun only lists the files that would be removed.
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+func (r RequestPruneLogsParams) Type() MethodName {
+	return PruneLogs
+}
+
+type RequestGetServiceInfoParams struct{}
+
+func (r RequestGetServiceInfoParams) Type() MethodName {
+	return GetServiceInfo
+}
+
+// PrunedFile is a log file removed by pruning.
+type PrunedFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// LogLine is a line read back from the log files of a process.
+type LogLine struct {
+	// Time is when the line was logged, empty for lines in the raw format.
+	Time   string     `json:"ts,omitempty"`
+	Stream StreamType `json:"stream,omitempty"`
+	Msg    string     `json:"msg"`
+}
+
 // SaveEntry represents a single process entry in a saved process list.
 type SaveEntry struct {
 	Name      string   `json:"name,omitempty" yaml:"name,omitempty"`
@@ -95,6 +232,8 @@ type SaveEntry struct {
 	Env       []string `json:"env,omitempty" yaml:"env,omitempty"`
 	Dir       string   `json:"cwd,omitempty" yaml:"cwd,omitempty"`
 	Status    string   `json:"status" yaml:"status"`
+
+	LogOptions `yaml:",inline"`
 }
 
 type RequestSaveProcessListParams struct{}
@@ -116,17 +255,19 @@ type RequestParams interface {
 }
 
 type Request struct {
-	Header string          `json:"jsonrpc"`
-	Method MethodName      `json:"method"`
-	MsgID  int             `json:"id"`
+	Header string     `json:"jsonrpc"`
+	Method MethodName `json:"method"`
+	// MsgID is nil for a notification, which gets no response.
+	MsgID  *ID             `json:"id,omitempty"`
 	Params json.RawMessage `json:"params,omitempty"`
 }
 
+// Response holds either a Result or an Error.
 type Response struct {
 	Header string          `json:"jsonrpc"`
 	Result *ResponseResult `json:"result,omitempty"`
-	Error  *ResponseError  `json:"params,omitempty"`
-	MsgID  int             `json:"id"`
+	Error  *ResponseError  `json:"error,omitempty"`
+	MsgID  ID              `json:"id"`
 }
 
 type Process struct {
@@ -142,6 +283,15 @@ type Process struct {
 	FailCount  int      `json:"failCount,omitempty"`
 	Status     Status   `json:"status"`
 	ExitCode   int      `json:"exitCode"`
+	Restart    string   `json:"restartPolicy,omitempty"`
+	// OutputUnavailable is set for processes re-adopted after a service crash,
+	// whose output can't be captured until they are restarted.
+	OutputUnavailable bool `json:"outputUnavailable,omitempty"`
+	// LoggingDegraded tells why output is not reliably written to the log
+	// files, like a full disk. It is empty when logging works.
+	LoggingDegraded string `json:"loggingDegraded,omitempty"`
+
+	LogOptions
 }
 
 type ResponseResult struct {
@@ -150,15 +300,35 @@ type ResponseResult struct {
 	ProcessId   *string        `json:"processId,omitempty"`
 	Process     *Process       `json:"process,omitempty"`
 	SaveEntries *([]SaveEntry) `json:"saveEntries,omitempty"`
+	LogLines    *([]LogLine)   `json:"logLines,omitempty"`
+	// Next requests the following page of LogLines, it is empty on the last page.
+	Next        *string         `json:"next,omitempty"`
+	PrunedFiles *([]PrunedFile) `json:"prunedFiles,omitempty"`
+	ServiceInfo *ServiceInfo    `json:"serviceInfo,omitempty"`
+	// ProcessIds are the processes a request for several of them acted on.
+	ProcessIds     *([]string)        `json:"processIds,omitempty"`
+	ProcessDetail  *ProcessDetail     `json:"processDetail,omitempty"`
+	ProcessDetails *([]ProcessDetail) `json:"processDetails,omitempty"`
 }
 
 type ResponseError struct {
 	Code    int              `json:"code"`
-	Message string           `json:"string"`
+	Message string           `json:"message"`
 	Data    *json.RawMessage `json:"data,omitempty"`
 }
 
 func NewRequest(msgID int, params RequestParams) ([]byte, error) {
+	id := NumberID(msgID)
+	return newRequest(&id, params)
+}
+
+// NewNotification builds a request without an id, to which the service
+// does not respond.
+func NewNotification(params RequestParams) ([]byte, error) {
+	return newRequest(nil, params)
+}
+
+func newRequest(msgID *ID, params RequestParams) ([]byte, error) {
 	if params == nil {
 		return nil, errors.New("message must not be nil")
 	}
@@ -170,14 +340,23 @@ func NewRequest(msgID int, params RequestParams) ([]byte, error) {
 	return json.Marshal(envelope)
 }
 
-func NewSuccessResponse(msgID int, data *ResponseResult) ([]byte, error) {
-	envelope := Response{Header: "2.0", MsgID: msgID, Result: data}
-	return json.Marshal(envelope)
+func NewSuccessResponse(msgID ID, data *ResponseResult) ([]byte, error) {
+	return json.Marshal(SuccessResponse(msgID, data))
 }
 
-func NewErrorResponse(msgID int, code int, message string) ([]byte, error) {
-	envelope := Response{Header: "2.0", MsgID: msgID, Error: &ResponseError{Code: code, Message: message}}
-	return json.Marshal(envelope)
+func NewErrorResponse(msgID ID, code int, message string) ([]byte, error) {
+	return json.Marshal(ErrorResponse(msgID, code, message))
+}
+
+func SuccessResponse(msgID ID, data *ResponseResult) Response {
+	if data == nil {
+		data = &ResponseResult{}
+	}
+	return Response{Header: "2.0", MsgID: msgID, Result: data}
+}
+
+func ErrorResponse(msgID ID, code int, message string) Response {
+	return Response{Header: "2.0", MsgID: msgID, Error: &ResponseError{Code: code, Message: message}}
 }
 
 func UnmarshalResponse(data []byte) (Response, error) {
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// MaxMessageSize is the longest message the service reads from a session.
+const MaxMessageSize = 8 << 20
+
+var socketPath string
+
+// SetSocketPath sets the socket of the service, DefaultSocketPath if empty.
+func SetSocketPath(path string) {
+	socketPath = path
+}
+
+// SocketPath is the Unix domain socket of the service. It takes one JSON-RPC
+// message per line and every connection is a session of its own, so shell
+// scripts can talk to the service with tools like socat or nc.
+func SocketPath() string {
+	if socketPath != "" {
+		return socketPath
+	}
+	return DefaultSocketPath()
+}
+
+// DefaultSocketPath is in the runtime directory of the user, or in a
+// directory of the user in the temporary directory without one. Each
+// instance has a socket of its own there.
+func DefaultSocketPath() string {
+	name := "jpm.sock"
+	if key := instanceKey(); key != "" {
+		name = key + ".sock"
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "jpm", name)
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("jpm-%d", os.Getuid()), name)
+}
@@ -11,4 +11,7 @@ const (
 type StdStreamMessage struct {
 	StreamType StreamType
 	Data       []byte
+	// Seq numbers the output of a process, so a client can resume after the
+	// last message it received. It is 0 for messages that are not numbered.
+	Seq uint64
 }
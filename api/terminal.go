@@ -0,0 +1,47 @@
+package api
+
+// TerminalPath is the path of the WebSocket terminal of a process in the
+// HTTP API, with %s for the id.
+const TerminalPath = "/api/processes/%s/terminal"
+
+type TerminalMessageType string
+
+const (
+	// TerminalStdout and TerminalStderr carry output of the process to the
+	// client, numbered by Seq.
+	TerminalStdout TerminalMessageType = "stdout"
+	TerminalStderr TerminalMessageType = "stderr"
+	// TerminalStdin carries input from the client to the process.
+	TerminalStdin TerminalMessageType = "stdin"
+	// TerminalResize tells the size of the terminal of the client.
+	TerminalResize TerminalMessageType = "resize"
+	// TerminalSignal asks to send Signal to the process.
+	TerminalSignal TerminalMessageType = "signal"
+	// TerminalRestart asks to restart the process.
+	TerminalRestart TerminalMessageType = "restart"
+	// TerminalStatus tells the client that the status of the process changed.
+	TerminalStatus TerminalMessageType = "status"
+	// TerminalError tells the client that one of its messages failed.
+	TerminalError TerminalMessageType = "error"
+)
+
+// TerminalMessage is a message of the WebSocket terminal, sent as a JSON
+// text frame in either direction. Only the fields of its type are set.
+type TerminalMessage struct {
+	Type TerminalMessageType `json:"type"`
+	// Data is the output or input.
+	Data string `json:"data,omitempty"`
+	// Seq numbers output, pass the last one as lastEventId when reconnecting
+	// to continue after it.
+	Seq uint64 `json:"seq,omitempty"`
+	// Dropped counts output lost before this message because the client
+	// did not keep up with it.
+	Dropped uint64 `json:"dropped,omitempty"`
+	Cols    int    `json:"cols,omitempty"`
+	Rows    int    `json:"rows,omitempty"`
+	// Signal is a signal name like SIGINT or INT, or its number.
+	Signal   string `json:"signal,omitempty"`
+	Status   string `json:"status,omitempty"`
+	ExitCode *int   `json:"exitCode,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"runtime/debug"
+	"slices"
+)
+
+// Version is the version of jpm, set when building a release with
+// -ldflags "-X jstarpl/jpm/api.Version=v1.2.3".
+var Version = ""
+
+// APIVersion is the version of the IPC and HTTP API. It is raised when a
+// change needs clients and services to be updated together.
+const APIVersion = 1
+
+// Features are optional capabilities of the service beyond its methods.
+const (
+	// FeatureBatch is support for JSON-RPC batches.
+	FeatureBatch = "batch"
+	// FeatureNotifications is support for JSON-RPC notifications.
+	FeatureNotifications = "notifications"
+	// FeatureSessions is a session of its own for every socket connection.
+	FeatureSessions = "sessions"
+	// FeatureInstances is support for several instances on one machine.
+	FeatureInstances = "instances"
+	// FeatureRedaction is masking of secrets in process output.
+	FeatureRedaction = "redaction"
+	// FeatureTerminal is the WebSocket terminal of the HTTP API.
+	FeatureTerminal = "terminal"
+)
+
+// Features are the features of this version of the service.
+var Features = []string{FeatureBatch, FeatureNotifications, FeatureSessions, FeatureInstances, FeatureRedaction, FeatureTerminal}
+
+// ServiceInfo describes a running service, so that clients can tell what it
+// supports.
+type ServiceInfo struct {
+	Version    string       `json:"version"`
+	APIVersion int          `json:"apiVersion"`
+	Instance   string       `json:"instance"`
+	Methods    []MethodName `json:"methods"`
+	Features   []string     `json:"features"`
+}
+
+// Supports tells if the service has the method.
+func (i ServiceInfo) Supports(method MethodName) bool {
+	return slices.Contains(i.Methods, method)
+}
+
+// HasFeature tells if the service has the feature.
+func (i ServiceInfo) HasFeature(feature string) bool {
+	return slices.Contains(i.Features, feature)
+}
+
+// BuildVersion returns Version, or the version Go recorded in the binary
+// when it was not set.
+func BuildVersion() string {
+	if Version != "" {
+		return Version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 7 {
+			return "dev-" + setting.Value[:7]
+		}
+	}
+	return "dev"
+}
+
+// LocalServiceInfo describes the service in this binary.
+func LocalServiceInfo() ServiceInfo {
+	return ServiceInfo{
+		Version:    BuildVersion(),
+		APIVersion: APIVersion,
+		Instance:   Instance(),
+		Methods:    slices.Clone(Methods),
+		Features:   slices.Clone(Features),
+	}
+}
@@ -0,0 +1,22 @@
+package api
+
+import "testing"
+
+func TestLocalServiceInfo(t *testing.T) {
+	info := LocalServiceInfo()
+	if info.APIVersion != APIVersion || info.Version == "" || info.Instance != Instance() {
+		t.Errorf("info = %+v", info)
+	}
+	if !info.Supports(GetServiceInfo) || !info.Supports(ListProcesses) || info.Supports("unknown") {
+		t.Errorf("methods = %v", info.Methods)
+	}
+	if !info.HasFeature(FeatureBatch) || info.HasFeature("unknown") {
+		t.Errorf("features = %v", info.Features)
+	}
+
+	// The info is a copy, changing it does not change the service.
+	info.Methods[0] = "changed"
+	if Methods[0] == "changed" {
+		t.Errorf("LocalServiceInfo shares its methods")
+	}
+}
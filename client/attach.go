@@ -0,0 +1,241 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"jstarpl/jpm/api"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"golang.org/x/term"
+)
+
+type Attach struct {
+	Id       string `arg:""`
+	Remote   string `name:"remote" help:"URL of the service to attach through, like http://host:3000. Defaults to the console of the local service."`
+	Token    string `name:"token" env:"JPM_TOKEN" help:"Token of the service. Defaults to the one in the configuration file." yaml:"token"`
+	SigProxy bool   `name:"sig-proxy" help:"Send Ctrl+C to the process as SIGINT instead of detaching."`
+	// Listen is where the local service listens, read from the configuration
+	// file like the service does.
+	Listen string `name:"listen" hidden:"" default:"${defaultListen}" yaml:"listen"`
+}
+
+// terminal is a WebSocket connection to the terminal of a process.
+type terminal struct {
+	conn     *websocket.Conn
+	mu       sync.Mutex
+	detached atomic.Bool
+}
+
+func (t *terminal) send(msg api.TerminalMessage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return t.conn.WriteJSON(msg)
+}
+
+// detach ends the session, leaving the process running.
+func (t *terminal) detach() {
+	t.detached.Store(true)
+	t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	t.conn.Close()
+}
+
+// AttachProcess connects the console to the input and output of a process
+// through the HTTP API of a service, until the process is deleted or the
+// user detaches.
+func AttachProcess(cli *Attach) {
+	u, err := terminalURL(cli.Remote, cli.Listen, cli.Id)
+	if err != nil {
+		Fail(ErrorInvalid, "%v", err)
+	}
+
+	header := http.Header{}
+	if cli.Token != "" {
+		header.Set("Authorization", "Bearer "+cli.Token)
+	}
+	conn, res, err := websocket.DefaultDialer.Dial(u, header)
+	if err != nil {
+		kind, err := dialError(res, err)
+		Fail(kind, "Could not attach to process %s: %v", cli.Id, err)
+	}
+	t := &terminal{conn: conn}
+
+	detachHint := "press Ctrl+D to detach"
+	if !cli.SigProxy {
+		detachHint = "press Ctrl+C or Ctrl+D to detach"
+	}
+	fmt.Fprintf(os.Stderr, "Attached to process %s, %s.\n", cli.Id, detachHint)
+
+	go t.forwardInput()
+	go t.forwardSignals(cli.SigProxy)
+	go t.forwardSize()
+
+	t.printOutput()
+}
+
+// terminalURL builds the WebSocket URL of the terminal of a process from the
+// URL of a service, or from the address the local service listens on.
+func terminalURL(remote, listen, id string) (string, error) {
+	if remote == "" {
+		host, port, err := net.SplitHostPort(listen)
+		if err != nil {
+			return "", fmt.Errorf("Invalid listen address %q: %v", listen, err)
+		}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+		}
+		remote = "http://" + net.JoinHostPort(host, port)
+	}
+
+	u, err := url.Parse(remote)
+	if err != nil {
+		return "", fmt.Errorf("Invalid remote %q: %v", remote, err)
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("Invalid remote %q: use an http:// or https:// URL", remote)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + fmt.Sprintf(api.TerminalPath, url.PathEscape(id))
+	return u.String(), nil
+}
+
+// dialError explains why the service refused the connection, or could not
+// be reached.
+func dialError(res *http.Response, err error) (ErrorKind, error) {
+	if res == nil {
+		return ErrorUnavailable, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return ErrorUnauthorized, fmt.Errorf("not authorized, pass the token of the service with --token or JPM_TOKEN")
+	}
+	data, _ := io.ReadAll(res.Body)
+	if r, err := api.UnmarshalResponse(data); err == nil && r.Error != nil {
+		return errorKind(res.StatusCode), fmt.Errorf("%s", r.Error.Message)
+	}
+	if res.StatusCode == http.StatusNotFound {
+		// Services without terminals serve the web console there.
+		return ErrorUnsupported, fmt.Errorf("the service does not support terminals. %s", upgradeHint())
+	}
+	return errorKind(res.StatusCode), fmt.Errorf("%s", res.Status)
+}
+
+// printOutput prints what the service sends until the session ends.
+func (t *terminal) printOutput() {
+	for {
+		var msg api.TerminalMessage
+		if err := t.conn.ReadJSON(&msg); err != nil {
+			if t.detached.Load() {
+				return
+			}
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseNormalClosure {
+				if closeErr.Text != "" {
+					fmt.Fprintf(os.Stderr, "%s.\n", closeErr.Text)
+				}
+				return
+			}
+			Fail(ErrorUnavailable, "Connection lost: %v", err)
+		}
+
+		switch msg.Type {
+		case api.TerminalStdout:
+			if msg.Dropped > 0 {
+				fmt.Fprintf(os.Stderr, "[%d lines of output skipped]\n", msg.Dropped)
+			}
+			os.Stdout.WriteString(msg.Data)
+		case api.TerminalStderr:
+			if msg.Dropped > 0 {
+				fmt.Fprintf(os.Stderr, "[%d lines of output skipped]\n", msg.Dropped)
+			}
+			os.Stderr.WriteString(msg.Data)
+		case api.TerminalStatus:
+			if msg.ExitCode != nil {
+				fmt.Fprintf(os.Stderr, "[process exited with code %d, %s]\n", *msg.ExitCode, msg.Status)
+			} else {
+				fmt.Fprintf(os.Stderr, "[process %s]\n", msg.Status)
+			}
+		case api.TerminalError:
+			fmt.Fprintf(os.Stderr, "Error: %s\n", msg.Message)
+		}
+	}
+}
+
+// forwardInput sends the input of the console to the process, detaching at
+// its end.
+func (t *terminal) forwardInput() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			if t.send(api.TerminalMessage{Type: api.TerminalStdin, Data: string(buf[:n])}) != nil {
+				return
+			}
+		}
+		if err != nil {
+			t.detach()
+			return
+		}
+	}
+}
+
+// forwardSignals passes Ctrl+C and termination on to the process with
+// sigProxy, otherwise they detach.
+func (t *terminal) forwardSignals(sigProxy bool) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	for sig := range signals {
+		if !sigProxy {
+			t.detach()
+			return
+		}
+		name := "SIGINT"
+		if sig == syscall.SIGTERM {
+			name = "SIGTERM"
+		}
+		if t.send(api.TerminalMessage{Type: api.TerminalSignal, Signal: name}) != nil {
+			return
+		}
+	}
+}
+
+// forwardSize tells the service the size of the console, now and whenever
+// it changes.
+func (t *terminal) forwardSize() {
+	fd := int(os.Stdout.Fd())
+	if !term.IsTerminal(fd) {
+		return
+	}
+
+	resized := make(chan os.Signal, 1)
+	resized <- nil
+	notifyResize(resized)
+
+	for range resized {
+		cols, rows, err := term.GetSize(fd)
+		if err != nil {
+			return
+		}
+		if t.send(api.TerminalMessage{Type: api.TerminalResize, Cols: cols, Rows: rows}) != nil {
+			return
+		}
+	}
+}
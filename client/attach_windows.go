@@ -0,0 +1,7 @@
+package client
+
+import "os"
+
+// notifyResize does nothing, Windows does not signal changes of the size of
+// the console.
+func notifyResize(c chan<- os.Signal) {}
@@ -3,8 +3,8 @@ package client
 import (
 	"fmt"
 	"jstarpl/jpm/api"
-	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,9 +15,15 @@ import (
 type Ps struct{}
 
 type Start struct {
-	Name      string   `name:"name" help:"Name of the process"`
-	Namespace string   `name:"namespace" help:"Namespace for the process, useful to group processes to address them together"`
-	Args      []string `arg:""`
+	Name             string   `name:"name" help:"Name of the process"`
+	Namespace        string   `name:"namespace" help:"Namespace for the process, useful to group processes to address them together"`
+	LogFormat        string   `name:"log-format" help:"Format of the process log files: text, raw or json. Defaults to the service setting."`
+	LogSplit         bool     `name:"log-split" help:"Write stdout and stderr to separate log files."`
+	LogPath          string   `name:"log-path" help:"Directory for the log files of the process instead of the one of the service." type:"path"`
+	NoLog            bool     `name:"no-log" help:"Do not write the output of the process to log files."`
+	LogRetentionDays int      `name:"log-retention-days" help:"Number of days to keep the log files of the process. Defaults to the service setting."`
+	SecretEnv        []string `name:"secret-env" help:"Environment variable whose value is masked in the output, wildcards allowed. Adds to the ones of the service."`
+	Args             []string `arg:""`
 }
 
 type Stop struct {
@@ -32,6 +38,32 @@ type Delete struct {
 	Id string `arg:""`
 }
 
+type Signal struct {
+	Target string `arg:"" help:"Id of the process, or a name, namespace or 'all' to signal all running processes it selects."`
+	Signal string `arg:"" help:"Signal to send, like SIGHUP, hup or 1."`
+	Group  bool   `name:"group" short:"g" help:"Send the signal to the whole process group of the process, reaching its children too."`
+}
+
+type Logs struct {
+	Search LogsSearch `cmd:"" default:"withargs" help:"Search the log files of a process."`
+	Prune  LogsPrune  `cmd:"" help:"Remove old log files of all processes."`
+}
+
+type LogsSearch struct {
+	Id     string `arg:""`
+	Grep   string `name:"grep" short:"g" help:"Only show lines matching this regular expression."`
+	Since  string `name:"since" help:"Only show lines logged since this time: an RFC 3339 time, a date or a duration like 2h or 7d."`
+	Until  string `name:"until" help:"Only show lines logged until this time."`
+	Stream string `name:"stream" help:"Only show lines of this stream: stdout or stderr."`
+	Limit  int    `name:"limit" short:"n" help:"Maximum number of lines to show." default:"100"`
+	After  string `name:"after" help:"Continue a previous search, as suggested at its end."`
+}
+
+type LogsPrune struct {
+	OlderThan string `name:"older-than" help:"Remove all log files older than this, like 7d, instead of applying the retention settings."`
+	DryRun    bool   `name:"dry-run" help:"Only list the files that would be removed."`
+}
+
 type Save struct {
 	File string `arg:"" help:"File path to save the process list dump to"`
 }
@@ -40,61 +72,68 @@ type Restore struct {
 	File string `arg:"" help:"File path to restore the process list from"`
 }
 
+type Version struct{}
+
 func ListProcesses(cli *Ps) {
 	client, err := DialService()
 	if err != nil {
-		log.Fatalf("Could not connect to service: %v", err)
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
 	}
 
 	req := &api.RequestListProcessesParams{}
-	bReq, err := api.NewRequest(1, req)
-	if err != nil {
-		panic("Could not build request")
-	}
-
-	err = client.WriteMsg(bReq)
-	if err != nil {
-		log.Fatalf("Unknown writing to server: %v", err)
-	}
-
-	data, err := client.ReadMsg()
-
-	if err != nil {
-		log.Fatalf("Unknown error reading from server: %v", err)
-	}
-
-	res, err := api.UnmarshalResponse(data)
-
-	if err != nil {
-		log.Fatalf("Could not decode response: %v", err)
-	}
-
-	if res.Error != nil {
-		log.Fatalf("Error while doing. %d %s", res.Error.Code, res.Error.Message)
-	}
+	SendRequest(client, 1, req)
+	res, _ := ReadResponse(client)
 
 	if res.Result == nil || res.Result.ProcessList == nil {
-		log.Fatalf("Invalid response: %v", res)
+		Fail(ErrorGeneric, "Invalid response: no process list returned")
 	}
+	client.Close()
 
-	tw := table.NewWriter()
-	tw.AppendHeader(table.Row{"ID", "Name", "Namespace", "Command", "Status", "↦", "⭯", "Uptime", "Args"})
-	for _, process := range *res.Result.ProcessList {
-		tw.AppendRow(table.Row{process.Id, process.Name, process.Namespace, process.Exec, process.Status, process.StartCount, process.FailCount, time.Duration(process.Uptime) * time.Millisecond, strings.Join(process.Arg, " ")})
-	}
-	tw.SetStyle(table.StyleRounded)
-	if len(*res.Result.ProcessList) > 0 {
-		tw.SuppressEmptyColumns()
-	}
-	fmt.Println(tw.Render())
+	list := *res.Result.ProcessList
+	var ids []string
+	for _, process := range list {
+		ids = append(ids, process.Id)
+	}
+	printResult(res.Result, ids, func(wide bool) {
+		tw := table.NewWriter()
+		header := table.Row{"ID", "Name", "Namespace", "Command", "Status", "↦", "⭯", "Uptime", "Args"}
+		if wide {
+			header = append(header, "Exit code", "Restart", "Directory", "Logging")
+		}
+		tw.AppendHeader(header)
+		for _, process := range list {
+			row := table.Row{process.Id, process.Name, process.Namespace, process.Exec, process.Status, process.StartCount, process.FailCount, time.Duration(process.Uptime) * time.Millisecond, strings.Join(process.Arg, " ")}
+			if wide {
+				row = append(row, process.ExitCode, process.Restart, process.Dir, loggingState(process))
+			}
+			tw.AppendRow(row)
+		}
+		tw.SetStyle(table.StyleRounded)
+		if len(list) > 0 {
+			tw.SuppressEmptyColumns()
+		}
+		fmt.Println(tw.Render())
+	})
+}
 
-	client.Close()
+// loggingState tells how the output of a process is logged, for the wide
+// process list.
+func loggingState(process api.Process) string {
+	switch {
+	case process.LogDisabled:
+		return "disabled"
+	case process.LoggingDegraded != "":
+		return "degraded"
+	case process.OutputUnavailable:
+		return "unavailable"
+	}
+	return "ok"
 }
 
 func StartProcess(cli *Start) {
 	client, err := DialService()
 	if err != nil {
-		log.Fatalf("Could not connect to service: %v", err)
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
 	}
 	defer client.Close()
 
@@ -110,20 +149,30 @@ func StartProcess(cli *Start) {
 		Arg:       cli.Args[1:],
 		Env:       os.Environ(),
 		Dir:       pwd,
+		LogOptions: api.LogOptions{
+			LogFormat:        cli.LogFormat,
+			LogSplit:         cli.LogSplit,
+			LogPath:          cli.LogPath,
+			LogDisabled:      cli.NoLog,
+			LogRetentionDays: cli.LogRetentionDays,
+			SecretEnv:        cli.SecretEnv,
+		},
 	}
 	SendRequest(client, 1, req)
 	res, _ := ReadResponse(client)
 
-	if res.Result != nil && res.Result.Success != nil {
-		fmt.Printf("Process started %s\n", *res.Result.ProcessId)
+	if res.Result == nil || res.Result.ProcessId == nil {
+		Fail(ErrorGeneric, "Invalid response: no process id returned")
 	}
-
+	printResult(res.Result, []string{*res.Result.ProcessId}, func(bool) {
+		fmt.Printf("Process started %s\n", *res.Result.ProcessId)
+	})
 }
 
 func RestartProcess(cli *Restart) {
 	client, err := DialService()
 	if err != nil {
-		log.Fatalf("Could not connect to service: %v", err)
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
 	}
 	defer client.Close()
 
@@ -133,15 +182,15 @@ func RestartProcess(cli *Restart) {
 	SendRequest(client, 1, req)
 	res, _ := ReadResponse(client)
 
-	if res.Result != nil && res.Result.Success != nil {
+	printResult(res.Result, []string{cli.Id}, func(bool) {
 		fmt.Printf("Process restarted %s\n", cli.Id)
-	}
+	})
 }
 
 func StopProcess(cli *Stop) {
 	client, err := DialService()
 	if err != nil {
-		log.Fatalf("Could not connect to service: %v", err)
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
 	}
 	defer client.Close()
 
@@ -151,15 +200,15 @@ func StopProcess(cli *Stop) {
 	SendRequest(client, 1, req)
 	res, _ := ReadResponse(client)
 
-	if res.Result != nil && res.Result.Success != nil {
+	printResult(res.Result, []string{cli.Id}, func(bool) {
 		fmt.Printf("Process stopped %s\n", cli.Id)
-	}
+	})
 }
 
 func DeleteProcess(cli *Delete) {
 	client, err := DialService()
 	if err != nil {
-		log.Fatalf("Could not connect to service: %v", err)
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
 	}
 	defer client.Close()
 
@@ -169,15 +218,42 @@ func DeleteProcess(cli *Delete) {
 	SendRequest(client, 1, req)
 	res, _ := ReadResponse(client)
 
-	if res.Result != nil && res.Result.Success != nil {
+	printResult(res.Result, []string{cli.Id}, func(bool) {
 		fmt.Printf("Process deleted %s\n", cli.Id)
+	})
+}
+
+func SignalProcess(cli *Signal) {
+	client, err := DialService()
+	if err != nil {
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
+	}
+	defer client.Close()
+
+	req := &api.RequestSignalProcessParams{
+		Signal: cli.Signal,
+		Group:  cli.Group,
+	}
+	if _, err := strconv.Atoi(cli.Target); err == nil {
+		req.Id = cli.Target
+	} else {
+		req.Query = cli.Target
 	}
+	SendRequest(client, 1, req)
+	res, _ := ReadResponse(client)
+
+	if res.Result == nil || res.Result.ProcessIds == nil || res.Result.Success == nil {
+		Fail(ErrorGeneric, "Invalid response: no signalled processes returned")
+	}
+	printResult(res.Result, *res.Result.ProcessIds, func(bool) {
+		fmt.Println(*res.Result.Success)
+	})
 }
 
 func RequestStopService() {
 	client, err := DialService()
 	if err != nil {
-		log.Fatalf("Could not connect to service: %v", err)
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
 	}
 	defer client.Close()
 
@@ -185,15 +261,140 @@ func RequestStopService() {
 	SendRequest(client, 1, req)
 	res, _ := ReadResponse(client)
 
-	if res.Result != nil && res.Result.Success != nil {
+	printResult(res.Result, nil, func(bool) {
 		fmt.Printf("Requested service shutdown\n")
+	})
+}
+
+func RequestReloadService() {
+	client, err := DialService()
+	if err != nil {
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
+	}
+	defer client.Close()
+
+	req := &api.RequestReloadServiceParams{}
+	SendRequest(client, 1, req)
+	res, _ := ReadResponse(client)
+
+	printResult(res.Result, nil, func(bool) {
+		fmt.Printf("Service configuration reloaded\n")
+	})
+}
+
+func RequestUpgradeService(exec string) {
+	client, err := DialService()
+	if err != nil {
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
+	}
+	defer client.Close()
+
+	req := &api.RequestUpgradeServiceParams{Exec: exec}
+	SendRequest(client, 1, req)
+	res, _ := ReadResponse(client)
+
+	printResult(res.Result, nil, func(bool) {
+		fmt.Printf("Service upgraded\n")
+	})
+}
+
+func QueryLogs(cli *LogsSearch) {
+	client, err := DialService()
+	if err != nil {
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
 	}
+	defer client.Close()
+
+	req := &api.RequestQueryLogsParams{
+		Id:     cli.Id,
+		Since:  cli.Since,
+		Until:  cli.Until,
+		Grep:   cli.Grep,
+		Stream: api.StreamType(cli.Stream),
+		Limit:  cli.Limit,
+		After:  cli.After,
+	}
+	SendRequest(client, 1, req)
+	res, _ := ReadResponse(client)
+
+	if res.Result == nil || res.Result.LogLines == nil {
+		Fail(ErrorGeneric, "Invalid response: no log lines returned")
+	}
+
+	printResult(res.Result, nil, func(bool) {
+		for _, line := range *res.Result.LogLines {
+			switch {
+			case line.Time != "":
+				fmt.Printf("%s [%s] %s\n", line.Time, line.Stream, line.Msg)
+			case line.Stream != "":
+				fmt.Printf("[%s] %s\n", line.Stream, line.Msg)
+			default:
+				fmt.Println(line.Msg)
+			}
+		}
+
+		if res.Result.Next != nil {
+			fmt.Fprintf(os.Stderr, "There may be more lines, continue with --after %s\n", *res.Result.Next)
+		}
+	})
+}
+
+func PruneLogs(cli *LogsPrune) {
+	client, err := DialService()
+	if err != nil {
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
+	}
+	defer client.Close()
+
+	req := &api.RequestPruneLogsParams{
+		OlderThan: cli.OlderThan,
+		DryRun:    cli.DryRun,
+	}
+	SendRequest(client, 1, req)
+	res, _ := ReadResponse(client)
+
+	if res.Result == nil || res.Result.PrunedFiles == nil {
+		Fail(ErrorGeneric, "Invalid response: no pruned files returned")
+	}
+
+	var paths []string
+	for _, f := range *res.Result.PrunedFiles {
+		paths = append(paths, f.Path)
+	}
+	printResult(res.Result, paths, func(bool) {
+		var size int64
+		for _, f := range *res.Result.PrunedFiles {
+			fmt.Println(f.Path)
+			size += f.Size
+		}
+
+		count := len(*res.Result.PrunedFiles)
+		if cli.DryRun {
+			fmt.Printf("Would remove %d log files, reclaiming %s\n", count, formatBytes(size))
+		} else {
+			fmt.Printf("Removed %d log files, reclaimed %s\n", count, formatBytes(size))
+		}
+	})
+}
+
+// formatBytes renders a size with a binary unit, e.g. "1.5 MiB".
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
 func SaveProcessList(cli *Save) {
 	client, err := DialService()
 	if err != nil {
-		log.Fatalf("Could not connect to service: %v", err)
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
 	}
 	defer client.Close()
 
@@ -202,38 +403,40 @@ func SaveProcessList(cli *Save) {
 	res, _ := ReadResponse(client)
 
 	if res.Result == nil || res.Result.SaveEntries == nil {
-		log.Fatalf("Invalid response: no save entries returned")
+		Fail(ErrorGeneric, "Invalid response: no save entries returned")
 	}
 
 	data, err := yaml.Marshal(res.Result.SaveEntries)
 	if err != nil {
-		log.Fatalf("Could not serialize process list: %v", err)
+		Fail(ErrorGeneric, "Could not serialize process list: %v", err)
 	}
 
 	err = os.WriteFile(cli.File, data, 0600)
 	if err != nil {
-		log.Fatalf("Could not write file %s: %v", cli.File, err)
+		Fail(ErrorGeneric, "Could not write file %s: %v", cli.File, err)
 	}
 
-	fmt.Printf("Process list saved to %s\n", cli.File)
+	printResult(res.Result, []string{cli.File}, func(bool) {
+		fmt.Printf("Process list saved to %s\n", cli.File)
+	})
 }
 
 func RestoreProcessList(cli *Restore) {
 	f, err := os.Open(cli.File)
 	if err != nil {
-		log.Fatalf("Could not open file %s: %v", cli.File, err)
+		Fail(ErrorInvalid, "Could not open file %s: %v", cli.File, err)
 	}
 	defer f.Close()
 
 	var entries []api.SaveEntry
 	decoder := yaml.NewDecoder(f)
 	if err := decoder.Decode(&entries); err != nil {
-		log.Fatalf("Could not parse file %s: %v", cli.File, err)
+		Fail(ErrorInvalid, "Could not parse file %s: %v", cli.File, err)
 	}
 
 	client, err := DialService()
 	if err != nil {
-		log.Fatalf("Could not connect to service: %v", err)
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
 	}
 	defer client.Close()
 
@@ -243,20 +446,24 @@ func RestoreProcessList(cli *Restore) {
 	SendRequest(client, 1, req)
 	res, _ := ReadResponse(client)
 
-	if res.Result != nil && res.Result.Success != nil {
+	printResult(res.Result, []string{cli.File}, func(bool) {
 		fmt.Printf("Process list restored from %s\n", cli.File)
-	}
+	})
 }
 
 func SendRequest(client *ServiceConnection, msgID int, req api.RequestParams) error {
-	bReq, err := api.NewRequest(1, req)
+	if err := client.checkMethod(req.Type()); err != nil {
+		Fail(ErrorUnsupported, "%v", err)
+	}
+
+	bReq, err := api.NewRequest(msgID, req)
 	if err != nil {
 		panic("Could not build request")
 	}
 
 	err = client.WriteMsg(bReq)
 	if err != nil {
-		log.Fatalf("Unknown writing to server: %v", err)
+		Fail(ErrorUnavailable, "Could not write to service: %v", err)
 	}
 
 	return err
@@ -266,18 +473,60 @@ func ReadResponse(client *ServiceConnection) (api.Response, error) {
 	data, err := client.ReadMsg()
 
 	if err != nil {
-		log.Fatalf("Unknown error reading from server: %v", err)
+		Fail(ErrorUnavailable, "Could not read from service: %v", err)
 	}
 
 	res, err := api.UnmarshalResponse(data)
 
 	if err != nil {
-		log.Fatalf("Could not decode response: %v", err)
+		Fail(ErrorGeneric, "Could not decode response: %v", err)
 	}
 
 	if res.Error != nil {
-		log.Fatalf("Error while doing. %d %s", res.Error.Code, res.Error.Message)
+		if res.Error.Code == int(api.MethodNotFound) && client.Info == nil {
+			Fail(ErrorUnsupported, "The service is older than this jpm and does not support the command. %s", upgradeHint())
+		}
+		failResponse(res.Error)
 	}
 
 	return res, err
 }
+
+// versionInfo is what jpm version prints with structured output. Service
+// is nil when it is not running or too old to tell its version.
+type versionInfo struct {
+	Client struct {
+		Version    string `json:"version"`
+		APIVersion int    `json:"apiVersion"`
+	} `json:"client"`
+	Service *api.ServiceInfo `json:"service"`
+}
+
+// ShowVersion prints the versions of this jpm and of the service.
+func ShowVersion() {
+	var v versionInfo
+	v.Client.Version = api.BuildVersion()
+	v.Client.APIVersion = api.APIVersion
+
+	client, err := DialService()
+	if err == nil {
+		v.Service = client.Info
+		client.Close()
+	}
+
+	printResult(v, []string{v.Client.Version}, func(bool) {
+		fmt.Printf("Client:  jpm %s (API %d)\n", v.Client.Version, v.Client.APIVersion)
+		switch {
+		case err != nil:
+			fmt.Printf("Service: not running (instance %s)\n", api.Instance())
+		case v.Service == nil:
+			fmt.Printf("Service: older than jpm %s, its version is unknown. %s\n", api.BuildVersion(), upgradeHint())
+		default:
+			info := v.Service
+			fmt.Printf("Service: jpm %s (API %d, instance %s)\n", info.Version, info.APIVersion, info.Instance)
+			if len(info.Features) > 0 {
+				fmt.Printf("Features: %s\n", strings.Join(info.Features, ", "))
+			}
+		}
+	})
+}
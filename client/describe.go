@@ -0,0 +1,159 @@
+package client
+
+import (
+	"fmt"
+	"jstarpl/jpm/api"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+type Describe struct {
+	Target string `arg:"" help:"Id of the process, or a name, namespace or 'all' to describe all processes it selects."`
+}
+
+func DescribeProcess(cli *Describe) {
+	client, err := DialService()
+	if err != nil {
+		Fail(ErrorUnavailable, "Could not connect to service: %v", err)
+	}
+	defer client.Close()
+
+	req := &api.RequestDescribeProcessParams{}
+	if _, err := strconv.Atoi(cli.Target); err == nil {
+		req.Id = cli.Target
+	} else {
+		req.Query = cli.Target
+	}
+	SendRequest(client, 1, req)
+	res, _ := ReadResponse(client)
+
+	if res.Result == nil || res.Result.ProcessDetails == nil {
+		Fail(ErrorGeneric, "Invalid response: no process details returned")
+	}
+
+	details := *res.Result.ProcessDetails
+	var ids []string
+	for _, detail := range details {
+		ids = append(ids, detail.Id)
+	}
+	printResult(res.Result, ids, func(bool) {
+		for i, detail := range details {
+			if i > 0 {
+				fmt.Println()
+			}
+			printProcessDetail(detail)
+		}
+	})
+}
+
+// printProcessDetail prints the details of a process as a list of fields,
+// followed by its environment, log files and exits.
+func printProcessDetail(p api.ProcessDetail) {
+	field := func(name string, value any) {
+		fmt.Printf("%-16s %v\n", name+":", value)
+	}
+
+	field("Id", p.Id)
+	if p.Name != "" {
+		field("Name", p.Name)
+	}
+	if p.Namespace != "" {
+		field("Namespace", p.Namespace)
+	}
+	field("Status", p.Status)
+	field("Health", p.Health)
+	field("Command", commandLine(p.Exec, p.Arg))
+	if p.Dir != "" {
+		field("Directory", p.Dir)
+	}
+	field("Restart policy", p.Restart)
+	if p.Pid != 0 {
+		pid := strconv.Itoa(p.Pid)
+		if p.Pgid != 0 {
+			pid += fmt.Sprintf(" (process group %d)", p.Pgid)
+		}
+		field("PID", pid)
+	}
+	if !p.LastStarted.IsZero() {
+		field("Last started", formatTime(p.LastStarted))
+	}
+	if !p.LastExited.IsZero() {
+		field("Last exited", formatTime(p.LastExited))
+	}
+	if p.Uptime > 0 {
+		field("Uptime", formatDuration(p.Uptime))
+	}
+	field("Starts", fmt.Sprintf("%d, %d failed", p.StartCount, p.FailCount))
+	if p.RespawnDelay > 0 {
+		field("Respawn delay", formatDuration(p.RespawnDelay))
+	}
+	if p.OutputUnavailable {
+		field("Output", "unavailable until the process is restarted")
+	}
+	if p.LoggingDegraded != "" {
+		field("Logging", "degraded: "+p.LoggingDegraded)
+	}
+
+	fmt.Println("Environment:")
+	if len(p.Env) == 0 {
+		fmt.Println("  (inherited from the service)")
+	}
+	for _, variable := range p.Env {
+		fmt.Printf("  %s\n", variable)
+	}
+
+	fmt.Println("Log files:")
+	switch {
+	case p.LogDisabled:
+		fmt.Println("  (disabled)")
+	case len(p.LogFiles) == 0:
+		fmt.Println("  (none)")
+	}
+	for _, file := range p.LogFiles {
+		fmt.Printf("  %s\n", file)
+	}
+
+	fmt.Println("Exits:")
+	if len(p.Exits) == 0 {
+		fmt.Println("  (none)")
+		return
+	}
+	tw := table.NewWriter()
+	tw.AppendHeader(table.Row{"Time", "Reason", "Code", "Signal", "Uptime"})
+	for _, exit := range p.Exits {
+		tw.AppendRow(table.Row{formatTime(exit.Time), exit.Reason, exit.ExitCode, exit.Signal, formatDuration(exit.Uptime)})
+	}
+	tw.SetStyle(table.StyleRounded)
+	fmt.Println(tw.Render())
+}
+
+// commandLine renders a command with its arguments, quoting the ones that
+// would not read back as a single argument.
+func commandLine(exec string, args []string) string {
+	words := []string{exec}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$") {
+			arg = strconv.Quote(arg)
+		}
+		words = append(words, arg)
+	}
+	return strings.Join(words, " ")
+}
+
+// formatTime renders a time in the local time zone, with how long ago it was.
+func formatTime(t time.Time) string {
+	ago := time.Since(t).Round(time.Second)
+	return fmt.Sprintf("%s (%s ago)", t.Local().Format(time.DateTime), ago)
+}
+
+// formatDuration renders milliseconds as a duration, like "1m30s".
+func formatDuration(ms int) string {
+	d := time.Duration(ms) * time.Millisecond
+	if d >= time.Second {
+		d = d.Round(time.Second)
+	}
+	return d.String()
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"jstarpl/jpm/api"
+	"net/http"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Exit codes of jpm, so that scripts can tell failures apart.
+const (
+	// ExitError is for failures without a code of their own.
+	ExitError = 1
+	// ExitInvalid is for invalid arguments, flags or files.
+	ExitInvalid = 2
+	// ExitNotFound is for ids and names no process has.
+	ExitNotFound = 3
+	// ExitConflict is for processes in a state the command does not apply
+	// to, like stopping a process that is not running.
+	ExitConflict = 4
+	// ExitUnavailable is for a service that is not running or stopped
+	// answering.
+	ExitUnavailable = 5
+	// ExitUnsupported is for commands the service is too old for.
+	ExitUnsupported = 6
+	// ExitUnauthorized is for a service refusing the token or the user.
+	ExitUnauthorized = 7
+)
+
+// ErrorKind classifies why a command failed, it decides the exit code.
+type ErrorKind string
+
+const (
+	ErrorGeneric      ErrorKind = "error"
+	ErrorInvalid      ErrorKind = "invalid"
+	ErrorNotFound     ErrorKind = "notFound"
+	ErrorConflict     ErrorKind = "conflict"
+	ErrorUnavailable  ErrorKind = "unavailable"
+	ErrorUnsupported  ErrorKind = "unsupported"
+	ErrorUnauthorized ErrorKind = "unauthorized"
+)
+
+var exitCodes = map[ErrorKind]int{
+	ErrorGeneric:      ExitError,
+	ErrorInvalid:      ExitInvalid,
+	ErrorNotFound:     ExitNotFound,
+	ErrorConflict:     ExitConflict,
+	ErrorUnavailable:  ExitUnavailable,
+	ErrorUnsupported:  ExitUnsupported,
+	ErrorUnauthorized: ExitUnauthorized,
+}
+
+// ExitCode is the exit code of jpm for errors of the kind.
+func (k ErrorKind) ExitCode() int {
+	if code, ok := exitCodes[k]; ok {
+		return code
+	}
+	return ExitError
+}
+
+// CommandError is why a command failed, as printed with structured output.
+type CommandError struct {
+	Kind ErrorKind `json:"kind" yaml:"kind"`
+	// Code is the code of the error response of the service, 0 for errors
+	// of jpm itself.
+	Code    int    `json:"code,omitempty" yaml:"code,omitempty"`
+	Message string `json:"message" yaml:"message"`
+}
+
+// errorKind classifies the code of an error response of the service. The
+// service answers with HTTP statuses, over the socket too, and JSON-RPC
+// codes for malformed requests.
+func errorKind(code int) ErrorKind {
+	switch code {
+	case http.StatusBadRequest, int(api.InvalidParams), int(api.InvalidRequest), int(api.ParseError):
+		return ErrorInvalid
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrorUnauthorized
+	case http.StatusNotFound:
+		return ErrorNotFound
+	case http.StatusConflict:
+		return ErrorConflict
+	case http.StatusServiceUnavailable:
+		return ErrorUnavailable
+	case int(api.MethodNotFound):
+		return ErrorUnsupported
+	}
+	return ErrorGeneric
+}
+
+// Fail prints an error and exits jpm with the exit code of its kind.
+func Fail(kind ErrorKind, format string, args ...any) {
+	failWith(CommandError{Kind: kind, Message: fmt.Sprintf(format, args...)})
+}
+
+// failResponse prints an error response of the service and exits.
+func failResponse(e *api.ResponseError) {
+	failWith(CommandError{Kind: errorKind(e.Code), Code: e.Code, Message: e.Message})
+}
+
+func failWith(e CommandError) {
+	switch {
+	case output.Format == "yaml":
+		data, _ := yaml.Marshal(map[string]CommandError{"error": e})
+		os.Stderr.Write(data)
+	case output.structured():
+		data, _ := json.Marshal(map[string]CommandError{"error": e})
+		fmt.Fprintln(os.Stderr, string(data))
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %s\n", e.Message)
+	}
+	os.Exit(e.Kind.ExitCode())
+}
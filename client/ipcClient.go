@@ -1,15 +1,28 @@
 package client
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
 	"jstarpl/jpm/api"
+	"net"
+	"os"
 	"time"
 
 	ipc "github.com/james-barrow/golang-ipc"
 )
 
+// ServiceConnection is a session with the service, over its socket or, with
+// services of earlier versions, the `jpm-ipc` channel.
 type ServiceConnection struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
 	client *ipc.Client
+
+	// Info describes the service, nil for services that predate
+	// getServiceInfo.
+	Info *api.ServiceInfo
 }
 
 const (
@@ -22,8 +35,41 @@ var (
 	ErrInvalidMessage           = errors.New("could not read from service connection")
 )
 
+// DialService connects to the service and finds out what it supports.
 func DialService() (*ServiceConnection, error) {
-	client, err := ipc.StartClient(api.IPCName, nil)
+	c, err := dialService()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.negotiate(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func dialService() (*ServiceConnection, error) {
+	conn, err := net.DialTimeout("unix", api.SocketPath(), serviceConnectionTimeout)
+	if err == nil {
+		return &ServiceConnection{
+			conn:   conn,
+			reader: bufio.NewReader(conn),
+		}, nil
+	}
+
+	// Services of earlier versions only serve the default instance.
+	if !api.IsDefaultInstance() || api.SocketPath() != api.DefaultSocketPath() {
+		return nil, ErrServiceConnection
+	}
+	return dialLegacyService()
+}
+
+func dialLegacyService() (*ServiceConnection, error) {
+	client, err := ipc.StartClient(api.IPCName, &ipc.ClientConfig{
+		Timeout:    serviceConnectionTimeout.Seconds(),
+		RetryTimer: 1,
+		Encryption: true,
+	})
 	if err != nil {
 		return nil, ErrServiceConnection
 	}
@@ -51,10 +97,22 @@ func DialService() (*ServiceConnection, error) {
 }
 
 func (c *ServiceConnection) WriteMsg(message []byte) error {
+	if c.conn != nil {
+		_, err := c.conn.Write(append(message, '\n'))
+		return err
+	}
 	return c.client.Write(api.MsgType, message)
 }
 
 func (c *ServiceConnection) ReadMsg() ([]byte, error) {
+	if c.conn != nil {
+		line, err := c.reader.ReadBytes('\n')
+		if err != nil {
+			return line, ErrInvalidMessage
+		}
+		return line, nil
+	}
+
 	for {
 		msg, err := c.client.Read()
 		if err != nil {
@@ -67,5 +125,68 @@ func (c *ServiceConnection) ReadMsg() ([]byte, error) {
 }
 
 func (c *ServiceConnection) Close() {
+	if c.conn != nil {
+		c.conn.Close()
+		return
+	}
 	c.client.Close()
 }
+
+// negotiate asks the service what it supports and warns if its API version
+// is not the one of this client.
+func (c *ServiceConnection) negotiate() error {
+	req, err := api.NewRequest(0, &api.RequestGetServiceInfoParams{})
+	if err != nil {
+		return err
+	}
+	if err := c.WriteMsg(req); err != nil {
+		return ErrServiceConnection
+	}
+	data, err := c.ReadMsg()
+	if err != nil {
+		return err
+	}
+	res, err := api.UnmarshalResponse(data)
+	if err != nil {
+		return ErrInvalidMessage
+	}
+
+	// Services of earlier versions answer Method not found.
+	if res.Error != nil || res.Result == nil || res.Result.ServiceInfo == nil {
+		return nil
+	}
+	c.Info = res.Result.ServiceInfo
+	if warning := compatibilityWarning(*c.Info); warning != "" {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+	return nil
+}
+
+// checkMethod fails if the service is known not to support the method.
+func (c *ServiceConnection) checkMethod(method api.MethodName) error {
+	if c.Info == nil || c.Info.Supports(method) {
+		return nil
+	}
+	return fmt.Errorf("the service runs jpm %s, which does not support %s. %s", c.Info.Version, method, upgradeHint())
+}
+
+func compatibilityWarning(info api.ServiceInfo) string {
+	switch {
+	case info.APIVersion < api.APIVersion:
+		return fmt.Sprintf("Warning: the service runs jpm %s (API %d), older than this jpm %s (API %d). %s",
+			info.Version, info.APIVersion, api.BuildVersion(), api.APIVersion, upgradeHint())
+	case info.APIVersion > api.APIVersion:
+		return fmt.Sprintf("Warning: the service runs jpm %s (API %d), newer than this jpm %s (API %d). Update this jpm binary to use all its features.",
+			info.Version, info.APIVersion, api.BuildVersion(), api.APIVersion)
+	}
+	return ""
+}
+
+// upgradeHint tells how to run the service with this binary.
+func upgradeHint() string {
+	exec, err := os.Executable()
+	if err != nil {
+		return "Upgrade it with `jpm service upgrade --exec <path of the new jpm>`."
+	}
+	return fmt.Sprintf("Upgrade it with `%s service upgrade --exec %s`.", exec, exec)
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// jqQuery is a jq program of paths joined by pipes, like
+// '.processList[] | .id'. Paths are made of fields like '.name' or
+// '.["a b"]', indexes like '.[0]' or '.[-1]' and iterations '.[]'.
+type jqQuery [][]jqStep
+
+type jqStep struct {
+	field   string
+	index   int
+	isIndex bool
+	iterate bool
+}
+
+func parseJq(expr string) (jqQuery, error) {
+	var q jqQuery
+	for _, term := range splitJqTerms(expr) {
+		path, err := parseJqPath(strings.TrimSpace(term))
+		if err != nil {
+			return nil, err
+		}
+		q = append(q, path)
+	}
+	return q, nil
+}
+
+// splitJqTerms splits expr at the pipes that are neither in brackets nor in
+// quoted field names.
+func splitJqTerms(expr string) []string {
+	var terms []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == '|' && depth == 0:
+			terms = append(terms, expr[start:i])
+			start = i + 1
+		}
+	}
+	return append(terms, expr[start:])
+}
+
+// closingBracket returns the index of the bracket closing the one s starts
+// with, skipping quoted field names, or -1 if it is not closed.
+func closingBracket(s string) int {
+	quoted := false
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == ']' && !quoted:
+			return i
+		}
+	}
+	return -1
+}
+
+func parseJqPath(s string) ([]jqStep, error) {
+	if !strings.HasPrefix(s, ".") {
+		return nil, fmt.Errorf("%q does not start with a dot", s)
+	}
+
+	var steps []jqStep
+	i := 1
+	for i < len(s) {
+		if s[i] == '.' {
+			i++
+			if i == len(s) || s[i] == '.' {
+				return nil, fmt.Errorf("%q has an empty field", s)
+			}
+			continue
+		}
+
+		if s[i] == '[' {
+			end := closingBracket(s[i:])
+			if end < 0 {
+				return nil, fmt.Errorf("%q has an unclosed bracket", s)
+			}
+			inner := strings.TrimSpace(s[i+1 : i+end])
+			i += end + 1
+
+			switch {
+			case inner == "":
+				steps = append(steps, jqStep{iterate: true})
+			case strings.HasPrefix(inner, `"`):
+				field, err := strconv.Unquote(inner)
+				if err != nil {
+					return nil, fmt.Errorf("%q has an invalid field name %s", s, inner)
+				}
+				steps = append(steps, jqStep{field: field})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("%q has an invalid index %s", s, inner)
+				}
+				steps = append(steps, jqStep{index: index, isIndex: true})
+			}
+			continue
+		}
+
+		start := i
+		for i < len(s) && (s[i] == '_' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= 'A' && s[i] <= 'Z' || s[i] >= '0' && s[i] <= '9') {
+			i++
+		}
+		if i == start {
+			return nil, fmt.Errorf("%q has an unexpected %q", s, s[i])
+		}
+		steps = append(steps, jqStep{field: s[start:i]})
+	}
+	return steps, nil
+}
+
+// eval runs the query on a value decoded from JSON, returning the values it
+// selects.
+func (q jqQuery) eval(v any) ([]any, error) {
+	values := []any{v}
+	for _, path := range q {
+		for _, step := range path {
+			var next []any
+			for _, value := range values {
+				selected, err := step.apply(value)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, selected...)
+			}
+			values = next
+		}
+	}
+	return values, nil
+}
+
+func (s jqStep) apply(v any) ([]any, error) {
+	switch v := v.(type) {
+	case nil:
+		if s.iterate {
+			return nil, fmt.Errorf("cannot iterate over null")
+		}
+		return []any{nil}, nil
+	case map[string]any:
+		switch {
+		case s.iterate:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			slices.Sort(keys)
+			values := make([]any, len(keys))
+			for i, key := range keys {
+				values[i] = v[key]
+			}
+			return values, nil
+		case s.isIndex:
+			return nil, fmt.Errorf("cannot index an object with a number")
+		}
+		return []any{v[s.field]}, nil
+	case []any:
+		switch {
+		case s.iterate:
+			return v, nil
+		case s.isIndex:
+			index := s.index
+			if index < 0 {
+				index += len(v)
+			}
+			if index < 0 || index >= len(v) {
+				return []any{nil}, nil
+			}
+			return []any{v[index]}, nil
+		}
+		return nil, fmt.Errorf("cannot index an array with %q", s.field)
+	}
+	return nil, fmt.Errorf("cannot index %v", v)
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"encoding/json"
+	"jstarpl/jpm/api"
+	"reflect"
+	"testing"
+)
+
+func TestJqQuery(t *testing.T) {
+	var doc any
+	json.Unmarshal([]byte(`{
+		"processList": [
+			{"id": "0", "name": "web", "env": {"PORT": "80"}},
+			{"id": "1", "name": "worker", "env": null}
+		],
+		"a b": 2,
+		"a|b": 3,
+		"a]b": 4
+	}`), &doc)
+
+	tests := []struct {
+		expr string
+		want []any
+	}{
+		{".", []any{doc}},
+		{".processList[].id", []any{"0", "1"}},
+		{".processList | .[] | .name", []any{"web", "worker"}},
+		{".processList[-1].name", []any{"worker"}},
+		{".processList[5]", []any{nil}},
+		{".processList[0].env.PORT", []any{"80"}},
+		{".processList[1].env.PORT", []any{nil}},
+		{`.["a b"]`, []any{float64(2)}},
+		{`.["a|b"]`, []any{float64(3)}},
+		{`. | .["a|b"] | .`, []any{float64(3)}},
+		{`.["a]b"]`, []any{float64(4)}},
+		{".missing", []any{nil}},
+	}
+	for _, test := range tests {
+		q, err := parseJq(test.expr)
+		if err != nil {
+			t.Errorf("parseJq(%q): %v", test.expr, err)
+			continue
+		}
+		got, err := q.eval(doc)
+		if err != nil {
+			t.Errorf("eval(%q): %v", test.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("eval(%q) = %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestJqQuery_Errors(t *testing.T) {
+	for _, expr := range []string{"", "id", ".a..b", ".a.", ".[0", `.["a]`, ".[x]", ".a-b"} {
+		if _, err := parseJq(expr); err == nil {
+			t.Errorf("parseJq(%q) succeeded", expr)
+		}
+	}
+
+	var doc any
+	json.Unmarshal([]byte(`{"list": [1], "none": null}`), &doc)
+	for _, expr := range []string{".list.name", ".[0]", ".none[]", ".list[0].x"} {
+		q, err := parseJq(expr)
+		if err != nil {
+			t.Fatalf("parseJq(%q): %v", expr, err)
+		}
+		if _, err := q.eval(doc); err == nil {
+			t.Errorf("eval(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := map[int]ErrorKind{
+		400:                     ErrorInvalid,
+		int(api.InvalidParams):  ErrorInvalid,
+		401:                     ErrorUnauthorized,
+		404:                     ErrorNotFound,
+		409:                     ErrorConflict,
+		int(api.MethodNotFound): ErrorUnsupported,
+		500:                     ErrorGeneric,
+		503:                     ErrorUnavailable,
+		int(api.ServerError):    ErrorGeneric,
+	}
+	for code, want := range tests {
+		if got := errorKind(code); got != want {
+			t.Errorf("errorKind(%d) = %s, want %s", code, got, want)
+		}
+	}
+	if ErrorKind("unknown").ExitCode() != ExitError {
+		t.Errorf("unknown kinds should exit with %d", ExitError)
+	}
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output are the flags of all commands choosing how they print results.
+// Structured formats print the result of the service as described by its
+// OpenAPI specification.
+type Output struct {
+	Format   string `name:"output" short:"o" env:"JPM_OUTPUT" enum:"table,wide,json,yaml,name" default:"table" help:"Output format: table, wide, json, yaml or name (only the ids)."`
+	Jq       string `name:"jq" help:"Print what a jq path like '.processList[].id' selects from the JSON output, strings without quotes."`
+	Template string `name:"template" help:"Format the JSON output with a Go template, like '{{range .processList}}{{.id}} {{end}}'."`
+
+	jq       jqQuery
+	template *template.Template
+}
+
+// output is how the commands print their results.
+var output = Output{Format: "table"}
+
+// SetOutput checks the output flags and applies them to all commands.
+func SetOutput(o Output) {
+	output.Format = o.Format
+	if o.Jq != "" && o.Template != "" {
+		Fail(ErrorInvalid, "--jq and --template can't be used together")
+	}
+	if o.Jq != "" {
+		q, err := parseJq(o.Jq)
+		if err != nil {
+			Fail(ErrorInvalid, "Invalid --jq path: %v", err)
+		}
+		o.jq = q
+	}
+	if o.Template != "" {
+		t, err := template.New("output").Parse(o.Template)
+		if err != nil {
+			Fail(ErrorInvalid, "Invalid --template: %v", err)
+		}
+		o.template = t
+	}
+	output = o
+}
+
+// structured tells if the output is meant for programs rather than people.
+func (o Output) structured() bool {
+	return o.Format == "json" || o.Format == "yaml" || o.jq != nil || o.template != nil
+}
+
+// PrintResult prints a result that does not come from the service, like the
+// configuration of the service, as printResult does.
+func PrintResult(result any, names []string, human func(wide bool)) {
+	printResult(result, names, human)
+}
+
+// printResult prints the result of a command. Structured formats print
+// result, name prints names, one per line, and the others call human, with
+// wide for the wide format.
+func printResult(result any, names []string, human func(wide bool)) {
+	switch {
+	case output.jq != nil:
+		values, err := output.jq.eval(jsonValue(result))
+		if err != nil {
+			Fail(ErrorInvalid, "Could not apply --jq: %v", err)
+		}
+		for _, v := range values {
+			printJqValue(v)
+		}
+		return
+	case output.template != nil:
+		if err := output.template.Execute(os.Stdout, jsonValue(result)); err != nil {
+			Fail(ErrorInvalid, "Could not apply --template: %v", err)
+		}
+		return
+	}
+
+	switch output.Format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	case "yaml":
+		data, err := yaml.Marshal(jsonValue(result))
+		if err != nil {
+			Fail(ErrorGeneric, "Could not serialize the result: %v", err)
+		}
+		os.Stdout.Write(data)
+	case "name":
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	default:
+		human(output.Format == "wide")
+	}
+}
+
+// jsonValue converts result to the maps, slices and scalars it is in JSON,
+// so that the field names are the ones of the API in every format.
+func jsonValue(result any) any {
+	data, err := json.Marshal(result)
+	if err != nil {
+		Fail(ErrorGeneric, "Could not serialize the result: %v", err)
+	}
+	var v any
+	json.Unmarshal(data, &v)
+	return v
+}
+
+// printJqValue prints strings as they are and other values as JSON, like
+// jq --raw-output.
+func printJqValue(v any) {
+	if s, ok := v.(string); ok {
+		fmt.Println(s)
+		return
+	}
+	data, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(data))
+}
@@ -1,36 +1,77 @@
 package main
 
 import (
+	"jstarpl/jpm/api"
 	"jstarpl/jpm/client"
 	"jstarpl/jpm/service"
 	"log"
+	"os"
 
 	"github.com/alecthomas/kong"
 )
 
 type CLI struct {
+	service.Globals `embed:""`
+	client.Output   `embed:""`
+
 	Service service.Service `cmd:"" help:"Manage the JPM service."`
 
-	Ps      client.Ps      `cmd:"" help:"List running processes." aliases:"list,ls"`
-	Start   client.Start   `cmd:"" help:"Start a new process." aliases:"add"`
-	Stop    client.Stop    `cmd:"" help:"Stop specified process"`
-	Restart client.Restart `cmd:"" help:"Restart an existing process"`
-	Delete  client.Delete  `cmd:"" help:"Delete specified process (implies 'stop')" aliases:"del,rm"`
-	Save    client.Save    `cmd:"" help:"Save the process list to a YAML file"`
-	Restore client.Restore `cmd:"" help:"Restore processes from a YAML dump file"`
+	Ps       client.Ps       `cmd:"" help:"List running processes." aliases:"list,ls"`
+	Start    client.Start    `cmd:"" help:"Start a new process." aliases:"add"`
+	Stop     client.Stop     `cmd:"" help:"Stop specified process"`
+	Restart  client.Restart  `cmd:"" help:"Restart an existing process"`
+	Delete   client.Delete   `cmd:"" help:"Delete specified process (implies 'stop')" aliases:"del,rm"`
+	Describe client.Describe `cmd:"" help:"Show everything about a process, like its exits and log files"`
+	Signal   client.Signal   `cmd:"" help:"Send a signal to a process, or to all processes a name or namespace selects"`
+	Logs     client.Logs     `cmd:"" help:"Search or prune process log files"`
+	Attach   client.Attach   `cmd:"" help:"Attach the console to the input and output of a process"`
+	Save     client.Save     `cmd:"" help:"Save the process list to a YAML file"`
+	Restore  client.Restore  `cmd:"" help:"Restore processes from a YAML dump file"`
+	Version  client.Version  `cmd:"" help:"Show the version of jpm and of the service"`
+}
+
+const exitCodesHelp = `Exit codes: 1 error, 2 invalid arguments, 3 process not found, 4 process in the wrong state, 5 service unavailable, 6 not supported by the service, 7 not authorized.`
+
+// exit ends jpm, with the exit code of jpm for invalid arguments when Kong
+// rejects them.
+func exit(code int) {
+	if code == kongUsageError {
+		code = client.ExitInvalid
+	}
+	os.Exit(code)
 }
 
+// kongUsageError is the exit code Kong uses for invalid arguments.
+const kongUsageError = 80
+
 func main() {
 	initConsole()
 
+	if err := api.SetInstance(service.InstanceArg(os.Args[1:])); err != nil {
+		client.Fail(client.ErrorInvalid, "%v", err)
+	}
+
 	var cli CLI
-	ctx := kong.Parse(&cli)
+	ctx := kong.Parse(&cli,
+		kong.Description(exitCodesHelp),
+		kong.Configuration(service.ConfigLoader, service.ConfigPath()),
+		service.KongVars(),
+		kong.Exit(exit),
+	)
+	api.SetSocketPath(cli.Socket)
+	client.SetOutput(cli.Output)
 
 	switch ctx.Command() {
 	case "service start":
 		service.StartService(&cli.Service)
 	case "service stop":
 		client.RequestStopService()
+	case "service upgrade":
+		client.RequestUpgradeService(cli.Service.Upgrade.Exec)
+	case "service reload":
+		client.RequestReloadService()
+	case "service config":
+		service.PrintConfig(&cli.Service)
 	case "ps":
 		client.ListProcesses(&cli.Ps)
 	case "start <args>":
@@ -41,10 +82,22 @@ func main() {
 		client.RestartProcess(&cli.Restart)
 	case "delete <id>":
 		client.DeleteProcess(&cli.Delete)
+	case "describe <target>":
+		client.DescribeProcess(&cli.Describe)
+	case "signal <target> <signal>":
+		client.SignalProcess(&cli.Signal)
+	case "logs search <id>":
+		client.QueryLogs(&cli.Logs.Search)
+	case "logs prune":
+		client.PruneLogs(&cli.Logs.Prune)
+	case "attach <id>":
+		client.AttachProcess(&cli.Attach)
 	case "save <file>":
 		client.SaveProcessList(&cli.Save)
 	case "restore <file>":
 		client.RestoreProcessList(&cli.Restore)
+	case "version":
+		client.ShowVersion()
 	default:
 		log.Default().Printf("Unknown command %s", ctx.Command())
 		panic(ctx.Error)
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/client"
+	"jstarpl/jpm/service/logger"
+	"jstarpl/jpm/service/logsink"
+	"jstarpl/jpm/service/notifier"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigPath returns the service configuration file path, honouring
+// JPM_CONFIG. Without it the file is config.yaml in the home of the instance.
+func ConfigPath() string {
+	if path := os.Getenv("JPM_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(api.Home(), "config.yaml")
+}
+
+// InstanceArg returns the instance selected with --instance in args or with
+// JPM_INSTANCE. It is needed to find the configuration file, before the
+// command line is parsed.
+func InstanceArg(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--instance" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if value, ok := strings.CutPrefix(arg, "--instance="); ok {
+			return value
+		}
+	}
+	return os.Getenv("JPM_INSTANCE")
+}
+
+// ConfigLoader is a kong.ConfigurationLoader reading flag values from a YAML
+// file. Keys are flag names in snake_case, e.g. "log_retention_days".
+//
+// Only settings of the service are read, flags with a yaml tag. Commands
+// like jpm start have flags of the same names that are meant for a single
+// process, they must not take the values of the service.
+//
+// Kong applies env vars as if they were defaults, so values from the file
+// would normally win over them. To keep the flag > env > file precedence the
+// resolver ignores flags whose env var is set.
+func ConfigLoader(r io.Reader) (kong.Resolver, error) {
+	values := map[string]any{}
+	if err := yaml.NewDecoder(r).Decode(&values); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var f kong.ResolverFunc = func(context *kong.Context, parent *kong.Path, flag *kong.Flag) (any, error) {
+		for _, env := range flag.Envs {
+			if _, ok := os.LookupEnv(env); ok {
+				return nil, nil
+			}
+		}
+
+		// The instance selects the file, it can't be set in it.
+		if !flag.Tag.Has("yaml") || flag.Name == "instance" {
+			return nil, nil
+		}
+
+		raw, ok := values[strings.ReplaceAll(flag.Name, "-", "_")]
+		if !ok {
+			return nil, nil
+		}
+
+		// Nested sections, like an inline "notifications" block, are not flag values.
+		if _, isMap := raw.(map[string]any); isMap {
+			return nil, nil
+		}
+		if raw == nil {
+			return nil, nil
+		}
+
+		return raw, nil
+	}
+
+	return f, nil
+}
+
+// ByteSize is a size in bytes that can be given with a binary unit suffix,
+// e.g. "512K", "100M" or "1G".
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"", 1},
+}
+
+// ParseByteSize parses a size like "100M". The suffix may be followed by "B"
+// or "iB" and is case insensitive.
+func ParseByteSize(s string) (ByteSize, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+
+	for _, unit := range byteSizeUnits {
+		if !strings.HasSuffix(str, unit.suffix) {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(str, unit.suffix)), 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid size %q", s)
+		}
+		return ByteSize(n * unit.multiplier), nil
+	}
+	return 0, fmt.Errorf("invalid size %q", s)
+}
+
+func (b ByteSize) String() string {
+	for _, unit := range byteSizeUnits {
+		if b != 0 && int64(b)%unit.multiplier == 0 {
+			return fmt.Sprintf("%d%s", int64(b)/unit.multiplier, unit.suffix)
+		}
+	}
+	return "0"
+}
+
+// Decode implements kong.MapperValue, accepting numbers from the
+// configuration file as well as strings.
+func (b *ByteSize) Decode(ctx *kong.DecodeContext) error {
+	token := ctx.Scan.Pop()
+	switch v := token.Value.(type) {
+	case string:
+		size, err := ParseByteSize(v)
+		if err != nil {
+			return err
+		}
+		*b = size
+	case int:
+		*b = ByteSize(v)
+	default:
+		return fmt.Errorf("expected a size but got %q", token)
+	}
+	return nil
+}
+
+func (b ByteSize) MarshalYAML() (any, error) {
+	if b == 0 {
+		return 0, nil
+	}
+	return b.String(), nil
+}
+
+// effectiveConfigPath returns the configuration file in use, expanded.
+func effectiveConfigPath(cli *Service) string {
+	if cli.ConfigFile != "" {
+		return kong.ExpandPath(string(cli.ConfigFile))
+	}
+	return kong.ExpandPath(ConfigPath())
+}
+
+// loadNotifications merges the inline "notifications" section of the
+// configuration file with sinks from the file referenced by --notifications.
+func loadNotifications(configPath string, opts *Options) (notifier.Config, error) {
+	var result notifier.Config
+
+	if err := decodeConfigSection(configPath, "notifications", yaml.MappingNode, &result); err != nil {
+		return result, err
+	}
+
+	if opts.Notifications != "" {
+		fromFile, err := notifier.LoadConfig(opts.Notifications)
+		if err != nil {
+			return result, err
+		}
+		result.Webhooks = append(result.Webhooks, fromFile.Webhooks...)
+		if fromFile.Commands.OnStart != "" {
+			result.Commands.OnStart = fromFile.Commands.OnStart
+		}
+		if fromFile.Commands.OnExit != "" {
+			result.Commands.OnExit = fromFile.Commands.OnExit
+		}
+		if fromFile.Commands.OnFail != "" {
+			result.Commands.OnFail = fromFile.Commands.OnFail
+		}
+	}
+
+	return result, nil
+}
+
+// loadLogSinks reads the "log_sinks" section of the configuration file.
+func loadLogSinks(configPath string) ([]logsink.Config, error) {
+	var result []logsink.Config
+	err := decodeConfigSection(configPath, "log_sinks", yaml.SequenceNode, &result)
+	return result, err
+}
+
+// decodeConfigSection decodes the top-level key of the configuration file
+// into out, if it is present and of the given kind.
+func decodeConfigSection(configPath string, key string, kind yaml.Kind, out any) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read config %s: %w", configPath, err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	var sections map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		return fmt.Errorf("could not parse config %s: %w", configPath, err)
+	}
+
+	if node, ok := sections[key]; ok && node.Kind == kind {
+		if err := node.Decode(out); err != nil {
+			return fmt.Errorf("could not parse %s in %s: %w", key, configPath, err)
+		}
+	}
+	return nil
+}
+
+// printedConfig is the effective service configuration as printed by
+// PrintConfig.
+type printedConfig struct {
+	ConfigFile    string `yaml:"config_file"`
+	Options       `yaml:",inline"`
+	Notifications notifier.Config  `yaml:"notifications,omitempty"`
+	LogSinks      []logsink.Config `yaml:"log_sinks,omitempty"`
+}
+
+// effectiveConfig returns the configuration the service would run with,
+// with the token, webhook secrets and log sink headers masked.
+func effectiveConfig(cli *Service) (*printedConfig, error) {
+	opts := cli.Config.Options
+	if err := opts.resolvePaths(); err != nil {
+		return nil, fmt.Errorf("could not resolve config: %w", err)
+	}
+
+	configPath := effectiveConfigPath(cli)
+	notifications, err := loadNotifications(configPath, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	logSinks, err := loadLogSinks(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Token != "" && opts.Token != "<random>" {
+		opts.Token = logger.RedactedText
+	}
+	for i := range notifications.Webhooks {
+		if notifications.Webhooks[i].Secret != "" {
+			notifications.Webhooks[i].Secret = logger.RedactedText
+		}
+	}
+	for _, sink := range logSinks {
+		for name := range sink.Headers {
+			sink.Headers[name] = logger.RedactedText
+		}
+	}
+
+	return &printedConfig{configPath, opts, notifications, logSinks}, nil
+}
+
+// PrintConfig prints the effective service configuration, as YAML unless
+// the output flags ask for another format.
+func PrintConfig(cli *Service) {
+	config, err := effectiveConfig(cli)
+	if err != nil {
+		client.Fail(client.ErrorInvalid, "Error loading config: %v", err)
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		client.Fail(client.ErrorGeneric, "Could not serialize config: %v", err)
+	}
+	// Structured formats use the keys of the configuration file.
+	var result map[string]any
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		client.Fail(client.ErrorGeneric, "Could not serialize config: %v", err)
+	}
+
+	client.PrintResult(result, nil, func(wide bool) {
+		os.Stdout.Write(data)
+	})
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/client"
+	"jstarpl/jpm/service/logger"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+func TestInstanceArg(t *testing.T) {
+	t.Setenv("JPM_INSTANCE", "env")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--instance", "ci", "ps"}, "ci"},
+		{[]string{"ps", "--instance=ci"}, "ci"},
+		{[]string{"start", "--", "app", "--instance", "x"}, "env"},
+		{[]string{"ps"}, "env"},
+	}
+	for _, tt := range tests {
+		if got := InstanceArg(tt.args); got != tt.want {
+			t.Errorf("InstanceArg(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestConfigLoader_ServiceSettingsOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := "log_format: json\nlog_retention_days: 5\ntoken: abc\nsocket: /tmp/jpm-test.sock\n"
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var cli struct {
+		Globals `embed:""`
+		Service Service       `cmd:""`
+		Start   client.Start  `cmd:""`
+		Attach  client.Attach `cmd:""`
+	}
+	parser, err := kong.New(&cli, kong.Configuration(ConfigLoader, path), KongVars())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parser.Parse([]string{"start", "app"}); err != nil {
+		t.Fatalf("Parse start: %v", err)
+	}
+	if cli.Start.LogFormat != "" || cli.Start.LogRetentionDays != 0 {
+		t.Errorf("jpm start took log settings of the service: format %q, retention %d", cli.Start.LogFormat, cli.Start.LogRetentionDays)
+	}
+	if cli.Socket != "/tmp/jpm-test.sock" {
+		t.Errorf("socket = %q, want the one of the configuration file", cli.Socket)
+	}
+
+	if _, err := parser.Parse([]string{"service", "config"}); err != nil {
+		t.Fatalf("Parse service config: %v", err)
+	}
+	if cli.Service.Config.LogFormat != "json" || cli.Service.Config.LogRetentionDays != 5 {
+		t.Errorf("service config = format %q, retention %d, want the configuration file", cli.Service.Config.LogFormat, cli.Service.Config.LogRetentionDays)
+	}
+
+	if _, err := parser.Parse([]string{"attach", "0"}); err != nil {
+		t.Fatalf("Parse attach: %v", err)
+	}
+	if cli.Attach.Token != "abc" {
+		t.Errorf("attach token = %q, want the one of the service", cli.Attach.Token)
+	}
+}
+
+func TestListenTCP_InstancePortInUse(t *testing.T) {
+	t.Setenv("JPM_HOME", t.TempDir())
+	api.SetInstance("ci")
+	defer api.SetInstance("")
+
+	// Another instance got the same port.
+	other, err := net.Listen("tcp", api.DefaultListen())
+	if err != nil {
+		t.Skip(err)
+	}
+	defer other.Close()
+
+	_, err = listenTCP(api.DefaultListen())
+	if err == nil || !strings.Contains(err.Error(), `instance "ci"`) || !strings.Contains(err.Error(), ConfigPath()) {
+		t.Errorf("error = %v, want one naming the instance and its configuration", err)
+	}
+}
+
+func TestEffectiveConfig_MasksSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := `token: abc
+notifications:
+  webhooks:
+    - url: http://hooks.example
+      secret: s3cret
+log_sinks:
+  - type: http
+    address: http://logs.example
+    headers:
+      Authorization: Bearer xyz
+`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var cli struct {
+		Globals `embed:""`
+		Service Service `cmd:""`
+	}
+	parser, err := kong.New(&cli, kong.Configuration(ConfigLoader, path), KongVars())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.Parse([]string{"service", "config", "--config", path}); err != nil {
+		t.Fatalf("Parse service config: %v", err)
+	}
+
+	got, err := effectiveConfig(&cli.Service)
+	if err != nil {
+		t.Fatalf("effectiveConfig: %v", err)
+	}
+	if got.Token != logger.RedactedText {
+		t.Errorf("token = %q, want it masked", got.Token)
+	}
+	if secret := got.Notifications.Webhooks[0].Secret; secret != logger.RedactedText {
+		t.Errorf("webhook secret = %q, want it masked", secret)
+	}
+	if header := got.LogSinks[0].Headers["Authorization"]; header != logger.RedactedText {
+		t.Errorf("log sink header = %q, want it masked", header)
+	}
+	if got.Notifications.Webhooks[0].URL != "http://hooks.example" {
+		t.Errorf("webhook url = %q", got.Notifications.Webhooks[0].URL)
+	}
+}
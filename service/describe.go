@@ -0,0 +1,33 @@
+package service
+
+import (
+	"fmt"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+)
+
+// describeProcesses returns the details of the process with the id of
+// params, or of the processes its query selects.
+func describeProcesses(params api.RequestDescribeProcessParams) ([]api.ProcessDetail, error) {
+	var ids []string
+	switch {
+	case params.Id != "":
+		ids = []string{params.Id}
+	case params.Query != "":
+		if ids = executor.SelectProcesses(params.Query); len(ids) == 0 {
+			return nil, fmt.Errorf("%w: %q selects no process", executor.ErrProcessNotFound, params.Query)
+		}
+	default:
+		return nil, fmt.Errorf("%w: id or query is missing", executor.ErrInvalidOptions)
+	}
+
+	details := make([]api.ProcessDetail, 0, len(ids))
+	for _, id := range ids {
+		detail, err := executor.DescribeProcess(id)
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, detail)
+	}
+	return details, nil
+}
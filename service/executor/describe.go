@@ -0,0 +1,82 @@
+package executor
+
+import (
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/logger"
+	"strings"
+	"time"
+)
+
+// DescribeProcess returns everything known about a process.
+func DescribeProcess(Id string) (api.ProcessDetail, error) {
+	processesMu.RLock()
+	defer processesMu.RUnlock()
+
+	proc, ok := processes[Id]
+	if !ok {
+		return api.ProcessDetail{}, ErrProcessNotFound
+	}
+
+	detail := api.ProcessDetail{
+		Process:      proc.ToApiProcess(),
+		Pid:          proc.Pid,
+		LastStarted:  proc.LastStarted,
+		RespawnDelay: proc.RespawnDelay,
+		Exits:        proc.Exits,
+		Health:       proc.health(),
+	}
+	if proc.Pid != 0 {
+		detail.Pgid = processGroup(proc.Pid)
+	}
+	if len(proc.Exits) > 0 {
+		detail.LastExited = proc.Exits[0].Time
+	}
+	if proc.Logger != nil {
+		detail.LogFiles = proc.Logger.Files()
+	}
+	if detail.Exits == nil {
+		detail.Exits = []api.ProcessExit{}
+	}
+	if detail.LogFiles == nil {
+		detail.LogFiles = []string{}
+	}
+	return detail, nil
+}
+
+// health tells how the process is doing from its status and last exit.
+func (proc *Process) health() api.Health {
+	switch proc.Status {
+	case api.Running, api.Starting:
+		// A respawned process is unstable until it stays up long enough to
+		// respawn with the initial delay again.
+		if len(proc.Exits) > 0 && proc.Exits[0].Reason != api.ExitStopped && time.Since(proc.LastStarted) < respawnResetTime {
+			return api.HealthUnstable
+		}
+		return api.HealthHealthy
+	case api.Respawn:
+		if proc.shouldRestart() {
+			return api.HealthRestarting
+		}
+		if proc.ExitCode == 0 {
+			return api.HealthExited
+		}
+		return api.HealthFailed
+	case api.Failed:
+		return api.HealthFailed
+	}
+	return api.HealthStopped
+}
+
+// maskedEnv returns the environment of the process with the values of its
+// secret variables masked.
+func (proc *Process) maskedEnv() []string {
+	env := make([]string, len(proc.Env))
+	for i, variable := range proc.Env {
+		name, _, ok := strings.Cut(variable, "=")
+		if ok && isSecretEnv(name, proc.Log.SecretEnv) {
+			variable = name + "=" + logger.RedactedText
+		}
+		env[i] = variable
+	}
+	return env
+}
@@ -0,0 +1,91 @@
+//go:build !windows
+
+package executor
+
+import (
+	"jstarpl/jpm/api"
+	"slices"
+	"testing"
+	"time"
+)
+
+// runToExit starts a shell script and waits for it to exit. The process is
+// left to the next test, which resets the process table.
+func runToExit(t *testing.T, script string, env []string, log api.LogOptions) *Process {
+	t.Helper()
+
+	events := SubscribeEvents()
+	defer events.Close()
+
+	proc, err := StartProcess("describe", "", "sh", []string{"-c", script}, "", env, log)
+	if err != nil {
+		t.Fatalf("StartProcess: %v", err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events.Ch():
+			if e.ProcessExitedEvent != nil && e.ProcessExitedEvent.Process.Id == proc.Id {
+				return proc
+			}
+		case <-timeout:
+			t.Fatalf("%q did not exit", script)
+		}
+	}
+}
+
+func TestDescribeProcess(t *testing.T) {
+	setProcesses()
+
+	proc := runToExit(t, "exit 3", []string{"API_TOKEN=tok-123", "HOME=/home/app"}, api.LogOptions{SecretEnv: []string{"*_token"}})
+
+	detail, err := DescribeProcess(proc.Id)
+	if err != nil {
+		t.Fatalf("DescribeProcess: %v", err)
+	}
+	if detail.Health != api.HealthFailed {
+		t.Errorf("health = %s, want %s", detail.Health, api.HealthFailed)
+	}
+	if want := []string{"API_TOKEN=[REDACTED]", "HOME=/home/app"}; !slices.Equal(detail.Env, want) {
+		t.Errorf("env = %q, want %q", detail.Env, want)
+	}
+	if len(detail.Exits) != 1 || detail.Exits[0].Reason != api.ExitExited || detail.Exits[0].ExitCode != 3 {
+		t.Errorf("exits = %+v", detail.Exits)
+	}
+	if detail.LastExited.IsZero() || detail.LastStarted.IsZero() || detail.Pid != 0 {
+		t.Errorf("detail = %+v", detail)
+	}
+	if proc.Env[0] != "API_TOKEN=tok-123" {
+		t.Errorf("describing changed the environment to %q", proc.Env)
+	}
+
+	if _, err := DescribeProcess("99999"); err != ErrProcessNotFound {
+		t.Errorf("describing an unknown process = %v", err)
+	}
+}
+
+func TestDescribeProcess_Signaled(t *testing.T) {
+	setProcesses()
+
+	proc := runToExit(t, "kill -KILL $$", nil, api.LogOptions{})
+
+	detail, _ := DescribeProcess(proc.Id)
+	if exit := detail.Exits[0]; exit.Reason != api.ExitSignaled || exit.Signal != "SIGKILL" {
+		t.Errorf("exit = %+v", exit)
+	}
+}
+
+func TestRecordExit_KeepsLatest(t *testing.T) {
+	proc := &Process{}
+	for i := range exitHistorySize + 5 {
+		proc.recordExit(api.ProcessExit{ExitCode: i})
+	}
+
+	if len(proc.Exits) != exitHistorySize {
+		t.Fatalf("%d exits kept, want %d", len(proc.Exits), exitHistorySize)
+	}
+	if first, last := proc.Exits[0].ExitCode, proc.Exits[exitHistorySize-1].ExitCode; first != exitHistorySize+4 || last != 5 {
+		t.Errorf("exits kept from %d to %d", first, last)
+	}
+}
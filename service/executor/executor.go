@@ -1,23 +1,69 @@
 package executor
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"jstarpl/jpm/api"
 	"jstarpl/jpm/service/logger"
+	"jstarpl/jpm/service/logsink"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/teivah/broadcast"
 )
 
 const (
-	stopTimeoutTime = 3 * time.Second
+	defaultStopTimeout = 3 * time.Second
+
+	initialRespawnDelay = 1 * time.Second
+	maxRespawnDelay     = 30 * time.Second
+	// A process that stayed up at least this long is considered healthy again
+	// and respawns with the initial delay.
+	respawnResetTime = 10 * time.Second
+
+	// exitHistorySize is how many exits of a process are remembered.
+	exitHistorySize = 10
+)
+
+var (
+	// ErrProcessNotFound is returned for an id no process has.
+	ErrProcessNotFound = errors.New("Process not found")
+	// ErrNotRunning is returned when stopping a process that is not running.
+	ErrNotRunning = errors.New("Process is not running")
+	// ErrLoggingDisabled is returned when querying the logs of a process
+	// without log files.
+	ErrLoggingDisabled = errors.New("Logging is disabled for the process")
+	// ErrInvalidOptions is returned when starting a process with invalid
+	// options.
+	ErrInvalidOptions = errors.New("Invalid process options")
+	// ErrInvalidSignal is returned for a signal name that is not known.
+	ErrInvalidSignal = errors.New("Unknown signal")
+	// ErrNoProcessGroup is returned when signalling the process group of a
+	// process that does not lead one.
+	ErrNoProcessGroup = errors.New("The process does not lead a process group of its own")
+	// ErrDraining is returned for changes to processes while they are
+	// handed over to another service.
+	ErrDraining = errors.New("The service is handing its processes over")
+)
+
+type RestartPolicy string
+
+const (
+	RestartNever     RestartPolicy = "never"
+	RestartOnFailure RestartPolicy = "on-failure"
+	RestartAlways    RestartPolicy = "always"
 )
 
 type Process struct {
@@ -35,35 +81,273 @@ type Process struct {
 	StartCount   int
 	RespawnDelay int
 	FailCount    int
-	StdOutErr    *broadcast.Relay[api.StdStreamMessage]
-	StdIn        *broadcast.Relay[api.StdStreamMessage]
-	Logger       *logger.ProcessLogger
+	Restart      RestartPolicy
+	Pid          int
+	// StartTime is the OS start time of Pid, used to tell it apart from a
+	// later process reusing the same PID. It is 0 where not supported.
+	StartTime uint64
+	// Log overrides the service log settings for this process.
+	Log api.LogOptions
+	// Exits are the last runs of the process that ended, latest first.
+	Exits []api.ProcessExit
+
+	adopted               bool
+	exited                chan struct{}
+	stdout, stderr, stdin *os.File
+	// input passes StdIn to the stdin pipe of the current run.
+	input     *broadcast.Listener[api.StdStreamMessage]
+	StdOutErr *broadcast.Relay[api.StdStreamMessage]
+	output    *scrollback
+	// redactor masks secrets in the output, it is guarded by output.mu.
+	redactor *logger.Redactor
+	StdIn    *broadcast.Relay[api.StdStreamMessage]
+	Logger   *logger.ProcessLogger
+	// ops makes stopping, restarting and deleting the process run one at a
+	// time, without holding processesMu while waiting for the process.
+	ops *sync.Mutex
 }
 
 type ProcessStatusChangeEvent struct {
 	Process Process
 }
 
+type ProcessExitedEvent struct {
+	Process Process
+	// Requested is true when the process exited because it was asked to stop.
+	Requested bool
+	// Signal is the name of the signal that ended the process, if one did.
+	Signal string
+}
+
+// ProcessLoggingEvent tells that writing the log files of a process failed
+// or works again.
+type ProcessLoggingEvent struct {
+	Process Process
+	// Err is why logging is degraded, nil once it recovered.
+	Err error
+}
+
 type ProcessDeletedEvent struct {
 	DeletedProcessId string
 }
 
 type Event struct {
 	*ProcessStatusChangeEvent
+	*ProcessExitedEvent
 	*ProcessDeletedEvent
+	*ProcessLoggingEvent
 }
 
+const eventListenerCapacity = 64
+
+// sinkListenerCapacity buffers output while a log sink is busy.
+const sinkListenerCapacity = 256
+
+// fileListenerCapacity buffers output while the disk is slow, so that the
+// pipes of the process keep flowing. Beyond it output is dropped, which the
+// logger notices and reports.
+const fileListenerCapacity = 4096
+
+// processesMu guards processes, the fields of every process and the
+// settings the processes are started with. Relays and loggers have locks of
+// their own, which are taken after it.
+var processesMu sync.RWMutex
 var processes map[string]*Process
 var execLog *log.Logger
+var events *broadcast.Relay[Event]
+
+var logConfig = LogConfig{Options: logger.Options{RetentionDays: logger.DefaultRetentionDays, Format: logger.FormatText}}
+
+var logSinks *logsink.Set
+var logSinksMu sync.RWMutex
+
+var restartPolicy = RestartNever
+var stopTimeout = defaultStopTimeout
+
+// LogConfig configures the process log files.
+type LogConfig struct {
+	// Dir is where log files are written. Empty disables file logging.
+	Dir string
+	// Options apply to every process, except for the settings a process
+	// overrides with its api.LogOptions.
+	Options logger.Options
+}
+
+// SetLogConfig configures process log files. Call before starting any processes.
+func SetLogConfig(config LogConfig) {
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	logConfig = config
+}
+
+// ApplyLogConfig is SetLogConfig for a running service: it also reconfigures
+// the loggers of existing processes, starting one where none was running.
+func ApplyLogConfig(config LogConfig) {
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	logConfig = config
+
+	for _, proc := range processes {
+		dir := proc.logDir()
+		if dir == "" {
+			continue
+		}
+
+		if proc.Logger == nil {
+			proc.startLogger()
+			continue
+		}
+
+		if err := proc.Logger.Reconfigure(dir, proc.loggerOptions()); err != nil {
+			execLog.Printf("Warning: could not reconfigure process logger for %s: %v", proc.Id, err)
+		}
+	}
+}
+
+// loggerOptions returns the service log options with the overrides of the process.
+func (proc *Process) loggerOptions() logger.Options {
+	options := logConfig.Options
+	options.Namespace = proc.Namespace
+	options.Split = proc.Log.LogSplit
+	if proc.Log.LogRetentionDays > 0 {
+		options.RetentionDays = proc.Log.LogRetentionDays
+	}
+	if proc.Log.LogFormat != "" {
+		options.Format = logger.Format(proc.Log.LogFormat)
+	}
+	return options
+}
+
+// logDir returns the directory for the log files of the process, or "" if
+// file logging is disabled.
+func (proc *Process) logDir() string {
+	switch {
+	case proc.Log.LogDisabled:
+		return ""
+	case proc.Log.LogPath == "":
+		return logConfig.Dir
+	case filepath.IsAbs(proc.Log.LogPath):
+		return proc.Log.LogPath
+	default:
+		return filepath.Join(proc.Dir, proc.Log.LogPath)
+	}
+}
+
+// SetLogSinks sets the sinks receiving the output of all processes and
+// returns the previous ones, which the caller should close.
+func SetLogSinks(sinks *logsink.Set) *logsink.Set {
+	logSinksMu.Lock()
+	defer logSinksMu.Unlock()
+
+	previous := logSinks
+	logSinks = sinks
+	return previous
+}
+
+// logRelayToSinks sends the output of a process to the log sinks until the
+// listener closes. The process must be locked, the output is sent from a
+// goroutine of its own.
+func logRelayToSinks(proc *Process) {
+	l := proc.StdOutErr.Listener(sinkListenerCapacity)
+	id, name, namespace := proc.Id, proc.Name, proc.Namespace
+
+	go func() {
+		for msg := range l.Ch() {
+			logSinksMu.RLock()
+			sinks := logSinks
+			logSinksMu.RUnlock()
+			if sinks == nil {
+				continue
+			}
+
+			processesMu.RLock()
+			instance := logConfig.Options.Instance
+			processesMu.RUnlock()
+
+			sinks.Send(logger.Entry{
+				Time:      time.Now().UTC(),
+				Stream:    msg.StreamType,
+				ProcessId: id,
+				Name:      name,
+				Namespace: namespace,
+				Instance:  instance,
+				Msg:       bytes.TrimRight(msg.Data, "\r\n"),
+			})
+		}
+	}()
+}
+
+// startLogger writes the output of the process to log files, if configured.
+func (proc *Process) startLogger() {
+	dir := proc.logDir()
+	if dir == "" {
+		return
+	}
+
+	pl, err := logger.NewProcessLogger(dir, proc.Id, proc.Name, proc.loggerOptions())
+	if err != nil {
+		execLog.Printf("Warning: could not create process logger for %s: %v", proc.Id, err)
+		return
+	}
+	pl.SetErrorHandler(func(err error) {
+		if err != nil {
+			execLog.Printf("Warning: logging degraded for %s: %v", proc.Id, err)
+		} else {
+			execLog.Printf("Logging recovered for %s", proc.Id)
+		}
+		// The handler runs with the logger locked, which must not wait for
+		// processesMu, so the event is sent from a goroutine of its own.
+		go emitLoggingChange(proc, err)
+	})
+	proc.Logger = pl
+	logRelayToFile(proc.StdOutErr, pl)
+}
+
+func emitLoggingChange(proc *Process, err error) {
+	processesMu.RLock()
+	defer processesMu.RUnlock()
+
+	events.Broadcast(Event{ProcessLoggingEvent: &ProcessLoggingEvent{Process: *proc, Err: err}})
+}
+
+// loggingDegraded tells why output of the process is not reliably logged.
+func (proc *Process) loggingDegraded() string {
+	if proc.Logger == nil {
+		return ""
+	}
+	if err := proc.Logger.Err(); err != nil {
+		return err.Error()
+	}
+	return ""
+}
 
-var logsDir string
-var logRetentionDays = logger.DefaultRetentionDays
+// FlushLogs writes the buffered output of all processes to their log files,
+// for when the service is about to exit.
+func FlushLogs() {
+	processesMu.RLock()
+	defer processesMu.RUnlock()
 
-// SetLogConfig configures the directory for process log files and the number
-// of days to retain them. Call before starting any processes.
-func SetLogConfig(dir string, retentionDays int) {
-	logsDir = dir
-	logRetentionDays = retentionDays
+	for _, proc := range processes {
+		if proc.Logger != nil {
+			proc.Logger.Flush()
+		}
+	}
+}
+
+// SetDefaults configures the restart policy given to new processes and how
+// long StopProcess waits for a process to exit before killing it.
+func SetDefaults(policy RestartPolicy, timeout time.Duration) {
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	if policy != "" {
+		restartPolicy = policy
+	}
+	if timeout > 0 {
+		stopTimeout = timeout
+	}
 }
 
 const logProps = log.Lmicroseconds | log.Ltime | log.Ldate | log.LUTC
@@ -71,6 +355,20 @@ const logProps = log.Lmicroseconds | log.Ltime | log.Ldate | log.LUTC
 func init() {
 	processes = make(map[string]*Process)
 	execLog = log.New(log.Default().Writer(), "executor: ", logProps)
+	events = broadcast.NewRelay[Event]()
+}
+
+// SubscribeEvents returns a listener receiving process lifecycle events.
+// Events are delivered without blocking the executor, so a listener that
+// falls behind by more than its buffer will miss events.
+func SubscribeEvents() *broadcast.Listener[Event] {
+	return events.Listener(eventListenerCapacity)
+}
+
+// emitStatusChange tells listeners about the status of the process, which
+// must be locked.
+func emitStatusChange(proc *Process) {
+	events.Broadcast(Event{ProcessStatusChangeEvent: &ProcessStatusChangeEvent{Process: *proc}})
 }
 
 func checkError(err error) {
@@ -80,36 +378,74 @@ func checkError(err error) {
 }
 
 func ListProcesses() *[]api.Process {
+	processesMu.RLock()
+	defer processesMu.RUnlock()
+
 	result := make([]api.Process, len(processes))
 	i := 0
-	for id, proc := range processes {
-		var uptime int
-		if proc.Status == api.Running {
-			uptime = int(time.Since(proc.LastStarted).Milliseconds())
-		} else {
-			uptime = 0
-		}
-
-		result[i] = api.Process{
-			Id:         id,
-			Name:       proc.Name,
-			Namespace:  proc.Namespace,
-			Exec:       proc.Exec,
-			Arg:        proc.Arg,
-			Env:        proc.Env,
-			Dir:        proc.Dir,
-			Uptime:     uptime,
-			StartCount: proc.StartCount,
-			FailCount:  proc.FailCount,
-			Status:     proc.Status,
-			ExitCode:   proc.ExitCode,
-		}
+	for _, proc := range processes {
+		result[i] = proc.ToApiProcess()
 		i++
 	}
 
 	return &result
 }
 
+// SelectProcesses returns the ids of the processes a query selects, in
+// order: all of them for "all", otherwise the ones with the query as name or
+// namespace.
+func SelectProcesses(query string) []string {
+	processesMu.RLock()
+	defer processesMu.RUnlock()
+
+	var ids []string
+	for _, proc := range processes {
+		if query == "all" || proc.Name == query || proc.Namespace == query {
+			ids = append(ids, proc.Id)
+		}
+	}
+
+	slices.SortFunc(ids, func(a, b string) int {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x - y
+	})
+	return ids
+}
+
+// ToApiProcess converts the process into its API representation, with the
+// values of its secret environment variables masked. The process must be
+// locked, unless it is a copy like the one of an event.
+func (proc *Process) ToApiProcess() api.Process {
+	var uptime int
+	if proc.Status == api.Running {
+		uptime = int(time.Since(proc.LastStarted).Milliseconds())
+	} else {
+		uptime = 0
+	}
+
+	return api.Process{
+		Id:         proc.Id,
+		Name:       proc.Name,
+		Namespace:  proc.Namespace,
+		Exec:       proc.Exec,
+		Arg:        proc.Arg,
+		Env:        proc.maskedEnv(),
+		Dir:        proc.Dir,
+		Uptime:     uptime,
+		StartCount: proc.StartCount,
+		FailCount:  proc.FailCount,
+		Status:     proc.Status,
+		ExitCode:   proc.ExitCode,
+		Restart:    string(proc.Restart),
+
+		OutputUnavailable: proc.adopted && proc.stdout == nil,
+		LoggingDegraded:   proc.loggingDegraded(),
+
+		LogOptions: proc.Log,
+	}
+}
+
 func getNextProcessId() string {
 	biggestId := -1
 	for k := range processes {
@@ -125,23 +461,35 @@ func getNextProcessId() string {
 	return strconv.FormatInt(int64(biggestId+1), 10)
 }
 
-func StartProcess(Name string, Namespace string, Exec string, Arg []string, Dir string, Env []string) (*Process, error) {
+func StartProcess(Name string, Namespace string, Exec string, Arg []string, Dir string, Env []string, Log api.LogOptions) (*Process, error) {
+	if Exec == "" {
+		return nil, fmt.Errorf("%w: exec is missing", ErrInvalidOptions)
+	}
+	if _, err := logger.ParseFormat(Log.LogFormat); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+	if err := ValidateSecretEnv(Log.SecretEnv); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+
+	if err := beginChange(); err != nil {
+		return nil, err
+	}
+	defer endChange()
+
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
 	newId := getNextProcessId()
 
 	stdOutErrRelay := broadcast.NewRelay[api.StdStreamMessage]()
 	stdInRelay := broadcast.NewRelay[api.StdStreamMessage]()
-	proc := Process{Id: newId, Name: Name, Namespace: Namespace, Exec: Exec, Dir: Dir, Arg: Arg, Env: Env, Status: api.Starting, Cmd: nil, ExitCode: 0, RespawnDelay: 0, FailCount: 0, StdOutErr: stdOutErrRelay, StdIn: stdInRelay}
+	proc := Process{Id: newId, Name: Name, Namespace: Namespace, Exec: Exec, Dir: Dir, Arg: Arg, Env: Env, Status: api.Starting, Cmd: nil, ExitCode: 0, RespawnDelay: 0, FailCount: 0, Restart: restartPolicy, Log: Log, StdOutErr: stdOutErrRelay, StdIn: stdInRelay, output: newScrollback(), ops: &sync.Mutex{}}
 	processes[newId] = &proc
 
-	if logsDir != "" {
-		pl, err := logger.NewProcessLogger(logsDir, newId, Name, logRetentionDays, true)
-		if err != nil {
-			execLog.Printf("Warning: could not create process logger for %s: %v", newId, err)
-		} else {
-			proc.Logger = pl
-			go logRelayToFile(proc.StdOutErr, pl)
-		}
-	}
+	proc.updateRedactor()
+	proc.startLogger()
+	logRelayToSinks(&proc)
 
 	err := startProcess(&proc)
 	if err != nil {
@@ -152,19 +500,22 @@ func StartProcess(Name string, Namespace string, Exec string, Arg []string, Dir
 }
 
 // logRelayToFile subscribes to a process stdout/stderr relay and writes all
-// messages to the given ProcessLogger. It closes the logger when the relay closes.
+// messages to the given ProcessLogger from a goroutine of its own. It closes
+// the logger when the relay closes. Write errors are reported through the
+// error handler of the logger.
 func logRelayToFile(relay *broadcast.Relay[api.StdStreamMessage], pl *logger.ProcessLogger) {
-	l := relay.Listener(1)
-	for msg := range l.Ch() {
-		if err := pl.Write(msg); err != nil {
-			execLog.Printf("Error writing to process log: %v", err)
+	l := relay.Listener(fileListenerCapacity)
+	go func() {
+		for msg := range l.Ch() {
+			pl.Write(msg)
 		}
-	}
-	if err := pl.Close(); err != nil {
-		execLog.Printf("Error closing process log: %v", err)
-	}
+		if err := pl.Close(); err != nil {
+			execLog.Printf("Error closing process log: %v", err)
+		}
+	}()
 }
 
+// startProcess runs the process, which must be locked.
 func startProcess(proc *Process) error {
 	if proc == nil {
 		return errors.New("Process is nil")
@@ -173,13 +524,19 @@ func startProcess(proc *Process) error {
 	cmd := exec.Command(proc.Exec, proc.Arg...)
 	cmd.Dir = proc.Dir
 	cmd.Env = proc.Env
+	setProcessGroup(cmd)
 
-	stdout, err := cmd.StdoutPipe()
+	// The pipes are created here rather than with cmd.StdoutPipe() and
+	// friends so that the service owns the files and can hand them over.
+	stdout, stdoutW, err := os.Pipe()
 	checkError(err)
-	stderr, err := cmd.StderrPipe()
+	stderr, stderrW, err := os.Pipe()
 	checkError(err)
-	stdin, err := cmd.StdinPipe()
+	stdinR, stdin, err := os.Pipe()
 	checkError(err)
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+	cmd.Stdin = stdinR
 
 	proc.Cmd = cmd
 	proc.StartCount++
@@ -188,132 +545,380 @@ func startProcess(proc *Process) error {
 
 	err = cmd.Start()
 
+	// The child has its own copies of these now.
+	stdoutW.Close()
+	stderrW.Close()
+	stdinR.Close()
+
 	execLog.Printf("Starting %s as %s...", proc.Exec, proc.Id)
 
 	if err != nil {
 		execLog.Printf("Failed to start %s: %v", proc.Id, err)
+		stdout.Close()
+		stderr.Close()
+		stdin.Close()
+		proc.Cmd = nil
 		proc.Status = api.Failed
 		proc.FailCount++
+		emitStatusChange(proc)
 		return err
 	}
 
+	proc.Pid = cmd.Process.Pid
+	proc.StartTime, _ = processStartTime(proc.Pid)
+	proc.exited = make(chan struct{})
 	proc.Status = api.Running
+	emitStatusChange(proc)
 
-	go readerCopyToRelay(proc.StdOutErr, stdout, api.Stdout)
-	go readerCopyToRelay(proc.StdOutErr, stderr, api.Stderr)
-	go relayCopyToWriter(proc.StdIn, stdin)
+	proc.attachPipes(stdout, stderr, stdin)
 
+	exited := proc.exited
 	go (func() {
 		err := cmd.Wait()
 
-		execLog.Printf("%s finished", proc.Id)
-
-		if proc.Status != api.Stopping {
-			proc.FailCount++
-		}
-
-		if proc.Status != api.Stopped && proc.Status != api.Stopping {
-			proc.Status = api.Respawn
-		}
-
+		exitCode := 0
+		var sig os.Signal
 		if err != nil {
 			if exiterr, ok := err.(*exec.ExitError); ok {
-				proc.ExitCode = exiterr.ExitCode()
-			} else {
-				proc.ExitCode = 0
+				exitCode = exiterr.ExitCode()
+				if status, ok := exiterr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+					sig = status.Signal()
+				}
 			}
-		} else {
-			proc.ExitCode = 0
 		}
-		proc.Cmd = nil
+
+		processExited(proc, exited, exitCode, sig)
 	})()
 
 	return nil
 }
 
-func relayCopyToWriter(src *broadcast.Relay[api.StdStreamMessage], dst io.Writer) {
-	l := src.Listener(1)
-	defer l.Close()
+// attachPipes starts relaying the given process pipes. Any of them may be nil
+// when the stream is not available, e.g. for a re-adopted process.
+func (proc *Process) attachPipes(stdout, stderr, stdin *os.File) {
+	proc.stdout, proc.stderr, proc.stdin = stdout, stderr, stdin
 
-	for msg := range l.Ch() {
-		if len(msg.Data) == 0 {
-			continue
+	if stdout != nil {
+		go proc.copyOutput(stdout, api.Stdout, maxLineLength, lineFlushTimeout)
+	}
+	if stderr != nil {
+		go proc.copyOutput(stderr, api.Stderr, maxLineLength, lineFlushTimeout)
+	}
+	if stdin != nil {
+		proc.input = relayCopyToWriter(proc.StdIn, stdin)
+	}
+}
+
+// detachPipes closes the service side of the process pipes, which stops the
+// relay goroutines without affecting the process itself.
+func (proc *Process) detachPipes() {
+	proc.closeInput()
+	for _, f := range []*os.File{proc.stdout, proc.stderr} {
+		if f != nil {
+			f.Close()
 		}
+	}
+	proc.stdout, proc.stderr = nil, nil
+}
 
-		_, err := dst.Write(msg.Data)
-		if err != nil {
-			execLog.Printf("Error while writing to stdin stream %v", err)
-			return
+// closeInput stops passing input to the process and closes the service side
+// of its stdin pipe.
+func (proc *Process) closeInput() {
+	if proc.input != nil {
+		proc.input.Close()
+		proc.input = nil
+	}
+	if proc.stdin != nil {
+		proc.stdin.Close()
+		proc.stdin = nil
+	}
+}
+
+// processExited updates the process after the run identified by exited
+// finished, with sig if a signal ended it, and respawns it if its restart
+// policy says so.
+func processExited(proc *Process, exited chan struct{}, exitCode int, sig os.Signal) {
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	execLog.Printf("%s finished", proc.Id)
+
+	if proc.exited != exited {
+		// The process has already been started again.
+		close(exited)
+		return
+	}
+
+	requested := proc.Status == api.Stopping || proc.Status == api.Stopped
+
+	if proc.Status != api.Stopping {
+		proc.FailCount++
+	}
+
+	if proc.Status != api.Stopped && proc.Status != api.Stopping {
+		proc.Status = api.Respawn
+	}
+
+	exit := api.ProcessExit{Time: time.Now(), Reason: api.ExitExited, ExitCode: exitCode, Uptime: int(time.Since(proc.LastStarted).Milliseconds())}
+	switch {
+	case requested:
+		exit.Reason = api.ExitStopped
+	case sig != nil:
+		exit.Reason = api.ExitSignaled
+	case proc.adopted:
+		exit.Reason = api.ExitLost
+	}
+	if sig != nil {
+		exit.Signal = SignalName(sig)
+	}
+	proc.recordExit(exit)
+
+	proc.ExitCode = exitCode
+	proc.Cmd = nil
+	proc.Pid = 0
+	proc.StartTime = 0
+	proc.adopted = false
+	// The output pipes are closed by copyOutput once it read everything the
+	// process wrote.
+	proc.closeInput()
+	proc.stdout, proc.stderr = nil, nil
+	close(proc.exited)
+
+	events.Broadcast(Event{ProcessExitedEvent: &ProcessExitedEvent{Process: *proc, Requested: requested, Signal: exit.Signal}})
+
+	if proc.Status == api.Respawn && proc.shouldRestart() {
+		go respawnProcess(proc)
+	}
+}
+
+// recordExit adds an exit to the history of the process.
+func (proc *Process) recordExit(exit api.ProcessExit) {
+	proc.Exits = append([]api.ProcessExit{exit}, proc.Exits[:min(len(proc.Exits), exitHistorySize-1)]...)
+}
+
+func (proc *Process) shouldRestart() bool {
+	switch proc.Restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return proc.ExitCode != 0
+	}
+	return false
+}
+
+// respawnProcess restarts a process that exited on its own, backing off
+// exponentially while it keeps crashing shortly after being started.
+func respawnProcess(proc *Process) {
+	processesMu.Lock()
+	delay := time.Duration(proc.RespawnDelay) * time.Millisecond
+	if delay == 0 || time.Since(proc.LastStarted) >= respawnResetTime {
+		delay = initialRespawnDelay
+	} else {
+		delay = min(delay*2, maxRespawnDelay)
+	}
+	proc.RespawnDelay = int(delay.Milliseconds())
+	processesMu.Unlock()
+
+	execLog.Printf("Respawning %s in %v", proc.Id, delay)
+	time.Sleep(delay)
+
+	// The service that takes over the process respawns it instead.
+	if err := beginChange(); err != nil {
+		return
+	}
+	defer endChange()
+
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	// The process may have been stopped, restarted or deleted in the meantime.
+	if proc.Status != api.Respawn || processes[proc.Id] != proc {
+		return
+	}
+
+	if err := startProcess(proc); err != nil {
+		execLog.Printf("Could not respawn %s: %v", proc.Id, err)
+	}
+}
+
+// relayCopyToWriter writes what is sent to src to dst from a goroutine of
+// its own, until src or the returned listener closes or writing fails.
+func relayCopyToWriter(src *broadcast.Relay[api.StdStreamMessage], dst io.Writer) *broadcast.Listener[api.StdStreamMessage] {
+	l := src.Listener(1)
+	go func() {
+		defer l.Close()
+
+		for msg := range l.Ch() {
+			if len(msg.Data) == 0 {
+				continue
+			}
+
+			_, err := dst.Write(msg.Data)
+			if errors.Is(err, fs.ErrClosed) {
+				return
+			} else if err != nil {
+				execLog.Printf("Error while writing to stdin stream %v", err)
+				return
+			}
 		}
+	}()
+	return l
+}
+
+// findProcess returns the process with the id.
+func findProcess(Id string) (*Process, error) {
+	processesMu.RLock()
+	defer processesMu.RUnlock()
+
+	proc := processes[Id]
+	if proc == nil {
+		return nil, ErrProcessNotFound
 	}
+	return proc, nil
 }
 
 func GetProcessStdInStreamRelay(Id string) (*broadcast.Relay[api.StdStreamMessage], error) {
-	processes := processes[Id]
-	if processes == nil {
-		return nil, errors.New("Process not found")
+	proc, err := findProcess(Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return proc.StdIn, nil
+}
+
+// WriteInput passes data to the input of a running process. It waits until
+// the process takes the input or ctx is done, so that senders can't get
+// ahead of a process that does not read. data is copied, the caller may
+// reuse it, like the body of a request, once WriteInput returns.
+func WriteInput(ctx context.Context, Id string, data []byte) error {
+	processesMu.RLock()
+	proc := processes[Id]
+	running := proc != nil && proc.stdin != nil
+	processesMu.RUnlock()
+
+	if proc == nil {
+		return ErrProcessNotFound
+	}
+	if !running {
+		return ErrNotRunning
 	}
 
-	return processes.StdIn, nil
+	proc.StdIn.NotifyCtx(ctx, api.StdStreamMessage{StreamType: api.Stdin, Data: bytes.Clone(data)})
+	return ctx.Err()
 }
 
 func GetProcessStdStreamRelay(Id string) (*broadcast.Relay[api.StdStreamMessage], error) {
-	process := processes[Id]
-	if process == nil {
-		return nil, errors.New("Process not found")
+	proc, err := findProcess(Id)
+	if err != nil {
+		return nil, err
 	}
 
-	return process.StdOutErr, nil
+	return proc.StdOutErr, nil
+}
+
+// QueryLogs searches the log files of a process, including rotated and
+// compressed ones.
+func QueryLogs(Id string, query logger.Query) (logger.QueryResult, error) {
+	processesMu.RLock()
+	proc := processes[Id]
+	var dir string
+	if proc != nil {
+		dir = proc.logDir()
+	}
+	processesMu.RUnlock()
+
+	if proc == nil {
+		return logger.QueryResult{}, ErrProcessNotFound
+	}
+	if dir == "" {
+		return logger.QueryResult{}, ErrLoggingDisabled
+	}
+
+	return logger.QueryFiles(dir, proc.Id, proc.Name, query)
 }
 
 func RestartProcess(Id string) error {
-	proc, ok := processes[Id]
-	if !ok {
-		return errors.New("Process Id not found")
+	if err := beginChange(); err != nil {
+		return err
 	}
+	defer endChange()
 
-	if proc.Status > api.Stopped {
-		err := StopProcess(Id)
-		if err != nil {
+	proc, err := findProcess(Id)
+	if err != nil {
+		return err
+	}
+
+	proc.ops.Lock()
+	defer proc.ops.Unlock()
+
+	processesMu.RLock()
+	deleted := processes[Id] != proc
+	running := proc.Status > api.Stopped
+	processesMu.RUnlock()
+
+	if deleted {
+		return ErrProcessNotFound
+	}
+	if running {
+		if err := proc.stop(); err != nil {
 			return err
 		}
 	}
 
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
 	return startProcess(proc)
 }
 
-func readerCopyToRelay(dst *broadcast.Relay[api.StdStreamMessage], src io.Reader, streamType api.StreamType) {
-	for {
-		buf := make([]byte, 1024)
-		read, err := src.Read(buf)
+// copyOutput publishes what the process writes to src, and closes src once
+// it is drained.
+func (proc *Process) copyOutput(src io.ReadCloser, streamType api.StreamType, maxLength int, flushTimeout time.Duration) {
+	defer src.Close()
 
-		dst.Broadcast(api.StdStreamMessage{
+	err := frameLines(src, maxLength, flushTimeout, func(line []byte) {
+		proc.publishOutput(api.StdStreamMessage{
 			StreamType: streamType,
-			Data:       buf[0:read],
+			Data:       line,
 		})
+	})
 
-		if (errors.Is(err, io.EOF)) || (errors.Is(err, io.ErrClosedPipe)) || (errors.Is(err, fs.ErrClosed)) {
-			return
-		} else if err != nil {
-			execLog.Printf("Error while reading from stream %v", err)
-			return
-		}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) && !errors.Is(err, fs.ErrClosed) {
+		execLog.Printf("Error while reading from stream %v", err)
 	}
 }
 
 func DeleteProcess(Id string) error {
-	proc, ok := processes[Id]
-	if !ok {
-		return errors.New("Process Id not found")
+	if err := beginChange(); err != nil {
+		return err
 	}
+	defer endChange()
 
-	if proc.Status > 0 {
-		err := StopProcess(proc.Id)
-		if err != nil {
+	proc, err := findProcess(Id)
+	if err != nil {
+		return err
+	}
+
+	proc.ops.Lock()
+	defer proc.ops.Unlock()
+
+	processesMu.RLock()
+	deleted := processes[Id] != proc
+	running := proc.Status > 0
+	processesMu.RUnlock()
+
+	if deleted {
+		return ErrProcessNotFound
+	}
+	if running {
+		if err := proc.stop(); err != nil {
 			return err
 		}
 	}
 
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
 	if proc.StdOutErr != nil {
 		proc.StdOutErr.Close()
 	}
@@ -324,44 +929,99 @@ func DeleteProcess(Id string) error {
 
 	delete(processes, Id)
 
+	events.Broadcast(Event{ProcessDeletedEvent: &ProcessDeletedEvent{DeletedProcessId: Id}})
+
 	return nil
 }
 
 func StopProcess(Id string) error {
-	proc, ok := processes[Id]
-	if !ok {
-		return errors.New("Process Id not found")
+	if err := beginChange(); err != nil {
+		return err
+	}
+	defer endChange()
+
+	proc, err := findProcess(Id)
+	if err != nil {
+		return err
+	}
+
+	proc.ops.Lock()
+	defer proc.ops.Unlock()
+
+	processesMu.RLock()
+	deleted := processes[Id] != proc
+	processesMu.RUnlock()
+
+	if deleted {
+		return ErrProcessNotFound
+	}
+	return proc.stop()
+}
+
+// stop ends the process, waiting for it to exit. The caller must hold ops,
+// processesMu is only held while the process is not waited for.
+func (proc *Process) stop() error {
+	processesMu.Lock()
+
+	if proc.Status <= api.Stopped {
+		processesMu.Unlock()
+		return ErrNotRunning
 	}
 
-	cmd := proc.Cmd
+	if proc.Pid == 0 && proc.Status == api.Respawn {
+		// Waiting to be respawned, cancel that.
+		proc.Status = api.Stopped
+		emitStatusChange(proc)
+		processesMu.Unlock()
+		return nil
+	}
 
 	proc.Status = api.Stopping
 
-	if cmd == nil {
-		return errors.New("Process is not attached")
+	osProc, err := proc.osProcess()
+	if err != nil {
+		processesMu.Unlock()
+		return err
 	}
+	exited := proc.exited
+	processesMu.Unlock()
 
 	if runtime.GOOS == "windows" {
 		execLog.Printf("Shutting down %s with os.Kill", proc.Exec)
-		cmd.Process.Signal(os.Kill)
+		osProc.Signal(os.Kill)
 	} else {
 		execLog.Printf("Shutting down %s with os.Interrupt", proc.Exec)
-		cmd.Process.Signal(os.Interrupt)
-		startTime := time.Now()
-		for time.Since(startTime) < stopTimeoutTime {
-			if cmd.ProcessState != nil {
-				break
-			}
-			execLog.Printf("Waiting for shutdown...")
-			time.Sleep(100 * time.Millisecond)
-		}
-		if cmd.ProcessState == nil {
+		osProc.Signal(os.Interrupt)
+		select {
+		case <-exited:
+		case <-time.After(stopTimeout):
 			execLog.Printf("Forcing %s with Process.Kill()", proc.Exec)
-			cmd.Process.Kill()
+			osProc.Kill()
+			// Make sure the exit was handled before the process can be started again.
+			select {
+			case <-exited:
+			case <-time.After(stopTimeout):
+			}
 		}
 	}
 
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
 	proc.Status = api.Stopped
+	emitStatusChange(proc)
 
 	return nil
 }
+
+// osProcess returns the running OS process, for both processes started by
+// this service and re-adopted ones.
+func (proc *Process) osProcess() (*os.Process, error) {
+	if proc.Cmd != nil && proc.Cmd.Process != nil {
+		return proc.Cmd.Process, nil
+	}
+	if proc.adopted && proc.Pid != 0 {
+		return os.FindProcess(proc.Pid)
+	}
+	return nil, errors.New("Process is not attached")
+}
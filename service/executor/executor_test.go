@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"errors"
+	"jstarpl/jpm/api"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// setProcesses replaces the process table with procs.
+func setProcesses(procs ...*Process) {
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	processes = map[string]*Process{}
+	for _, proc := range procs {
+		processes[proc.Id] = proc
+	}
+}
+
+func TestProcesses_Concurrent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sleep")
+	}
+	setProcesses()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			proc, err := StartProcess("concurrent", "", "sleep", []string{"60"}, "", nil, api.LogOptions{})
+			if err != nil {
+				t.Errorf("StartProcess: %v", err)
+				return
+			}
+			if err := RestartProcess(proc.Id); err != nil {
+				t.Errorf("RestartProcess: %v", err)
+			}
+			if err := DeleteProcess(proc.Id); err != nil {
+				t.Errorf("DeleteProcess: %v", err)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			if list := ListProcesses(); len(*list) != 0 {
+				t.Errorf("%d processes left", len(*list))
+			}
+			return
+		default:
+		}
+
+		ListProcesses()
+		Snapshot()
+		for _, id := range SelectProcesses("concurrent") {
+			DescribeProcess(id)
+			StopProcess(id)
+		}
+	}
+}
+
+func TestDrain_RejectsChanges(t *testing.T) {
+	setProcesses()
+	Drain()
+
+	if _, err := StartProcess("drained", "", "true", nil, "", nil, api.LogOptions{}); !errors.Is(err, ErrDraining) {
+		t.Errorf("StartProcess while draining: %v, want ErrDraining", err)
+	}
+	if err := StopProcess("1"); !errors.Is(err, ErrDraining) {
+		t.Errorf("StopProcess while draining: %v, want ErrDraining", err)
+	}
+
+	Resume()
+	if err := StopProcess("1"); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("StopProcess after resuming: %v, want ErrProcessNotFound", err)
+	}
+}
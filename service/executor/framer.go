@@ -0,0 +1,118 @@
+package executor
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+const (
+	defaultMaxLineLength = 64 * 1024
+	defaultFlushTimeout  = 100 * time.Millisecond
+	readBufferSize       = 4096
+)
+
+var maxLineLength = defaultMaxLineLength
+var lineFlushTimeout = defaultFlushTimeout
+
+// SetLineFraming configures how process output is split into lines: lines
+// longer than maxLength are cut, and output without a newline is passed on
+// once nothing more arrived for flushTimeout, so prompts are not held back.
+// Applies to processes started afterwards.
+func SetLineFraming(maxLength int, flushTimeout time.Duration) {
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	if maxLength > 0 {
+		maxLineLength = maxLength
+	}
+	if flushTimeout > 0 {
+		lineFlushTimeout = flushTimeout
+	}
+}
+
+// lineFramer collects output and emits it line by line.
+type lineFramer struct {
+	maxLength int
+	buf       []byte
+	emit      func([]byte)
+}
+
+// Write emits every complete line in data, including the newline, and keeps
+// the rest until the line is complete or grows beyond maxLength.
+func (f *lineFramer) Write(data []byte) {
+	f.buf = append(f.buf, data...)
+
+	for {
+		if i := bytes.IndexByte(f.buf, '\n'); i >= 0 && i < f.maxLength {
+			f.emitLine(i + 1)
+		} else if len(f.buf) >= f.maxLength {
+			f.emitLine(f.maxLength)
+		} else {
+			return
+		}
+	}
+}
+
+// Flush emits an incomplete line.
+func (f *lineFramer) Flush() {
+	if len(f.buf) > 0 {
+		f.emitLine(len(f.buf))
+	}
+}
+
+// Pending reports whether an incomplete line is buffered.
+func (f *lineFramer) Pending() bool {
+	return len(f.buf) > 0
+}
+
+func (f *lineFramer) emitLine(n int) {
+	line := make([]byte, n)
+	copy(line, f.buf[:n])
+	f.buf = f.buf[n:]
+	f.emit(line)
+}
+
+// frameLines reads src until it fails and passes the output to emit line by
+// line, see SetLineFraming. It returns the error that ended reading.
+func frameLines(src io.Reader, maxLength int, flushTimeout time.Duration, emit func([]byte)) error {
+	type chunk struct {
+		data []byte
+		err  error
+	}
+	chunks := make(chan chunk)
+
+	go (func() {
+		for {
+			buf := make([]byte, readBufferSize)
+			read, err := src.Read(buf)
+			chunks <- chunk{buf[:read], err}
+			if err != nil {
+				return
+			}
+		}
+	})()
+
+	framer := &lineFramer{maxLength: maxLength, emit: emit}
+	timer := time.NewTimer(flushTimeout)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case c := <-chunks:
+			framer.Write(c.data)
+			if c.err != nil {
+				framer.Flush()
+				return c.err
+			}
+			if framer.Pending() {
+				timer.Reset(flushTimeout)
+			} else {
+				timer.Stop()
+			}
+		case <-timer.C:
+			framer.Flush()
+		}
+	}
+}
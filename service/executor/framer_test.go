@@ -0,0 +1,66 @@
+package executor
+
+import (
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLineFramer(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []string
+	}{
+		{"whole lines", []string{"a\nb\n"}, []string{"a\n", "b\n"}},
+		{"line split across writes", []string{"hel", "lo\nwor", "ld\n"}, []string{"hello\n", "world\n"}},
+		{"incomplete line is kept", []string{"a\n> "}, []string{"a\n"}},
+		{"long line is cut", []string{"abcdefghijklmnopqrs\n"}, []string{"abcdefgh", "ijklmnop", "qrs\n"}},
+		{"line of max length", []string{"abcdefg\n"}, []string{"abcdefg\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			f := &lineFramer{maxLength: 8, emit: func(line []byte) { got = append(got, string(line)) }}
+			for _, w := range tt.writes {
+				f.Write([]byte(w))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrameLinesFlushesAfterTimeout(t *testing.T) {
+	r, w := io.Pipe()
+	lines := make(chan string, 10)
+	done := make(chan error)
+	go (func() {
+		done <- frameLines(r, 1024, 20*time.Millisecond, func(line []byte) { lines <- string(line) })
+	})()
+
+	w.Write([]byte("first\nprom"))
+	w.Write([]byte("pt> "))
+	if got := <-lines; got != "first\n" {
+		t.Errorf("got %q, want first line", got)
+	}
+	select {
+	case got := <-lines:
+		if got != "prompt> " {
+			t.Errorf("got %q, want the prompt", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("incomplete line was not flushed")
+	}
+
+	w.Write([]byte("tail"))
+	w.Close()
+	if err := <-done; err != io.EOF {
+		t.Errorf("frameLines returned %v, want io.EOF", err)
+	}
+	if got := <-lines; got != "tail" {
+		t.Errorf("got %q, want the rest on EOF", got)
+	}
+}
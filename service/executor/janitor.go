@@ -0,0 +1,83 @@
+package executor
+
+import (
+	"errors"
+	"jstarpl/jpm/service/logger"
+	"time"
+)
+
+// LogJanitorInterval is how often RunLogJanitor applies log retention.
+const LogJanitorInterval = 1 * time.Hour
+
+// PruneLogs removes old log files from the log directory of the service and
+// the ones of processes. Unless before is set, the retention of each process
+// applies, and the service retention to files of processes that are gone.
+// Files being written are never removed.
+func PruneLogs(before time.Time, dryRun bool) ([]logger.PrunedFile, error) {
+	processesMu.RLock()
+	config := logConfig
+	dirs := map[string][]logger.PruneProcess{}
+	if config.Dir != "" {
+		dirs[config.Dir] = nil
+	}
+
+	keep := map[string]bool{}
+	for _, proc := range processes {
+		dir := proc.logDir()
+		if dir == "" {
+			continue
+		}
+		dirs[dir] = append(dirs[dir], logger.PruneProcess{
+			ProcessId:     proc.Id,
+			ProcessName:   proc.Name,
+			RetentionDays: proc.loggerOptions().RetentionDays,
+		})
+		if proc.Logger != nil {
+			for path := range proc.Logger.CurrentPaths() {
+				keep[path] = true
+			}
+		}
+	}
+	processesMu.RUnlock()
+
+	var pruned []logger.PrunedFile
+	var errs []error
+	for dir, procs := range dirs {
+		options := logger.PruneOptions{Before: before, Processes: procs, Keep: keep, DryRun: dryRun}
+		// Directories of single processes may be shared with other programs,
+		// only the one of the service is cleaned of files nobody owns.
+		if dir == config.Dir {
+			options.OrphanRetentionDays = config.Options.RetentionDays
+		} else {
+			options.SkipOrphans = true
+		}
+
+		files, err := logger.Prune(dir, options)
+		pruned = append(pruned, files...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return pruned, errors.Join(errs...)
+}
+
+// RunLogJanitor applies log retention every interval, so that log files of
+// quiet or deleted processes are removed too.
+func RunLogJanitor(interval time.Duration) {
+	for {
+		files, err := PruneLogs(time.Time{}, false)
+		if err != nil {
+			execLog.Printf("Warning: could not remove old log files: %v", err)
+		}
+		if len(files) > 0 {
+			var size int64
+			for _, f := range files {
+				size += f.Size
+			}
+			execLog.Printf("Removed %d old log files, %d bytes", len(files), size)
+		}
+
+		time.Sleep(interval)
+	}
+}
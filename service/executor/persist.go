@@ -0,0 +1,89 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type stateFile struct {
+	Processes []ProcessState `json:"processes"`
+}
+
+// PersistState keeps path up to date with the state of all processes, so
+// that a service started after a crash can re-adopt the ones still running.
+// It is meant to be run in its own goroutine.
+func PersistState(path string) {
+	l := events.Listener(eventListenerCapacity)
+	defer l.Close()
+
+	writeStateLogged(path)
+	for range l.Ch() {
+		writeStateLogged(path)
+	}
+}
+
+func writeStateLogged(path string) {
+	if err := writeState(path); err != nil {
+		execLog.Printf("Warning: could not write state file: %v", err)
+	}
+}
+
+// writeState replaces the state file atomically so a crash while writing
+// never leaves a truncated file behind.
+func writeState(path string) error {
+	data, err := json.MarshalIndent(stateFile{Processes: Snapshot()}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RecoverState re-adopts the processes listed in the state file at path
+// whose pid is still alive, whatever their status, typically after the
+// previous service crashed.
+// Their output pipes died with that service, so their output is unavailable
+// until they are restarted.
+func RecoverState(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read state file %s: %w", path, err)
+	}
+
+	var state stateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("could not parse state file %s: %w", path, err)
+	}
+
+	for _, ps := range state.Processes {
+		if _, err := findProcess(ps.Id); err == nil {
+			execLog.Printf("Process %s is already managed, not adopting", ps.Id)
+			continue
+		}
+		if ps.Pid == 0 {
+			execLog.Printf("Process %s was %s without a pid, not adopting", ps.Id, ps.Status)
+			continue
+		}
+		if !processAlive(ps.Pid, ps.StartTime) {
+			execLog.Printf("Process %s (pid %d, %s) did not survive, not adopting", ps.Id, ps.Pid, ps.Status)
+			continue
+		}
+
+		Adopt(ps, nil, nil, nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+//go:build !windows
+
+package executor
+
+import (
+	"encoding/json"
+	"jstarpl/jpm/api"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecoverState_AdoptsAlivePids(t *testing.T) {
+	setProcesses()
+
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	data, _ := json.Marshal(stateFile{Processes: []ProcessState{
+		{Id: "1", Exec: "sleep", Status: api.Starting, Pid: cmd.Process.Pid},
+		{Id: "2", Exec: "sleep", Status: api.Stopped},
+	}})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if err := RecoverState(path); err != nil {
+		t.Fatalf("RecoverState: %v", err)
+	}
+
+	processesMu.RLock()
+	defer processesMu.RUnlock()
+	if proc := processes["1"]; proc == nil || proc.Status != api.Running || proc.Pid != cmd.Process.Pid {
+		t.Errorf("starting process with a live pid was not adopted: %+v", proc)
+	}
+	if proc := processes["2"]; proc != nil {
+		t.Errorf("stopped process without a pid was adopted")
+	}
+}
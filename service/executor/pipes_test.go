@@ -0,0 +1,40 @@
+//go:build linux
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"jstarpl/jpm/api"
+	"os"
+	"testing"
+	"time"
+)
+
+func openFiles(t *testing.T) int {
+	t.Helper()
+
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	return len(entries)
+}
+
+func TestProcessExited_ClosesPipes(t *testing.T) {
+	setProcesses()
+	before := openFiles(t)
+
+	proc := runToExit(t, "echo out; echo err >&2", nil, api.LogOptions{})
+	if err := WriteInput(context.Background(), proc.Id, []byte("late\n")); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("WriteInput after exit: %v, want ErrNotRunning", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for openFiles(t) > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d files left open", openFiles(t)-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readProcStat returns the state and start time (in clock ticks after boot)
+// of a process from /proc/<pid>/stat.
+func readProcStat(pid int) (state string, startTime uint64, err error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return "", 0, err
+	}
+
+	// The command name is in parentheses and may itself contain spaces or
+	// parentheses, so the remaining fields start after the last ')'.
+	stat := string(data)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return "", 0, errors.New("malformed stat")
+	}
+	fields := strings.Fields(stat[end+1:])
+	// fields[0] is field 3 (state), so field 22 (starttime) is fields[19].
+	if len(fields) < 20 {
+		return "", 0, errors.New("malformed stat")
+	}
+
+	startTime, err = strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return fields[0], startTime, nil
+}
+
+func processStartTime(pid int) (uint64, error) {
+	_, startTime, err := readProcStat(pid)
+	return startTime, err
+}
+
+// processAlive reports whether pid is still running and, when startTime is
+// known, that it is the same process rather than a reuse of the PID.
+func processAlive(pid int, startTime uint64) bool {
+	state, current, err := readProcStat(pid)
+	if err != nil || state == "Z" {
+		return false
+	}
+	return startTime == 0 || current == startTime
+}
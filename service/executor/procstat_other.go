@@ -0,0 +1,15 @@
+//go:build !linux && !windows
+
+package executor
+
+import "syscall"
+
+func processStartTime(pid int) (uint64, error) {
+	return 0, nil
+}
+
+// processAlive reports whether pid is still running. Without /proc a reused
+// PID cannot be told apart, so startTime is ignored.
+func processAlive(pid int, startTime uint64) bool {
+	return syscall.Kill(pid, 0) == nil
+}
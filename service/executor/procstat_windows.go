@@ -0,0 +1,18 @@
+package executor
+
+import "os"
+
+func processStartTime(pid int) (uint64, error) {
+	return 0, nil
+}
+
+// processAlive reports whether pid is still running. A reused PID cannot be
+// told apart, so startTime is ignored.
+func processAlive(pid int, startTime uint64) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	proc.Release()
+	return true
+}
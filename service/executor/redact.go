@@ -0,0 +1,88 @@
+package executor
+
+import (
+	"fmt"
+	"jstarpl/jpm/service/logger"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redactionMu guards the redaction settings, which are also read for
+// copies of processes, like the ones of events, without processesMu.
+var redactionMu sync.RWMutex
+var redactPatterns []*regexp.Regexp
+var secretEnv []string
+
+// SetRedaction configures what is masked in the output of processes before
+// it is logged, shown or sent to log sinks: matches of patterns, and the
+// values of environment variables whose names match one of the secretEnvNames
+// wildcards. It applies to running processes too.
+func SetRedaction(patterns []*regexp.Regexp, secretEnvNames []string) {
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	redactionMu.Lock()
+	redactPatterns = patterns
+	secretEnv = secretEnvNames
+	redactionMu.Unlock()
+
+	for _, proc := range processes {
+		proc.updateRedactor()
+	}
+}
+
+// ValidateSecretEnv checks wildcards naming secret environment variables.
+func ValidateSecretEnv(names []string) error {
+	for _, name := range names {
+		if _, err := path.Match(name, ""); err != nil {
+			return fmt.Errorf("invalid secret env pattern %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// updateRedactor sets up masking for the current configuration and the
+// environment of the process, which must be locked.
+func (proc *Process) updateRedactor() {
+	redactionMu.RLock()
+	patterns := redactPatterns
+	redactionMu.RUnlock()
+
+	r := logger.NewRedactor(patterns, proc.secrets())
+
+	proc.output.mu.Lock()
+	defer proc.output.mu.Unlock()
+
+	proc.redactor = r
+}
+
+// secrets returns the values of the secret environment variables of the process.
+func (proc *Process) secrets() []string {
+	var values []string
+	for _, variable := range proc.Env {
+		name, value, ok := strings.Cut(variable, "=")
+		if ok && isSecretEnv(name, proc.Log.SecretEnv) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// isSecretEnv reports whether the environment variable name matches one of
+// the service wildcards or the extra ones. Names are compared ignoring case.
+func isSecretEnv(name string, extra []string) bool {
+	redactionMu.RLock()
+	defer redactionMu.RUnlock()
+
+	name = strings.ToUpper(name)
+	for _, patterns := range [][]string{secretEnv, extra} {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(strings.ToUpper(pattern), name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
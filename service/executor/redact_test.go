@@ -0,0 +1,36 @@
+package executor
+
+import (
+	"jstarpl/jpm/api"
+	"regexp"
+	"testing"
+
+	"github.com/teivah/broadcast"
+)
+
+func TestPublishOutput_Redacts(t *testing.T) {
+	defer SetRedaction(nil, nil)
+
+	proc := &Process{
+		Id:        "0",
+		Env:       []string{"API_TOKEN=tok-123456", "HOME=/home/app", "DB_URL=postgres://app:pw@db"},
+		Log:       api.LogOptions{SecretEnv: []string{"db_*"}},
+		StdOutErr: broadcast.NewRelay[api.StdStreamMessage](),
+		output:    &scrollback{maxLines: 10, maxBytes: 1024},
+	}
+	setProcesses(proc)
+	SetRedaction([]*regexp.Regexp{regexp.MustCompile(`key=(\w+)`)}, []string{"*TOKEN*"})
+
+	l := proc.StdOutErr.Listener(4)
+	defer l.Close()
+
+	proc.publishOutput(stdout("tok-123456 /home/app postgres://app:pw@db key=abc\n"))
+
+	want := "[REDACTED] /home/app [REDACTED] key=[REDACTED]\n"
+	if live := <-l.Ch(); string(live.Data) != want {
+		t.Errorf("live output = %q, want %q", live.Data, want)
+	}
+	if kept := proc.output.since(0); string(kept[0].Data) != want {
+		t.Errorf("scrollback = %q, want %q", kept[0].Data, want)
+	}
+}
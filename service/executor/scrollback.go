@@ -0,0 +1,131 @@
+package executor
+
+import (
+	"jstarpl/jpm/api"
+	"sync"
+
+	"github.com/teivah/broadcast"
+)
+
+const (
+	defaultScrollbackLines = 1000
+	defaultScrollbackBytes = 1024 * 1024
+)
+
+var scrollbackLines = defaultScrollbackLines
+var scrollbackBytes = defaultScrollbackBytes
+
+// outputListenerCapacity buffers output for subscribers that replay the
+// scrollback first, so the live output does not overtake them.
+const outputListenerCapacity = 256
+
+// SetScrollback configures how much recent output is kept per process to be
+// replayed to new subscribers. Zero keeps no output.
+func SetScrollback(lines, bytes int) {
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	scrollbackLines = lines
+	scrollbackBytes = bytes
+
+	for _, proc := range processes {
+		proc.output.resize(lines, bytes)
+	}
+}
+
+// scrollback is a bounded buffer of the most recent output of a process.
+// Each message gets a sequence number, so a subscriber can resume after the
+// last message it saw.
+type scrollback struct {
+	mu       sync.Mutex
+	messages []api.StdStreamMessage
+	size     int
+	maxLines int
+	maxBytes int
+	lastSeq  uint64
+}
+
+func newScrollback() *scrollback {
+	return &scrollback{maxLines: scrollbackLines, maxBytes: scrollbackBytes}
+}
+
+// add numbers msg and keeps it. It must be called with mu held.
+func (s *scrollback) add(msg api.StdStreamMessage) api.StdStreamMessage {
+	s.lastSeq++
+	msg.Seq = s.lastSeq
+
+	s.messages = append(s.messages, msg)
+	s.size += len(msg.Data)
+	s.trim()
+
+	return msg
+}
+
+func (s *scrollback) trim() {
+	drop := 0
+	for drop < len(s.messages) && (len(s.messages)-drop > s.maxLines || s.size > s.maxBytes) {
+		s.size -= len(s.messages[drop].Data)
+		drop++
+	}
+	if drop > 0 {
+		clear(s.messages[:drop])
+		s.messages = s.messages[drop:]
+	}
+}
+
+func (s *scrollback) resize(lines, bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxLines = lines
+	s.maxBytes = bytes
+	s.trim()
+}
+
+// since returns the kept messages numbered after seq. It must be called with
+// mu held. A seq from before a service restart, higher than any message,
+// returns everything.
+func (s *scrollback) since(seq uint64) []api.StdStreamMessage {
+	if seq > s.lastSeq {
+		seq = 0
+	}
+
+	var result []api.StdStreamMessage
+	for _, msg := range s.messages {
+		if msg.Seq > seq {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
+
+// publishOutput numbers msg, keeps it in the scrollback and broadcasts it to
+// live subscribers.
+func (proc *Process) publishOutput(msg api.StdStreamMessage) {
+	proc.output.mu.Lock()
+	defer proc.output.mu.Unlock()
+
+	msg.Data = proc.redactor.Redact(msg.Data)
+	proc.StdOutErr.Broadcast(proc.output.add(msg))
+}
+
+// SubscribeOutput returns the kept output of a process numbered after
+// since, and a listener for the output that follows it. Pass 0 to get all
+// kept output. The caller must close the listener.
+func SubscribeOutput(Id string, since uint64) ([]api.StdStreamMessage, *broadcast.Listener[api.StdStreamMessage], error) {
+	// A process is only deleted, closing its relay, with processesMu locked.
+	processesMu.RLock()
+	defer processesMu.RUnlock()
+
+	proc := processes[Id]
+	if proc == nil {
+		return nil, nil, ErrProcessNotFound
+	}
+
+	// Holding the lock while subscribing makes sure no message is missed or
+	// seen twice between the replay and the live output.
+	proc.output.mu.Lock()
+	defer proc.output.mu.Unlock()
+
+	return proc.output.since(since), proc.StdOutErr.Listener(outputListenerCapacity), nil
+}
@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"jstarpl/jpm/api"
+	"testing"
+
+	"github.com/teivah/broadcast"
+)
+
+func stdout(data string) api.StdStreamMessage {
+	return api.StdStreamMessage{StreamType: api.Stdout, Data: []byte(data)}
+}
+
+func seqs(messages []api.StdStreamMessage) []uint64 {
+	var result []uint64
+	for _, msg := range messages {
+		result = append(result, msg.Seq)
+	}
+	return result
+}
+
+func TestScrollback_LimitsLines(t *testing.T) {
+	s := &scrollback{maxLines: 2, maxBytes: 1024}
+	for _, data := range []string{"a\n", "b\n", "c\n"} {
+		s.add(stdout(data))
+	}
+
+	got := s.since(0)
+	if len(got) != 2 || string(got[0].Data) != "b\n" || got[1].Seq != 3 {
+		t.Errorf("expected the last two lines, got %v", seqs(got))
+	}
+}
+
+func TestScrollback_LimitsBytes(t *testing.T) {
+	s := &scrollback{maxLines: 100, maxBytes: 5}
+	for _, data := range []string{"aa\n", "bb\n", "cc\n"} {
+		s.add(stdout(data))
+	}
+
+	if got := s.since(0); len(got) != 1 || string(got[0].Data) != "cc\n" || s.size != 3 {
+		t.Errorf("expected the last line only, got %v (%d bytes)", seqs(got), s.size)
+	}
+}
+
+func TestScrollback_Since(t *testing.T) {
+	s := &scrollback{maxLines: 100, maxBytes: 1024}
+	for _, data := range []string{"a\n", "b\n", "c\n"} {
+		s.add(stdout(data))
+	}
+
+	if got := seqs(s.since(1)); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("since(1) = %v, want [2 3]", got)
+	}
+	if got := s.since(3); len(got) != 0 {
+		t.Errorf("since(3) = %v, want nothing", seqs(got))
+	}
+	// A sequence number from before a service restart replays everything.
+	if got := s.since(42); len(got) != 3 {
+		t.Errorf("since(42) = %v, want everything", seqs(got))
+	}
+}
+
+func TestSubscribeOutput_ReplaysThenFollows(t *testing.T) {
+	proc := &Process{Id: "0", StdOutErr: broadcast.NewRelay[api.StdStreamMessage](), output: &scrollback{maxLines: 10, maxBytes: 1024}}
+	setProcesses(proc)
+
+	proc.publishOutput(stdout("before\n"))
+
+	replay, l, err := SubscribeOutput("0", 0)
+	if err != nil {
+		t.Fatalf("SubscribeOutput: %v", err)
+	}
+	defer l.Close()
+
+	proc.publishOutput(stdout("after\n"))
+
+	if len(replay) != 1 || string(replay[0].Data) != "before\n" {
+		t.Errorf("replay = %v", replay)
+	}
+	if live := <-l.Ch(); string(live.Data) != "after\n" || live.Seq != 2 {
+		t.Errorf("live = %q (seq %d)", live.Data, live.Seq)
+	}
+
+	if _, _, err := SubscribeOutput("1", 0); err == nil {
+		t.Errorf("expected an error for an unknown process")
+	}
+}
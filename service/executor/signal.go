@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"fmt"
+	"jstarpl/jpm/api"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ParseSignal reads a signal name like SIGHUP or HUP, or a signal number.
+func ParseSignal(name string) (os.Signal, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig, ok := signalNames[name]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrInvalidSignal, name)
+}
+
+// SignalName returns the name of sig, like SIGHUP.
+func SignalName(sig os.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
+
+// SignalProcess sends sig to a running process, or with group to the
+// process group it leads, which includes the children it did not move to
+// a group of their own.
+func SignalProcess(Id string, sig os.Signal, group bool) error {
+	if err := beginChange(); err != nil {
+		return err
+	}
+	defer endChange()
+
+	processesMu.RLock()
+	defer processesMu.RUnlock()
+
+	proc, ok := processes[Id]
+	if !ok {
+		return ErrProcessNotFound
+	}
+	if proc.Status <= api.Stopped {
+		return ErrNotRunning
+	}
+
+	osProc, err := proc.osProcess()
+	if err != nil {
+		return ErrNotRunning
+	}
+
+	if group {
+		execLog.Printf("Sending %s to the process group of %s", SignalName(sig), proc.Exec)
+		return signalGroup(osProc.Pid, sig)
+	}
+	execLog.Printf("Sending %s to %s", SignalName(sig), proc.Exec)
+	return osProc.Signal(sig)
+}
@@ -0,0 +1,82 @@
+//go:build !windows
+
+package executor
+
+import (
+	"errors"
+	"jstarpl/jpm/api"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		name string
+		want syscall.Signal
+	}{
+		{"SIGHUP", syscall.SIGHUP},
+		{"hup", syscall.SIGHUP},
+		{" usr1 ", syscall.SIGUSR1},
+		{"SigTerm", syscall.SIGTERM},
+		{"9", syscall.SIGKILL},
+	}
+	for _, tt := range tests {
+		sig, err := ParseSignal(tt.name)
+		if err != nil || sig != tt.want {
+			t.Errorf("ParseSignal(%q) = %v, %v, want %v", tt.name, sig, err, tt.want)
+		}
+	}
+
+	for _, name := range []string{"", "SIG", "NOPE", "0", "-1"} {
+		if _, err := ParseSignal(name); !errors.Is(err, ErrInvalidSignal) {
+			t.Errorf("ParseSignal(%q) error = %v", name, err)
+		}
+	}
+}
+
+func TestSignalProcess_Group(t *testing.T) {
+	setProcesses()
+
+	// Started processes lead a group of their own, so that signalling the
+	// group reaches the children of the shell but not the service.
+	proc, err := StartProcess("group", "", "sh", []string{"-c", "sleep 60; exit 0"}, "", nil, api.LogOptions{})
+	if err != nil {
+		t.Fatalf("StartProcess: %v", err)
+	}
+	defer DeleteProcess(proc.Id)
+
+	detail, err := DescribeProcess(proc.Id)
+	if err != nil {
+		t.Fatalf("DescribeProcess: %v", err)
+	}
+	pid := detail.Pid
+	if pgid, err := syscall.Getpgid(pid); err != nil || pgid != pid {
+		t.Fatalf("process group = %d, %v, want %d", pgid, err, pid)
+	}
+
+	events := SubscribeEvents()
+	defer events.Close()
+
+	if err := SignalProcess(proc.Id, syscall.SIGTERM, true); err != nil {
+		t.Fatalf("SignalProcess: %v", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for exited := false; !exited; {
+		select {
+		case e := <-events.Ch():
+			if e.ProcessExitedEvent != nil && e.ProcessExitedEvent.Process.Id == proc.Id {
+				if e.ProcessExitedEvent.Signal != "SIGTERM" {
+					t.Errorf("process exited by %q, want SIGTERM", e.ProcessExitedEvent.Signal)
+				}
+				exited = true
+			}
+		case <-timeout:
+			t.Fatalf("process %d still runs after signalling its group", pid)
+		}
+	}
+
+	if err := SignalProcess("99999", syscall.SIGTERM, false); !errors.Is(err, ErrProcessNotFound) {
+		t.Errorf("signalling an unknown process = %v", err)
+	}
+}
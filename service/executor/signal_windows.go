@@ -0,0 +1,29 @@
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// signalNames are the signals that can be sent by name. Windows can only
+// deliver SIGKILL.
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup fails, Windows has no process groups to signal.
+func signalGroup(pid int, sig os.Signal) error {
+	return ErrNoProcessGroup
+}
+
+// processGroup returns 0, Windows has no process groups.
+func processGroup(pid int) int {
+	return 0
+}
@@ -0,0 +1,222 @@
+package executor
+
+import (
+	"jstarpl/jpm/api"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/teivah/broadcast"
+)
+
+const adoptedPollInterval = 500 * time.Millisecond
+
+// changesMu is read-locked by every change to processes, and locked while
+// draining is set so that Drain waits for the changes in progress. It is
+// taken before processesMu.
+var (
+	changesMu sync.RWMutex
+	draining  bool
+)
+
+// ProcessState is the serializable part of a Process. It is used to hand
+// processes over to another service instance.
+type ProcessState struct {
+	Id           string            `json:"id"`
+	Name         string            `json:"name,omitempty"`
+	Namespace    string            `json:"namespace,omitempty"`
+	Exec         string            `json:"exec"`
+	Arg          []string          `json:"args,omitempty"`
+	Env          []string          `json:"env,omitempty"`
+	Dir          string            `json:"cwd,omitempty"`
+	Status       api.Status        `json:"status"`
+	ExitCode     int               `json:"exitCode"`
+	StartCount   int               `json:"startCount,omitempty"`
+	FailCount    int               `json:"failCount,omitempty"`
+	RespawnDelay int               `json:"respawnDelay,omitempty"`
+	Restart      RestartPolicy     `json:"restartPolicy,omitempty"`
+	LastStarted  time.Time         `json:"lastStarted"`
+	Pid          int               `json:"pid,omitempty"`
+	StartTime    uint64            `json:"startTime,omitempty"`
+	Log          api.LogOptions    `json:"log"`
+	Exits        []api.ProcessExit `json:"exits,omitempty"`
+	// HasPipes is set when the stdout, stderr and stdin pipes of the process
+	// are passed along with the state.
+	HasPipes bool `json:"hasPipes,omitempty"`
+}
+
+// state returns the state of the process, which must be locked.
+func (proc *Process) state() ProcessState {
+	return ProcessState{
+		Id:           proc.Id,
+		Name:         proc.Name,
+		Namespace:    proc.Namespace,
+		Exec:         proc.Exec,
+		Arg:          proc.Arg,
+		Env:          proc.Env,
+		Dir:          proc.Dir,
+		Status:       proc.Status,
+		ExitCode:     proc.ExitCode,
+		StartCount:   proc.StartCount,
+		FailCount:    proc.FailCount,
+		RespawnDelay: proc.RespawnDelay,
+		Restart:      proc.Restart,
+		LastStarted:  proc.LastStarted,
+		Pid:          proc.Pid,
+		StartTime:    proc.StartTime,
+		Log:          proc.Log,
+		Exits:        proc.Exits,
+		HasPipes:     proc.stdout != nil && proc.stderr != nil && proc.stdin != nil,
+	}
+}
+
+// Snapshot returns the state of every managed process.
+func Snapshot() []ProcessState {
+	processesMu.RLock()
+	defer processesMu.RUnlock()
+
+	result := make([]ProcessState, 0, len(processes))
+	for _, proc := range processes {
+		result = append(result, proc.state())
+	}
+	return result
+}
+
+// Drain makes changes to processes fail with ErrDraining, including respawns,
+// and returns once the changes in progress are done. Used before the
+// processes are handed over, so that the snapshot stays accurate.
+func Drain() {
+	changesMu.Lock()
+	defer changesMu.Unlock()
+	draining = true
+}
+
+// Resume undoes Drain after a failed handover.
+func Resume() {
+	changesMu.Lock()
+	defer changesMu.Unlock()
+	draining = false
+}
+
+// beginChange must be called before changing a process, and endChange once
+// done unless it returned an error.
+func beginChange() error {
+	changesMu.RLock()
+	if draining {
+		changesMu.RUnlock()
+		return ErrDraining
+	}
+	return nil
+}
+
+func endChange() {
+	changesMu.RUnlock()
+}
+
+// ProcessPipes returns the service side of the stdout, stderr and stdin
+// pipes of a process, or nil if they are not available.
+func ProcessPipes(Id string) []*os.File {
+	processesMu.RLock()
+	defer processesMu.RUnlock()
+
+	proc := processes[Id]
+	if proc == nil || proc.stdout == nil || proc.stderr == nil || proc.stdin == nil {
+		return nil
+	}
+	return []*os.File{proc.stdout, proc.stderr, proc.stdin}
+}
+
+// DetachAll closes the service side of all process pipes so this service
+// stops relaying output, leaving the processes running. Used after the
+// processes have been handed over to another service.
+func DetachAll() {
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	for _, proc := range processes {
+		proc.detachPipes()
+	}
+}
+
+// Adopt registers a process from its state. When state.Pid is still alive the
+// process is supervised as Running and, if given, its pipes are relayed;
+// otherwise it is treated as having exited while nobody was watching.
+// No lifecycle events are emitted for the adoption itself.
+func Adopt(state ProcessState, stdout, stderr, stdin *os.File) *Process {
+	processesMu.Lock()
+	defer processesMu.Unlock()
+
+	proc := &Process{
+		Id:           state.Id,
+		Name:         state.Name,
+		Namespace:    state.Namespace,
+		Exec:         state.Exec,
+		Arg:          state.Arg,
+		Env:          state.Env,
+		Dir:          state.Dir,
+		Status:       state.Status,
+		ExitCode:     state.ExitCode,
+		StartCount:   state.StartCount,
+		FailCount:    state.FailCount,
+		RespawnDelay: state.RespawnDelay,
+		Restart:      state.Restart,
+		LastStarted:  state.LastStarted,
+		Log:          state.Log,
+		Exits:        state.Exits,
+		StdOutErr:    broadcast.NewRelay[api.StdStreamMessage](),
+		StdIn:        broadcast.NewRelay[api.StdStreamMessage](),
+		output:       newScrollback(),
+		ops:          &sync.Mutex{},
+	}
+	processes[proc.Id] = proc
+
+	proc.updateRedactor()
+	proc.startLogger()
+	logRelayToSinks(proc)
+
+	if state.Pid == 0 {
+		return proc
+	}
+
+	if !processAlive(state.Pid, state.StartTime) {
+		execLog.Printf("Process %s (pid %d) is gone, not adopting", proc.Id, state.Pid)
+		for _, f := range []*os.File{stdout, stderr, stdin} {
+			if f != nil {
+				f.Close()
+			}
+		}
+		if proc.Status > api.Stopped {
+			proc.recordExit(api.ProcessExit{Time: time.Now(), Reason: api.ExitLost, ExitCode: -1, Uptime: int(time.Since(proc.LastStarted).Milliseconds())})
+			proc.Status = api.Respawn
+			proc.FailCount++
+			if proc.shouldRestart() {
+				go respawnProcess(proc)
+			}
+		}
+		return proc
+	}
+
+	execLog.Printf("Adopting %s as %s (pid %d)", proc.Exec, proc.Id, state.Pid)
+
+	proc.Pid = state.Pid
+	proc.StartTime = state.StartTime
+	proc.adopted = true
+	proc.exited = make(chan struct{})
+	proc.Status = api.Running
+	proc.attachPipes(stdout, stderr, stdin)
+
+	go monitorAdopted(proc, proc.Pid, proc.StartTime, proc.exited)
+
+	return proc
+}
+
+// monitorAdopted polls an adopted process, which is not a child of this
+// service and so cannot be waited for, until the run identified by exited
+// ends. Its exit code is unknown.
+func monitorAdopted(proc *Process, pid int, startTime uint64, exited chan struct{}) {
+	for processAlive(pid, startTime) {
+		time.Sleep(adoptedPollInterval)
+	}
+
+	processExited(proc, exited, -1, nil)
+}
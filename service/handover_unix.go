@@ -0,0 +1,306 @@
+//go:build !windows
+
+package service
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	handoverTimeout = 10 * time.Second
+	// The old service exits once the new one acknowledged the handover,
+	// which can take a while if it is still answering a request.
+	handoverReleaseTimeout = 30 * time.Second
+	handoverAck            = "ok\n"
+	pipesPerProcess        = 3
+)
+
+// upgradeMu makes upgrades run one at a time, other requests run alongside
+// them.
+var (
+	upgradeMu  sync.Mutex
+	handedOver bool
+)
+
+type handoverHeader struct {
+	Processes []executor.ProcessState `json:"processes"`
+}
+
+// upgradeService starts execPath as a new service and hands all processes,
+// including their stdio pipes, over to it through a Unix socket. On success
+// the caller must exit without stopping any processes, which can no longer
+// be changed.
+//
+// Protocol: the old service sends a length-prefixed JSON handoverHeader,
+// then one single-byte message per process with HasPipes carrying its
+// stdout, stderr and stdin as SCM_RIGHTS. The new service replies with
+// handoverAck and takes over once the old service closed the connection.
+func upgradeService(execPath string) error {
+	upgradeMu.Lock()
+	defer upgradeMu.Unlock()
+	if handedOver {
+		return errors.New("the processes were already handed over")
+	}
+
+	if execPath == "" {
+		var err error
+		execPath, err = os.Executable()
+		if err != nil {
+			return fmt.Errorf("could not determine executable: %w", err)
+		}
+	}
+
+	// Processes must not change from the snapshot on until this service
+	// exits, requests to change them are rejected meanwhile.
+	executor.Drain()
+	defer func() {
+		if !handedOver {
+			executor.Resume()
+		}
+	}()
+
+	// The socket is in the runtime directory of the user, which only they
+	// can access, so only they can connect to it from the moment it exists.
+	dir := filepath.Dir(api.DefaultSocketPath())
+	if err := checkSocketDir(dir); err != nil {
+		return fmt.Errorf("could not create handover socket in %s: %w", dir, err)
+	}
+	sockPath := filepath.Join(dir, fmt.Sprintf("handover-%d.sock", os.Getpid()))
+	os.Remove(sockPath)
+
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: sockPath, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", sockPath, err)
+	}
+	defer ln.Close()
+	if err := os.Chmod(sockPath, 0600); err != nil {
+		return fmt.Errorf("could not restrict access to %s: %w", sockPath, err)
+	}
+
+	cmd := exec.Command(execPath, append(serviceStartArgs(), "--handover", sockPath)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start %s: %w", execPath, err)
+	}
+	go cmd.Wait()
+
+	ln.SetDeadline(time.Now().Add(handoverTimeout))
+	conn, err := acceptHandover(ln, cmd.Process.Pid)
+	if err != nil {
+		cmd.Process.Kill()
+		return fmt.Errorf("new service did not connect: %w", err)
+	}
+	// On success the connection is left open: it is closed when this process
+	// exits, which is what the new service waits for.
+	defer func() {
+		if !handedOver {
+			conn.Close()
+		}
+	}()
+	conn.SetDeadline(time.Now().Add(handoverTimeout))
+
+	states := executor.Snapshot()
+	if err := writeHandoverHeader(conn, handoverHeader{Processes: states}); err != nil {
+		cmd.Process.Kill()
+		return err
+	}
+
+	for _, state := range states {
+		if !state.HasPipes {
+			continue
+		}
+		fds, err := fileDescriptors(executor.ProcessPipes(state.Id))
+		if err != nil {
+			cmd.Process.Kill()
+			return fmt.Errorf("could not pass pipes of %s: %w", state.Id, err)
+		}
+		if _, _, err := conn.WriteMsgUnix([]byte{0}, syscall.UnixRights(fds...), nil); err != nil {
+			cmd.Process.Kill()
+			return fmt.Errorf("could not pass pipes of %s: %w", state.Id, err)
+		}
+	}
+
+	ack, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || ack != handoverAck {
+		cmd.Process.Kill()
+		return fmt.Errorf("new service did not acknowledge handover: %v", err)
+	}
+
+	executor.DetachAll()
+	handedOver = true
+	log.Default().Printf("Handed %d processes over to pid %d", len(states), cmd.Process.Pid)
+
+	return nil
+}
+
+// acceptHandover accepts the connection of the new service with the process
+// pid, rejecting any other process. Where the process on the other end can't
+// be told, only the permissions of the socket keep others out.
+func acceptHandover(ln *net.UnixListener, pid int) (*net.UnixConn, error) {
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+		peerPid, peerUid, ok := peerCred(conn)
+		if !ok || (peerPid == pid && peerUid == os.Getuid()) {
+			return conn, nil
+		}
+		log.Default().Printf("Rejected handover to pid %d of user %d", peerPid, peerUid)
+		conn.Close()
+	}
+}
+
+// receiveHandover connects to the old service, adopts its processes and
+// returns once the old service has exited.
+func receiveHandover(sockPath string) error {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: sockPath, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", sockPath, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handoverTimeout))
+
+	header, err := readHandoverHeader(conn)
+	if err != nil {
+		return err
+	}
+
+	pipes := make(map[string][]*os.File)
+	for _, state := range header.Processes {
+		if !state.HasPipes {
+			continue
+		}
+		files, err := readHandoverPipes(conn)
+		if err != nil {
+			return fmt.Errorf("could not receive pipes of %s: %w", state.Id, err)
+		}
+		pipes[state.Id] = files
+	}
+
+	if _, err := io.WriteString(conn, handoverAck); err != nil {
+		return err
+	}
+
+	// Wait for the old service to exit so it no longer reads from the pipes
+	// and has released the IPC channel and HTTP listener.
+	conn.SetDeadline(time.Now().Add(handoverReleaseTimeout))
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		return fmt.Errorf("old service did not exit: %w", err)
+	}
+
+	for _, state := range header.Processes {
+		if files, ok := pipes[state.Id]; ok {
+			executor.Adopt(state, files[0], files[1], files[2])
+		} else {
+			executor.Adopt(state, nil, nil, nil)
+		}
+	}
+
+	log.Default().Printf("Took over %d processes", len(header.Processes))
+
+	return nil
+}
+
+// fileDescriptors returns the descriptors of files without switching them to
+// blocking mode, which os.File.Fd would do for both services.
+func fileDescriptors(files []*os.File) ([]int, error) {
+	fds := make([]int, len(files))
+	for i, f := range files {
+		raw, err := f.SyscallConn()
+		if err != nil {
+			return nil, err
+		}
+		if err := raw.Control(func(fd uintptr) { fds[i] = int(fd) }); err != nil {
+			return nil, err
+		}
+	}
+	return fds, nil
+}
+
+func writeHandoverHeader(w io.Writer, header handoverHeader) error {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func readHandoverHeader(r io.Reader) (handoverHeader, error) {
+	var header handoverHeader
+
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return header, fmt.Errorf("could not read handover header: %w", err)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return header, fmt.Errorf("could not read handover header: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &header); err != nil {
+		return header, fmt.Errorf("could not decode handover header: %w", err)
+	}
+
+	return header, nil
+}
+
+func readHandoverPipes(conn *net.UnixConn) ([]*os.File, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(pipesPerProcess*4))
+
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 {
+		return nil, errors.New("expected a single control message")
+	}
+
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) != pipesPerProcess {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return nil, fmt.Errorf("expected %d file descriptors, got %d", pipesPerProcess, len(fds))
+	}
+
+	names := []string{"stdout", "stderr", "stdin"}
+	files := make([]*os.File, len(fds))
+	for i, fd := range fds {
+		files[i] = os.NewFile(uintptr(fd), names[i])
+	}
+
+	return files, nil
+}
@@ -0,0 +1,13 @@
+package service
+
+import "errors"
+
+var errHandoverUnsupported = errors.New("service upgrade is not supported on Windows")
+
+func upgradeService(execPath string) error {
+	return errHandoverUnsupported
+}
+
+func receiveHandover(sockPath string) error {
+	return errHandoverUnsupported
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// openAPISpec describes the HTTP API. Routes and responses are checked
+// against it in the contract tests.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// stdinTimeout is how long input sent over HTTP waits for the process to
+// read it.
+const stdinTimeout = 5 * time.Second
+
+var processIdPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// sendResult writes a successful response.
+func sendResult(c fiber.Ctx, status int, result *api.ResponseResult) error {
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	return c.Status(status).JSON(api.SuccessResponse(api.NumberID(0), result))
+}
+
+// sendError writes an error response, with the HTTP status as error code.
+func sendError(c fiber.Ctx, status int, message string) error {
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	return c.Status(status).JSON(api.ErrorResponse(api.NumberID(0), status, message))
+}
+
+// errorStatus is the HTTP status for an error of the executor.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, executor.ErrProcessNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, executor.ErrNotRunning), errors.Is(err, executor.ErrLoggingDisabled), errors.Is(err, executor.ErrNoProcessGroup):
+		return fiber.StatusConflict
+	case errors.Is(err, executor.ErrInvalidOptions), errors.Is(err, executor.ErrInvalidSignal):
+		return fiber.StatusBadRequest
+	case errors.Is(err, executor.ErrDraining):
+		return fiber.StatusServiceUnavailable
+	}
+	return fiber.StatusInternalServerError
+}
+
+// processIdParam returns the :id of the route, or an error if it can't be
+// the id of a process.
+func processIdParam(c fiber.Ctx) (string, error) {
+	id := c.Params("id")
+	if !processIdPattern.MatchString(id) {
+		return "", fmt.Errorf("Invalid process id %q", id)
+	}
+	return id, nil
+}
+
+// lastEventIdParam returns the sequence number of the last output a client
+// got, which browsers send when reconnecting, or 0.
+func lastEventIdParam(c fiber.Ctx) (uint64, error) {
+	lastEventId := c.Get("Last-Event-ID", c.Query("lastEventId"))
+	if lastEventId == "" {
+		return 0, nil
+	}
+	since, err := strconv.ParseUint(lastEventId, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid last event id %q", lastEventId)
+	}
+	return since, nil
+}
+
+// decodeBody decodes the JSON body of the request into v, rejecting unknown
+// fields.
+func decodeBody(c fiber.Ctx, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("Invalid request body: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	ipc "github.com/james-barrow/golang-ipc"
+)
+
+var ipcLog = log.New(log.Default().Writer(), "ipc: ", logProps)
+
+func startIPCServer() {
+	startSocketServer()
+	// The `jpm-ipc` channel is the same for all services, only the default
+	// instance on the default socket takes it, and only if asked to: any user
+	// can connect to it.
+	if currentOptions().LegacyIPC && api.IsDefaultInstance() && api.SocketPath() == api.DefaultSocketPath() {
+		startLegacyIPCServer()
+	}
+}
+
+// startSocketServer listens on the socket of the service. Every connection is
+// a session of its own, so concurrent clients each get their own responses.
+func startSocketServer() {
+	path := api.SocketPath()
+	if err := checkSocketDir(filepath.Dir(path)); err != nil {
+		panic(fmt.Sprintf("Could not listen on %s: %v", path, err))
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		panic(fmt.Sprintf("Could not listen on %s. Check if the service isn't already running.", path))
+	}
+	// Left behind by a service that did not shut down cleanly.
+	os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		panic(fmt.Sprintf("Could not listen on %s: %v", path, err))
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		panic(fmt.Sprintf("Could not restrict access to %s: %v", path, err))
+	}
+	ipcLog.Printf("Listening on %s", path)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				ipcLog.Fatalf("Error accepting IPC connection: %v", err)
+			}
+			if uid, ok := peerUID(conn); ok && !sessionAllowed(uid) {
+				ipcLog.Printf("Rejected session of user %d", uid)
+				res, _ := api.NewErrorResponse(api.ID{}, int(api.ServerError), "Permission denied")
+				conn.Write(append(res, '\n'))
+				conn.Close()
+				continue
+			}
+			go serveSession(conn)
+		}
+	}()
+}
+
+// sessionAllowed tells if a user may talk to the service: the user running
+// it and root.
+func sessionAllowed(uid int) bool {
+	return uid == os.Getuid() || uid == 0
+}
+
+// serveSession answers the messages of a single connection, one JSON-RPC
+// message per line, in the order they arrive.
+func serveSession(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), api.MaxMessageSize)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		res := handleMessage(scanner.Bytes())
+		if res == nil {
+			continue
+		}
+		if _, err := conn.Write(append(res, '\n')); err != nil {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		ipcLog.Printf("Closing session: %v", err)
+	}
+}
+
+// startLegacyIPCServer serves clients of earlier versions, which only know the
+// `jpm-ipc` channel. It talks to one client at a time.
+func startLegacyIPCServer() {
+	server, err := ipc.StartServer(api.IPCName, nil)
+	if err != nil {
+		panic("Could not open `jpm-ipc` IPC channel. Check if the service isn't already running.")
+	}
+
+	go (func() {
+		for {
+			data, err := server.Read()
+			if err != nil {
+				ipcLog.Fatalf("Error reading from IPC: %v", err)
+			}
+
+			// ipcLog.Printf("Message received: %d %v Length %d %v", data.MsgType, data.Status, len(data.Data), string(data.Data[:]))
+
+			if data.MsgType > 0 {
+				if res := handleMessage(data.Data); res != nil {
+					server.Write(api.MsgType, res)
+				}
+			}
+		}
+	})()
+}
+
+// handleMessage answers a JSON-RPC message of any session.
+func handleMessage(data []byte) []byte {
+	return api.HandleMessage(data, func(e api.Request) api.Response {
+		ipcLog.Printf("Method requested %s", e.Method)
+		return handleRequest(e)
+	})
+}
+
+// decodeParams decodes the params of a request into params, which are left
+// as they are when the request has none.
+func decodeParams(raw json.RawMessage, params any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, params)
+}
+
+// handleRequest runs a single JSON-RPC request. The id of the response is
+// set by api.HandleMessage.
+func handleRequest(e api.Request) api.Response {
+	switch e.Method {
+	case api.ListProcesses:
+		list := executor.ListProcesses()
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{
+			ProcessList: list,
+		})
+	case api.StartProcess:
+		var params api.RequestStartProcessParams
+		if err := decodeParams(e.Params, &params); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		}
+		proc, err := executor.StartProcess(params.Name, params.Namespace, params.Exec, params.Arg, params.Dir, params.Env, params.LogOptions)
+		if err != nil {
+			return api.ErrorResponse(api.ID{}, errorStatus(err), fmt.Sprintf("Could not start process: %v", err))
+		}
+
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{Success: stringPtr("Process started"), ProcessId: &proc.Id})
+	case api.StopProcess:
+		var params api.RequestStopProcessParams
+		if err := decodeParams(e.Params, &params); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		}
+		if err := executor.StopProcess(params.Id); err != nil {
+			return api.ErrorResponse(api.ID{}, errorStatus(err), fmt.Sprintf("Could not stop process: %v", err))
+		}
+
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{Success: stringPtr("Process stopped")})
+	case api.RestartProcess:
+		var params api.RequestRestartProcessParams
+		if err := decodeParams(e.Params, &params); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		}
+		if err := executor.RestartProcess(params.Id); err != nil {
+			return api.ErrorResponse(api.ID{}, errorStatus(err), fmt.Sprintf("Could not restart process: %v", err))
+		}
+
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{Success: stringPtr("Process restarted")})
+	case api.DeleteProcess:
+		var params api.RequestDeleteProcessParams
+		if err := decodeParams(e.Params, &params); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		}
+		if err := executor.DeleteProcess(params.Id); err != nil {
+			return api.ErrorResponse(api.ID{}, errorStatus(err), fmt.Sprintf("Could not delete process: %v", err))
+		}
+
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{Success: stringPtr("Process deleted")})
+	case api.SignalProcess:
+		var params api.RequestSignalProcessParams
+		if err := decodeParams(e.Params, &params); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		}
+		result, err := signalProcesses(params)
+		if errors.Is(err, executor.ErrInvalidSignal) || errors.Is(err, executor.ErrInvalidOptions) {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		} else if err != nil {
+			res := api.ErrorResponse(api.ID{}, errorStatus(err), fmt.Sprintf("Could not signal process: %v", err))
+			if result != nil {
+				// The processes that were signalled.
+				data, _ := json.Marshal(result)
+				raw := json.RawMessage(data)
+				res.Error.Data = &raw
+			}
+			return res
+		}
+
+		return api.SuccessResponse(api.ID{}, result)
+	case api.DescribeProcess:
+		var params api.RequestDescribeProcessParams
+		if err := decodeParams(e.Params, &params); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		}
+		details, err := describeProcesses(params)
+		if errors.Is(err, executor.ErrInvalidOptions) {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		} else if err != nil {
+			return api.ErrorResponse(api.ID{}, errorStatus(err), fmt.Sprintf("Could not describe process: %v", err))
+		}
+
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{ProcessDetails: &details})
+	case api.RequestStopService:
+		// Exit once the response, which may be part of a batch, is written.
+		go func() {
+			time.Sleep(ipcFlushDelay)
+			executor.FlushLogs()
+			log.Default().Fatalf("Shutdown requested over IPC")
+		}()
+
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{Success: stringPtr("Shutting down")})
+	case api.UpgradeService:
+		var params api.RequestUpgradeServiceParams
+		if err := decodeParams(e.Params, &params); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		}
+		if err := upgradeService(params.Exec); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.ServerError), fmt.Sprintf("Could not upgrade service: %v", err))
+		}
+
+		// Give the response a moment to reach the client, the new
+		// service only starts serving once this one is gone.
+		go func() {
+			time.Sleep(ipcFlushDelay)
+			executor.FlushLogs()
+			log.Default().Printf("Exiting after handover")
+			os.Exit(0)
+		}()
+
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{Success: stringPtr("Service upgraded")})
+	case api.ReloadService:
+		if err := reloadService(); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.ServerError), fmt.Sprintf("Could not reload configuration: %v", err))
+		}
+
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{Success: stringPtr("Configuration reloaded")})
+	case api.QueryLogs:
+		var params api.RequestQueryLogsParams
+		if err := decodeParams(e.Params, &params); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		}
+		query, err := logQuery(params)
+		if err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid query: %v", err))
+		}
+
+		result, err := queryLogsResult(params, query)
+		if err != nil {
+			return api.ErrorResponse(api.ID{}, errorStatus(err), fmt.Sprintf("Could not query logs: %v", err))
+		}
+
+		return api.SuccessResponse(api.ID{}, result)
+	case api.PruneLogs:
+		var params api.RequestPruneLogsParams
+		if err := decodeParams(e.Params, &params); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		}
+		before, err := pruneBefore(params)
+		if err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		}
+
+		result, err := pruneLogs(before, params.DryRun)
+		if err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.ServerError), fmt.Sprintf("Could not prune logs: %v", err))
+		}
+
+		return api.SuccessResponse(api.ID{}, result)
+	case api.GetServiceInfo:
+		info := api.LocalServiceInfo()
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{ServiceInfo: &info})
+	case api.SaveProcessList:
+		entries := saveProcessList()
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{SaveEntries: &entries})
+	case api.RestoreProcessList:
+		var params api.RequestRestoreProcessListParams
+		if err := decodeParams(e.Params, &params); err != nil {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
+		}
+		if len(params.Entries) == 0 {
+			return api.ErrorResponse(api.ID{}, int(api.InvalidParams), "entries must not be empty")
+		}
+		restoreProcessList(params.Entries)
+
+		return api.SuccessResponse(api.ID{}, &api.ResponseResult{Success: stringPtr("Process list restored")})
+	default:
+		return api.ErrorResponse(api.ID{}, int(api.MethodNotFound), "Method not found")
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+	"jstarpl/jpm/service/logger"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+func TestServeSession_Routing(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			client, server := net.Pipe()
+			defer client.Close()
+			go serveSession(server)
+
+			reader := bufio.NewReader(client)
+			for n := range 5 {
+				id := fmt.Sprintf("session-%d-%d", i, n)
+				fmt.Fprintf(client, `{"jsonrpc": "2.0", "method": "listProcesses", "id": %q}`+"\n", id)
+				line, err := reader.ReadBytes('\n')
+				if err != nil {
+					t.Errorf("%s: %v", id, err)
+					return
+				}
+				res, err := api.UnmarshalResponse(line)
+				if err != nil {
+					t.Errorf("%s: %v", id, err)
+					return
+				}
+				if !res.MsgID.Equal(api.StringID(id)) {
+					t.Errorf("got response %s, want %s", res.MsgID, id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestServeSession_Notification(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go serveSession(server)
+
+	// Only the request after the notification is answered.
+	fmt.Fprintln(client, `{"jsonrpc": "2.0", "method": "listProcesses"}`)
+	fmt.Fprintln(client, `{"jsonrpc": "2.0", "method": "unknown", "id": 1}`)
+	line, err := bufio.NewReader(client).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("ReadBytes: %v", err)
+	}
+	res, err := api.UnmarshalResponse(line)
+	if err != nil {
+		t.Fatalf("UnmarshalResponse: %v", err)
+	}
+	if !res.MsgID.Equal(api.NumberID(1)) || res.Error == nil || res.Error.Code != int(api.MethodNotFound) {
+		t.Errorf("response = %s", line)
+	}
+}
+
+func TestServeSession_ServiceInfo(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go serveSession(server)
+
+	fmt.Fprintln(client, `{"jsonrpc": "2.0", "method": "getServiceInfo", "id": 1}`)
+	line, err := bufio.NewReader(client).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("ReadBytes: %v", err)
+	}
+	res, err := api.UnmarshalResponse(line)
+	if err != nil {
+		t.Fatalf("UnmarshalResponse: %v", err)
+	}
+	if res.Result == nil || res.Result.ServiceInfo == nil {
+		t.Fatalf("response = %s", line)
+	}
+	if info := res.Result.ServiceInfo; info.APIVersion != api.APIVersion || !info.Supports(api.GetServiceInfo) {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestListProcesses_MasksSecretEnv(t *testing.T) {
+	env := []string{"API_TOKEN=tok-123", "HOME=/home/app"}
+	proc, err := executor.StartProcess("secret", "", "sleep", []string{"60"}, "", env, api.LogOptions{SecretEnv: []string{"API_*"}})
+	if err != nil {
+		t.Fatalf("StartProcess: %v", err)
+	}
+	t.Cleanup(func() { executor.DeleteProcess(proc.Id) })
+
+	masked := []string{"API_TOKEN=" + logger.RedactedText, "HOME=/home/app"}
+	find := func(list []api.Process) []string {
+		for _, p := range list {
+			if p.Id == proc.Id {
+				return p.Env
+			}
+		}
+		t.Fatalf("process %s is not listed", proc.Id)
+		return nil
+	}
+
+	res := handleRequest(api.Request{Method: api.ListProcesses})
+	if got := find(*res.Result.ProcessList); !slices.Equal(got, masked) {
+		t.Errorf("IPC env = %q, want %q", got, masked)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/processes", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+testToken)
+	httpRes, err := newTestApp(t).Test(req)
+	if err != nil {
+		t.Fatalf("GET /api/processes: %v", err)
+	}
+	defer httpRes.Body.Close()
+	var body api.Response
+	if err := json.NewDecoder(httpRes.Body).Decode(&body); err != nil {
+		t.Fatalf("GET /api/processes: %v", err)
+	}
+	if got := find(*body.Result.ProcessList); !slices.Equal(got, masked) {
+		t.Errorf("HTTP env = %q, want %q", got, masked)
+	}
+
+	// Saved processes keep their environment to be restored.
+	for _, entry := range saveProcessList() {
+		if entry.Name == "secret" && !slices.Equal(entry.Env, env) {
+			t.Errorf("saved env = %q, want %q", entry.Env, env)
+		}
+	}
+}
+
+func TestHandleRequest_InvalidParams(t *testing.T) {
+	methods := []api.MethodName{
+		api.StartProcess, api.StopProcess, api.RestartProcess, api.DeleteProcess,
+		api.SignalProcess, api.DescribeProcess, api.UpgradeService, api.QueryLogs,
+		api.PruneLogs, api.RestoreProcessList,
+	}
+	for _, method := range methods {
+		res := handleRequest(api.Request{Method: method, Params: json.RawMessage(`{"id": 1`)})
+		if res.Error == nil || res.Error.Code != int(api.InvalidParams) {
+			t.Errorf("%s with malformed params: %+v, want invalid params", method, res.Error)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"jstarpl/jpm/api"
+	"time"
+)
+
+// Format is the format of process log files.
+type Format string
+
+const (
+	// FormatText prefixes each write with a timestamp and the stream name.
+	FormatText Format = "text"
+	// FormatRaw writes the output as is.
+	FormatRaw Format = "raw"
+	// FormatJSON writes one JSON object per line of output (JSON Lines).
+	FormatJSON Format = "json"
+)
+
+// ParseFormat validates a log format name. An empty name is FormatText.
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case "":
+		return FormatText, nil
+	case FormatText, FormatRaw, FormatJSON:
+		return Format(s), nil
+	}
+	return "", fmt.Errorf("unknown log format %q", s)
+}
+
+const timestampFormat = "2006-01-02T15:04:05.000Z"
+
+// Entry is a line of process output along with where it came from.
+type Entry struct {
+	Time      time.Time
+	Stream    api.StreamType
+	ProcessId string
+	Name      string
+	Namespace string
+	Instance  string
+	// Msg is the line without its newline.
+	Msg []byte
+}
+
+type jsonLine struct {
+	Ts        string         `json:"ts"`
+	Stream    api.StreamType `json:"stream"`
+	ProcessId string         `json:"process_id"`
+	Name      string         `json:"name,omitempty"`
+	Namespace string         `json:"namespace,omitempty"`
+	Instance  string         `json:"instance,omitempty"`
+	// Msg is a string, or the object itself when the output line is a JSON object.
+	Msg any `json:"msg"`
+}
+
+// MarshalJSON renders the entry as used by the JSON Lines format, without
+// a trailing newline.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	line := jsonLine{
+		Ts:        e.Time.Format(timestampFormat),
+		Stream:    e.Stream,
+		ProcessId: e.ProcessId,
+		Name:      e.Name,
+		Namespace: e.Namespace,
+		Instance:  e.Instance,
+		Msg:       string(e.Msg),
+	}
+	if obj := bytes.TrimSpace(e.Msg); len(obj) > 0 && obj[0] == '{' && json.Valid(obj) {
+		line.Msg = json.RawMessage(obj)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(line); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// formatLine renders msg as it should be written to the log file.
+func (l *ProcessLogger) formatLine(now time.Time, msg api.StdStreamMessage) ([]byte, error) {
+	switch l.options.Format {
+	case FormatRaw:
+		return msg.Data, nil
+	case FormatJSON:
+		return l.formatJSON(now, msg)
+	default:
+		line := fmt.Appendf(nil, "[%s] [%s] %s", now.Format(timestampFormat), msg.StreamType, msg.Data)
+		// Output flushed before its line was complete still gets a line of its own.
+		if line[len(line)-1] != '\n' {
+			line = append(line, '\n')
+		}
+		return line, nil
+	}
+}
+
+// formatJSON writes a JSON line for every line in msg.
+func (l *ProcessLogger) formatJSON(now time.Time, msg api.StdStreamMessage) ([]byte, error) {
+	var buf []byte
+	for _, text := range bytes.SplitAfter(msg.Data, []byte("\n")) {
+		text = bytes.TrimRight(text, "\r\n")
+		if len(text) == 0 {
+			continue
+		}
+
+		line, err := Entry{
+			Time:      now,
+			Stream:    msg.StreamType,
+			ProcessId: l.processId,
+			Name:      l.rawName,
+			Namespace: l.options.Namespace,
+			Instance:  l.options.Instance,
+			Msg:       text,
+		}.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	return buf, nil
+}
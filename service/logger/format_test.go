@@ -0,0 +1,92 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"jstarpl/jpm/api"
+	"os"
+	"testing"
+)
+
+func TestProcessLogger_WriteJSON(t *testing.T) {
+	dir := t.TempDir()
+	pl, err := NewProcessLogger(dir, "9", "My App", Options{
+		RetentionDays: DefaultRetentionDays,
+		Format:        FormatJSON,
+		Namespace:     "web",
+		Instance:      "default",
+	})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+
+	data := "plain text\n{\"level\":\"info\",\"count\":2}\n\n{not json\n"
+	if err := pl.Write(api.StdStreamMessage{StreamType: api.Stderr, Data: []byte(data)}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	logFile := pl.files[0].path
+	if err := pl.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err := os.Open(logFile)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	var lines []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 JSON lines, got %d: %v", len(lines), lines)
+	}
+
+	first := lines[0]
+	for key, want := range map[string]string{
+		"stream":     "stderr",
+		"process_id": "9",
+		"name":       "My App",
+		"namespace":  "web",
+		"instance":   "default",
+		"msg":        "plain text",
+	} {
+		if first[key] != want {
+			t.Errorf("%s = %v, want %q", key, first[key], want)
+		}
+	}
+	if _, ok := first["ts"].(string); !ok {
+		t.Errorf("ts missing: %v", first)
+	}
+
+	obj, ok := lines[1]["msg"].(map[string]any)
+	if !ok {
+		t.Fatalf("JSON output should be embedded as an object, got %v", lines[1]["msg"])
+	}
+	if obj["level"] != "info" || obj["count"] != float64(2) {
+		t.Errorf("embedded object = %v", obj)
+	}
+
+	if lines[2]["msg"] != "{not json" {
+		t.Errorf("invalid JSON should be kept as a string, got %v", lines[2]["msg"])
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for input, want := range map[string]Format{"": FormatText, "text": FormatText, "raw": FormatRaw, "json": FormatJSON} {
+		got, err := ParseFormat(input)
+		if err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("ParseFormat(\"xml\") should fail")
+	}
+}
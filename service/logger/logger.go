@@ -2,19 +2,33 @@ package logger
 
 import (
 	"bufio"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
 	"jstarpl/jpm/api"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
 const DefaultRetentionDays = 30
 
+// DefaultFlushInterval is how long output may stay buffered before it is
+// written to the log file.
+const DefaultFlushInterval = 1 * time.Second
+
+// writeBufferSize is how much output is buffered before it is written,
+// regardless of the flush interval.
+const writeBufferSize = 64 * 1024
+
 var nonAlphanumeric = regexp.MustCompile(`[^a-zA-Z0-9]+`)
 
 // sanitizeName replaces non-alphanumeric characters with hyphens for safe filenames.
@@ -27,61 +41,181 @@ func sanitizeName(name string) string {
 	return strings.ToLower(s)
 }
 
+// Options configure a ProcessLogger.
+type Options struct {
+	RetentionDays int
+	Format        Format
+	// Split writes stdout and stderr to separate files.
+	Split bool
+	// MaxFileSize rotates a log file once it reaches this many bytes, zero
+	// rotates at midnight only.
+	MaxFileSize int64
+	// MaxTotalSize caps the bytes taken by all log files of the process, zero
+	// means no limit.
+	MaxTotalSize int64
+	// Namespace and Instance are included in each line in the JSON format.
+	Namespace string
+	Instance  string
+	// FlushInterval is how long output may stay buffered, DefaultFlushInterval if zero.
+	FlushInterval time.Duration
+	// Fsync makes sure flushed output reached the disk, at the cost of speed.
+	Fsync bool
+}
+
 // ProcessLogger writes stdout/stderr output from a managed process to dated log files,
-// rotating at midnight and deleting files older than retentionDays.
+// rotating at midnight and when a file reaches MaxFileSize. Closed files are
+// compressed in the background and deleted once older than RetentionDays or
+// when all files of the process exceed MaxTotalSize.
 type ProcessLogger struct {
-	logDir        string
-	processId     string
-	processName   string
-	datePrepend   bool
-	retentionDays int
-	currentDate   time.Time
-	file          *os.File
-	writer        *bufio.Writer
-	mu            sync.Mutex
+	logDir      string
+	processId   string
+	processName string
+	// rawName is the unsanitized process name, used in JSON lines.
+	rawName     string
+	options     Options
+	currentDate time.Time
+	// files holds a single file for both streams, or one each for stdout and
+	// stderr when Split is set.
+	files []*segmentFile
+	mu    sync.Mutex
+	// flushTimer is set while output is waiting to be flushed.
+	flushTimer *time.Timer
+	// err is why output is not reliably written, nil when all is well.
+	err     error
+	onError func(err error)
+	// lastSeq is the number of the last message written, to notice gaps.
+	lastSeq uint64
+	dropped uint64
+	// maintenanceMu serializes compression and cleanup runs.
+	maintenanceMu sync.Mutex
+}
+
+// segmentFile is the log file being written for a stream.
+type segmentFile struct {
+	// stream is empty for a file holding both streams.
+	stream  api.StreamType
+	segment int
+	path    string
+	size    int64
+	file    *os.File
+	writer  *bufio.Writer
 }
 
 // NewProcessLogger creates a ProcessLogger that writes to logDir.
-// Log files are named "{processId}-{sanitized-processName}-YYYY-MM-DD.log".
-func NewProcessLogger(logDir, processId, processName string, retentionDays int, datePrepend bool) (*ProcessLogger, error) {
+// Log files are named "{processId}-{sanitized-processName}-YYYY-MM-DD.log",
+// further files of the same day get a segment number: "YYYY-MM-DD.1.log".
+// With Split, the stream is part of the name: "{processId}-{name}-stdout-YYYY-MM-DD.log".
+func NewProcessLogger(logDir, processId, processName string, options Options) (*ProcessLogger, error) {
 	if err := os.MkdirAll(logDir, 0755); err != nil {
 		return nil, fmt.Errorf("could not create log directory: %w", err)
 	}
 
 	l := &ProcessLogger{
-		logDir:        logDir,
-		processId:     processId,
-		processName:   sanitizeName(processName),
-		retentionDays: retentionDays,
-		datePrepend:   datePrepend,
+		logDir:      logDir,
+		processId:   processId,
+		processName: sanitizeName(processName),
+		rawName:     processName,
+		options:     options,
 	}
-	if err := l.openCurrentFile(); err != nil {
+	if err := l.openCurrentFiles(); err != nil {
 		return nil, err
 	}
 	return l, nil
 }
 
-func (l *ProcessLogger) fileNameForDate(date time.Time) string {
-	return filepath.Join(l.logDir, fmt.Sprintf("%s-%s-%s.log", l.processId, l.processName, date.Format("2006-01-02")))
+// filePrefix is the start of the names of the log files of stream. It does
+// not change, so it needs no lock.
+func (l *ProcessLogger) filePrefix(stream api.StreamType) string {
+	if stream == "" {
+		return fmt.Sprintf("%s-%s-", l.processId, l.processName)
+	}
+	return fmt.Sprintf("%s-%s-%s-", l.processId, l.processName, stream)
 }
 
-func (l *ProcessLogger) openCurrentFile() error {
+func (l *ProcessLogger) fileNameForDate(stream api.StreamType, date time.Time, segment int) string {
+	if segment == 0 {
+		return filepath.Join(l.logDir, fmt.Sprintf("%s%s.log", l.filePrefix(stream), date.Format("2006-01-02")))
+	}
+	return filepath.Join(l.logDir, fmt.Sprintf("%s%s.%d.log", l.filePrefix(stream), date.Format("2006-01-02"), segment))
+}
+
+func (l *ProcessLogger) openCurrentFiles() error {
 	now := time.Now().UTC()
 	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	streams := []api.StreamType{""}
+	if l.options.Split {
+		streams = []api.StreamType{api.Stdout, api.Stderr}
+	}
+
 	l.currentDate = today
+	l.files = nil
+	for _, stream := range streams {
+		f := &segmentFile{stream: stream}
+		if err := l.openFile(f, today, 0); err != nil {
+			l.closeFiles()
+			return err
+		}
+		l.files = append(l.files, f)
+	}
+	return nil
+}
+
+// openFile opens the log file of f for date, continuing the last segment of
+// that day if it is neither compressed nor full. The segment number is at
+// least minSegment.
+func (l *ProcessLogger) openFile(f *segmentFile, date time.Time, minSegment int) error {
+	last := -1
+	compressed := false
+	for _, existing := range listFiles(l.logDir, l.filePrefix(f.stream), f.stream) {
+		if existing.date.Equal(date) && existing.segment >= last {
+			last = existing.segment
+			compressed = existing.compressed
+		}
+	}
+
+	segment := max(last, minSegment)
+	if segment == last {
+		if compressed {
+			segment++
+		} else if info, err := os.Stat(l.fileNameForDate(f.stream, date, segment)); err == nil && l.options.MaxFileSize > 0 && info.Size() >= l.options.MaxFileSize {
+			segment++
+		}
+	}
+	filePath := l.fileNameForDate(f.stream, date, segment)
 
-	filePath := l.fileNameForDate(today)
 	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("could not open log file %s: %w", filePath, err)
 	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not open log file %s: %w", filePath, err)
+	}
 
-	l.file = file
-	l.writer = bufio.NewWriter(file)
+	f.segment = segment
+	f.path = filePath
+	f.size = info.Size()
+	f.file = file
+	f.writer = bufio.NewWriterSize(file, writeBufferSize)
 	return nil
 }
 
-// Write appends a stream message to the current log file, rotating if the date has changed.
+// fileFor returns the file the output of stream is written to.
+func (l *ProcessLogger) fileFor(stream api.StreamType) *segmentFile {
+	if len(l.files) > 1 && stream == api.Stderr {
+		return l.files[1]
+	}
+	return l.files[0]
+}
+
+// Write appends a stream message to the current log file, rotating if the date has
+// changed or the file would grow beyond MaxFileSize. The output is buffered
+// and flushed within FlushInterval.
+//
+// Messages numbered with a Seq are expected in order, a gap means output
+// was dropped before it reached the logger; it is noted in the file.
 func (l *ProcessLogger) Write(msg api.StdStreamMessage) error {
 	if len(msg.Data) == 0 {
 		return nil
@@ -90,95 +224,445 @@ func (l *ProcessLogger) Write(msg api.StdStreamMessage) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	if len(l.files) == 0 || l.files[0].file == nil {
+		return errors.New("log file is closed")
+	}
+
 	now := time.Now().UTC()
+
+	if msg.Seq != 0 {
+		if l.lastSeq != 0 && msg.Seq > l.lastSeq+1 {
+			dropped := msg.Seq - l.lastSeq - 1
+			l.dropped += dropped
+			notice := api.StdStreamMessage{StreamType: api.Stderr, Data: fmt.Appendf(nil, "jpm: %d lines of output were dropped, the log could not keep up\n", dropped)}
+			if err := l.writeLocked(now, notice); err != nil {
+				return err
+			}
+		}
+		l.lastSeq = msg.Seq
+	}
+
+	return l.writeLocked(now, msg)
+}
+
+func (l *ProcessLogger) writeLocked(now time.Time, msg api.StdStreamMessage) error {
 	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
 
+	line, err := l.formatLine(now, msg)
+	if err != nil {
+		return err
+	}
+
 	if today.After(l.currentDate) {
-		if err := l.rotate(today); err != nil {
-			return err
+		l.currentDate = today
+		for _, f := range l.files {
+			if err := l.rotate(f, 0); err != nil {
+				return err
+			}
 		}
 	}
 
-	if l.datePrepend {
-		_, err := fmt.Fprintf(l.writer, "[%s] [%s] %s", now.Format("2006-01-02T15:04:05.000Z"), msg.StreamType, msg.Data)
-		if err != nil {
+	f := l.fileFor(msg.StreamType)
+	if l.options.MaxFileSize > 0 && f.size > 0 && f.size+int64(len(line)) > l.options.MaxFileSize {
+		if err := l.rotate(f, f.segment+1); err != nil {
 			return err
 		}
-	} else {
-		_, err := fmt.Fprintf(l.writer, "%s", msg.Data)
+	}
+
+	n, err := f.writer.Write(line)
+	f.size += int64(n)
+	if err != nil {
+		l.failed(f, err)
+		return l.err
+	}
+
+	if l.flushTimer == nil {
+		l.flushTimer = time.AfterFunc(l.flushInterval(), func() {
+			l.Flush()
+		})
+	}
+	return nil
+}
+
+func (l *ProcessLogger) flushInterval() time.Duration {
+	if l.options.FlushInterval > 0 {
+		return l.options.FlushInterval
+	}
+	return DefaultFlushInterval
+}
+
+// Flush writes buffered output to the log files, and syncs them to disk
+// with Fsync. It updates Err.
+func (l *ProcessLogger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.flushLocked()
+}
+
+func (l *ProcessLogger) flushLocked() error {
+	if l.flushTimer != nil {
+		l.flushTimer.Stop()
+		l.flushTimer = nil
+	}
+
+	for _, f := range l.files {
+		if f.file == nil {
+			continue
+		}
+		err := f.writer.Flush()
+		if err == nil && l.options.Fsync {
+			err = f.file.Sync()
+		}
 		if err != nil {
-			return err
+			l.failed(f, err)
+			return l.err
 		}
 	}
 
-	return l.writer.Flush()
+	if l.dropped > 0 {
+		l.setErr(fmt.Errorf("%d lines of output were dropped, the log could not keep up", l.dropped))
+		l.dropped = 0
+	} else {
+		l.setErr(nil)
+	}
+	return l.err
 }
 
-// rotate closes the current log file, opens a new one for newDate, and runs cleanup.
-func (l *ProcessLogger) rotate(newDate time.Time) error {
-	if l.writer != nil {
-		l.writer.Flush()
+// failed records a write error of f. A buffered writer keeps failing after
+// an error, so the output it holds is given up to be able to continue.
+func (l *ProcessLogger) failed(f *segmentFile, err error) {
+	if errors.Is(err, syscall.ENOSPC) {
+		err = fmt.Errorf("disk full: %w", err)
 	}
-	if l.file != nil {
-		l.file.Close()
-		l.file = nil
-		l.writer = nil
+	l.setErr(err)
+	f.writer.Reset(f.file)
+}
+
+// setErr updates the logging state, telling the error handler about changes.
+func (l *ProcessLogger) setErr(err error) {
+	changed := (err == nil) != (l.err == nil) || (err != nil && err.Error() != l.err.Error())
+	l.err = err
+	if changed && l.onError != nil {
+		l.onError(err)
 	}
+}
 
-	l.currentDate = newDate
-	filePath := l.fileNameForDate(newDate)
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("could not open log file %s: %w", filePath, err)
+// Err returns why output is not reliably written to the log files, like a
+// full disk or output dropped under load, or nil when all is well.
+func (l *ProcessLogger) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.err
+}
+
+// SetErrorHandler sets a function called whenever Err changes. It is called
+// with the logger locked, so it must not call back into it.
+func (l *ProcessLogger) SetErrorHandler(fn func(err error)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.onError = fn
+}
+
+// rotate closes the log file f, opens a new one for currentDate with at
+// least minSegment, and runs compression and cleanup in the background.
+func (l *ProcessLogger) rotate(f *segmentFile, minSegment int) error {
+	f.close()
+
+	if err := l.openFile(f, l.currentDate, minSegment); err != nil {
+		return err
 	}
-	l.file = file
-	l.writer = bufio.NewWriter(file)
 
-	go l.cleanup()
+	go l.maintain()
 	return nil
 }
 
-// cleanup removes log files for this process that are older than retentionDays.
-func (l *ProcessLogger) cleanup() {
-	cutoff := time.Now().UTC().AddDate(0, 0, -l.retentionDays)
-	pattern := filepath.Join(l.logDir, fmt.Sprintf("%s-%s-*.log", l.processId, l.processName))
-	matches, err := filepath.Glob(pattern)
+func (f *segmentFile) close() error {
+	if f.writer != nil {
+		f.writer.Flush()
+	}
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	f.writer = nil
+	return err
+}
+
+func (l *ProcessLogger) closeFiles() error {
+	var errs []error
+	for _, f := range l.files {
+		errs = append(errs, f.close())
+	}
+	return errors.Join(errs...)
+}
+
+// Reconfigure applies new settings to a live logger. When logDir or Split
+// changes the current files are closed and writing continues in new ones.
+func (l *ProcessLogger) Reconfigure(logDir string, options Options) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previous, previousDir := l.options, l.logDir
+	l.options = options
+	// New limits are enforced on existing files right away, with whatever
+	// settings are stored once this returns.
+	defer func() { go l.maintain() }()
+
+	if logDir == l.logDir && options.Split == previous.Split {
+		return nil
+	}
+
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		l.options.Split = previous.Split
+		return fmt.Errorf("could not create log directory: %w", err)
+	}
+
+	l.closeFiles()
+	l.logDir = logDir
+	if err := l.openCurrentFiles(); err != nil {
+		// Keep logging where it did rather than not at all.
+		l.logDir = previousDir
+		l.options.Split = previous.Split
+		if reopenErr := l.openCurrentFiles(); reopenErr != nil {
+			return errors.Join(err, reopenErr)
+		}
+		return err
+	}
+	return nil
+}
+
+var segmentSuffix = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})(?:\.(\d+))?\.log(\.gz)?$`)
+
+type logFile struct {
+	path string
+	// stream is empty for a file holding both streams.
+	stream     api.StreamType
+	date       time.Time
+	segment    int
+	compressed bool
+}
+
+// listFiles returns the log files of stream in dir whose names start with
+// prefix, oldest first.
+func listFiles(dir, prefix string, stream api.StreamType) []logFile {
+	matches, err := filepath.Glob(filepath.Join(dir, prefix+"*.log*"))
 	if err != nil {
-		log.Printf("logger: could not list log files for cleanup: %v", err)
-		return
+		log.Printf("logger: could not list log files: %v", err)
+		return nil
 	}
-	prefix := fmt.Sprintf("%s-%s-", l.processId, l.processName)
+
+	var files []logFile
 	for _, filePath := range matches {
 		base := filepath.Base(filePath)
 		if !strings.HasPrefix(base, prefix) {
 			continue
 		}
-		dateStr := strings.TrimSuffix(strings.TrimPrefix(base, prefix), ".log")
-		fileDate, err := time.Parse("2006-01-02", dateStr)
+		m := segmentSuffix.FindStringSubmatch(strings.TrimPrefix(base, prefix))
+		if m == nil {
+			continue
+		}
+		fileDate, err := time.Parse("2006-01-02", m[1])
 		if err != nil {
 			continue
 		}
-		if fileDate.Before(cutoff) {
-			if err := os.Remove(filePath); err != nil {
-				log.Printf("logger: could not remove old log file %s: %v", filePath, err)
-			}
+		segment := 0
+		if m[2] != "" {
+			segment, _ = strconv.Atoi(m[2])
 		}
+		files = append(files, logFile{path: filePath, stream: stream, date: fileDate, segment: segment, compressed: m[3] != ""})
 	}
+
+	sortLogFiles(files)
+	return files
 }
 
-// Close flushes and closes the current log file.
-func (l *ProcessLogger) Close() error {
+// listAllFiles returns the log files of this process in logDir, split or
+// not, oldest first.
+func (l *ProcessLogger) listAllFiles() []logFile {
+	l.mu.Lock()
+	dir := l.logDir
+	l.mu.Unlock()
+
+	var files []logFile
+	for _, stream := range []api.StreamType{"", api.Stdout, api.Stderr} {
+		files = append(files, listFiles(dir, l.filePrefix(stream), stream)...)
+	}
+	sortLogFiles(files)
+	return files
+}
+
+// Files returns the paths of the log files of this process, oldest first.
+func (l *ProcessLogger) Files() []string {
+	var paths []string
+	for _, f := range l.listAllFiles() {
+		paths = append(paths, f.path)
+	}
+	return paths
+}
+
+func sortLogFiles(files []logFile) {
+	sort.SliceStable(files, func(i, j int) bool {
+		if !files[i].date.Equal(files[j].date) {
+			return files[i].date.Before(files[j].date)
+		}
+		if files[i].segment != files[j].segment {
+			return files[i].segment < files[j].segment
+		}
+		return streamRank(files[i].stream) < streamRank(files[j].stream)
+	})
+}
+
+// streamRank orders the files of a day and segment: combined, stdout, stderr.
+func streamRank(stream api.StreamType) int {
+	switch stream {
+	case api.Stdout:
+		return 1
+	case api.Stderr:
+		return 2
+	}
+	return 0
+}
+
+// currentPaths returns the log files being written.
+func (l *ProcessLogger) currentPaths() map[string]bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.currentPathsLocked()
+}
+
+func (l *ProcessLogger) currentPathsLocked() map[string]bool {
+	paths := make(map[string]bool)
+	for _, f := range l.files {
+		if f.file != nil {
+			paths[f.path] = true
+		}
+	}
+	return paths
+}
+
+// maintain compresses closed log files and removes old ones.
+func (l *ProcessLogger) maintain() {
+	l.maintenanceMu.Lock()
+	defer l.maintenanceMu.Unlock()
+
+	l.compressClosed()
+	l.cleanup()
+}
+
+// compressClosed gzips every uncompressed log file except the ones being written.
+func (l *ProcessLogger) compressClosed() {
+	for _, f := range l.listAllFiles() {
+		if f.compressed || l.currentPaths()[f.path] {
+			continue
+		}
+		if err := l.compress(f.path); err != nil {
+			log.Printf("logger: could not compress log file %s: %v", f.path, err)
+		}
+	}
+}
+
+// compress replaces filePath with a gzipped copy. It gives up if the file was
+// reopened for writing in the meantime.
+func (l *ProcessLogger) compress(filePath string) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpPath := filePath + ".gz.tmp"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	gz := gzip.NewWriter(dst)
+	copied, err := io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if l.writer != nil {
-		l.writer.Flush()
+	if l.currentPathsLocked()[filePath] {
+		return nil
 	}
-	if l.file != nil {
-		err := l.file.Close()
-		l.file = nil
-		l.writer = nil
+	if info, err := os.Stat(filePath); err != nil || info.Size() != copied {
+		// Written to since, the next run will pick it up again.
 		return err
 	}
-	return nil
+	if err := os.Rename(tmpPath, filePath+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(filePath)
+}
+
+// cleanup removes log files for this process that are older than RetentionDays
+// and, oldest first, files beyond MaxTotalSize. Current files are never removed.
+func (l *ProcessLogger) cleanup() {
+	l.mu.Lock()
+	retentionDays, maxTotalSize, current := l.options.RetentionDays, l.options.MaxTotalSize, l.currentPathsLocked()
+	l.mu.Unlock()
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -retentionDays)
+	var kept []logFile
+	var sizes []int64
+	var total int64
+	for _, f := range l.listAllFiles() {
+		if !current[f.path] && f.date.Before(cutoff) {
+			if err := os.Remove(f.path); err != nil {
+				log.Printf("logger: could not remove old log file %s: %v", f.path, err)
+			}
+			continue
+		}
+		info, err := os.Stat(f.path)
+		if err != nil {
+			continue
+		}
+		kept = append(kept, f)
+		sizes = append(sizes, info.Size())
+		total += info.Size()
+	}
+
+	if maxTotalSize <= 0 {
+		return
+	}
+	for i, f := range kept {
+		if total <= maxTotalSize {
+			break
+		}
+		if current[f.path] {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			log.Printf("logger: could not remove log file %s: %v", f.path, err)
+			continue
+		}
+		total -= sizes[i]
+	}
+}
+
+// Close flushes and closes the current log files.
+func (l *ProcessLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.flushTimer != nil {
+		l.flushTimer.Stop()
+		l.flushTimer = nil
+	}
+	return l.closeFiles()
 }
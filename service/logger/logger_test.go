@@ -1,6 +1,9 @@
 package logger
 
 import (
+	"compress/gzip"
+	"fmt"
+	"io"
 	"jstarpl/jpm/api"
 	"os"
 	"path/filepath"
@@ -32,7 +35,7 @@ func TestSanitizeName(t *testing.T) {
 
 func TestNewProcessLogger_CreatesFile(t *testing.T) {
 	dir := t.TempDir()
-	pl, err := NewProcessLogger(dir, "0", "testapp", DefaultRetentionDays)
+	pl, err := NewProcessLogger(dir, "0", "testapp", Options{RetentionDays: DefaultRetentionDays})
 	if err != nil {
 		t.Fatalf("NewProcessLogger: %v", err)
 	}
@@ -47,7 +50,7 @@ func TestNewProcessLogger_CreatesFile(t *testing.T) {
 
 func TestProcessLogger_Write(t *testing.T) {
 	dir := t.TempDir()
-	pl, err := NewProcessLogger(dir, "1", "myapp", DefaultRetentionDays)
+	pl, err := NewProcessLogger(dir, "1", "myapp", Options{RetentionDays: DefaultRetentionDays})
 	if err != nil {
 		t.Fatalf("NewProcessLogger: %v", err)
 	}
@@ -77,7 +80,7 @@ func TestProcessLogger_Write(t *testing.T) {
 
 func TestProcessLogger_WriteEmpty(t *testing.T) {
 	dir := t.TempDir()
-	pl, err := NewProcessLogger(dir, "2", "emptytest", DefaultRetentionDays)
+	pl, err := NewProcessLogger(dir, "2", "emptytest", Options{RetentionDays: DefaultRetentionDays})
 	if err != nil {
 		t.Fatalf("NewProcessLogger: %v", err)
 	}
@@ -94,7 +97,7 @@ func TestProcessLogger_Cleanup(t *testing.T) {
 	dir := t.TempDir()
 	retentionDays := 3
 
-	pl, err := NewProcessLogger(dir, "3", "cleanuptest", retentionDays)
+	pl, err := NewProcessLogger(dir, "3", "cleanuptest", Options{RetentionDays: retentionDays})
 	if err != nil {
 		t.Fatalf("NewProcessLogger: %v", err)
 	}
@@ -127,7 +130,7 @@ func TestProcessLogger_Cleanup(t *testing.T) {
 
 func TestProcessLogger_RotateOnDateChange(t *testing.T) {
 	dir := t.TempDir()
-	pl, err := NewProcessLogger(dir, "4", "rotatetest", DefaultRetentionDays)
+	pl, err := NewProcessLogger(dir, "4", "rotatetest", Options{RetentionDays: DefaultRetentionDays})
 	if err != nil {
 		t.Fatalf("NewProcessLogger: %v", err)
 	}
@@ -144,6 +147,9 @@ func TestProcessLogger_RotateOnDateChange(t *testing.T) {
 	if err := pl.Write(msg); err != nil {
 		t.Fatalf("Write after simulated date change: %v", err)
 	}
+	if err := pl.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
 
 	today := time.Now().UTC().Format("2006-01-02")
 	todayFile := filepath.Join(dir, "4-rotatetest-"+today+".log")
@@ -159,3 +165,304 @@ func TestProcessLogger_RotateOnDateChange(t *testing.T) {
 		t.Errorf("rotated log file should contain written data, got: %s", string(content))
 	}
 }
+
+func TestProcessLogger_RotateOnSize(t *testing.T) {
+	dir := t.TempDir()
+	pl, err := NewProcessLogger(dir, "5", "sizetest", Options{RetentionDays: DefaultRetentionDays, Format: FormatRaw})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+	defer pl.Close()
+	pl.options.MaxFileSize = 10
+
+	for _, data := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n"} {
+		if err := pl.Write(api.StdStreamMessage{StreamType: api.Stdout, Data: []byte(data)}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := pl.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	current := filepath.Join(dir, "5-sizetest-"+today+".2.log")
+	content, err := os.ReadFile(current)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(content) != "cccccccc\n" {
+		t.Errorf("current segment should only contain the last write, got: %q", string(content))
+	}
+
+	pl.maintain()
+
+	for _, name := range []string{"5-sizetest-" + today + ".log.gz", "5-sizetest-" + today + ".1.log.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected compressed segment %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(current); err != nil {
+		t.Errorf("current segment should not be compressed: %v", err)
+	}
+}
+
+func TestProcessLogger_ContinuesAfterCompressedSegment(t *testing.T) {
+	dir := t.TempDir()
+	today := time.Now().UTC().Format("2006-01-02")
+	if err := os.WriteFile(filepath.Join(dir, "6-resume-"+today+".log.gz"), []byte{}, 0644); err != nil {
+		t.Fatalf("create segment: %v", err)
+	}
+
+	pl, err := NewProcessLogger(dir, "6", "resume", Options{RetentionDays: DefaultRetentionDays, Format: FormatRaw})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+	defer pl.Close()
+
+	if want := filepath.Join(dir, "6-resume-"+today+".1.log"); pl.files[0].path != want {
+		t.Errorf("logger should continue in %s, writes to %s", want, pl.files[0].path)
+	}
+}
+
+func TestProcessLogger_Compress(t *testing.T) {
+	dir := t.TempDir()
+	pl, err := NewProcessLogger(dir, "7", "gziptest", Options{RetentionDays: DefaultRetentionDays, Format: FormatRaw})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+	defer pl.Close()
+
+	closed := filepath.Join(dir, "7-gziptest-"+time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")+".log")
+	if err := os.WriteFile(closed, []byte("yesterday\n"), 0644); err != nil {
+		t.Fatalf("create closed file: %v", err)
+	}
+
+	pl.compressClosed()
+
+	if _, err := os.Stat(closed); !os.IsNotExist(err) {
+		t.Errorf("closed log file should have been replaced: %s", closed)
+	}
+	f, err := os.Open(closed + ".gz")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	content, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(content) != "yesterday\n" {
+		t.Errorf("compressed file content = %q", string(content))
+	}
+}
+
+func TestProcessLogger_CleanupMaxTotalSize(t *testing.T) {
+	dir := t.TempDir()
+	pl, err := NewProcessLogger(dir, "8", "totaltest", Options{RetentionDays: DefaultRetentionDays, Format: FormatRaw})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+	defer pl.Close()
+	pl.options.MaxTotalSize = 25
+
+	var files []string
+	for days := 3; days >= 1; days-- {
+		date := time.Now().UTC().AddDate(0, 0, -days).Format("2006-01-02")
+		filePath := filepath.Join(dir, "8-totaltest-"+date+".log.gz")
+		if err := os.WriteFile(filePath, []byte("0123456789"), 0644); err != nil {
+			t.Fatalf("create file: %v", err)
+		}
+		files = append(files, filePath)
+	}
+
+	pl.cleanup()
+
+	if _, err := os.Stat(files[0]); !os.IsNotExist(err) {
+		t.Errorf("oldest log file should have been deleted: %s", files[0])
+	}
+	for _, filePath := range files[1:] {
+		if _, err := os.Stat(filePath); err != nil {
+			t.Errorf("log file should have been kept: %s", filePath)
+		}
+	}
+	if _, err := os.Stat(pl.files[0].path); err != nil {
+		t.Errorf("current log file should have been kept: %v", err)
+	}
+}
+
+func TestProcessLogger_Split(t *testing.T) {
+	dir := t.TempDir()
+	pl, err := NewProcessLogger(dir, "10", "splittest", Options{RetentionDays: DefaultRetentionDays, Format: FormatRaw, Split: true})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+
+	pl.Write(api.StdStreamMessage{StreamType: api.Stdout, Data: []byte("out\n")})
+	pl.Write(api.StdStreamMessage{StreamType: api.Stderr, Data: []byte("err\n")})
+	if err := pl.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	for stream, want := range map[string]string{"stdout": "out\n", "stderr": "err\n"} {
+		content, err := os.ReadFile(filepath.Join(dir, "10-splittest-"+stream+"-"+today+".log"))
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if string(content) != want {
+			t.Errorf("%s log = %q, want %q", stream, string(content), want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "10-splittest-"+today+".log")); !os.IsNotExist(err) {
+		t.Errorf("no combined log file should be written")
+	}
+}
+
+func TestProcessLogger_FlushInterval(t *testing.T) {
+	dir := t.TempDir()
+	pl, err := NewProcessLogger(dir, "7", "flushtest", Options{RetentionDays: DefaultRetentionDays, Format: FormatRaw, FlushInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+	defer pl.Close()
+
+	if err := pl.Write(api.StdStreamMessage{StreamType: api.Stdout, Data: []byte("buffered\n")}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if content, _ := os.ReadFile(pl.files[0].path); len(content) != 0 {
+		t.Errorf("output should be buffered, file has %q", content)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		content, _ := os.ReadFile(pl.files[0].path)
+		if string(content) == "buffered\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("output was not flushed within the interval, file has %q", content)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProcessLogger_DroppedOutput(t *testing.T) {
+	dir := t.TempDir()
+	pl, err := NewProcessLogger(dir, "8", "droptest", Options{RetentionDays: DefaultRetentionDays, Format: FormatRaw})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+	defer pl.Close()
+
+	var reported []error
+	pl.SetErrorHandler(func(err error) { reported = append(reported, err) })
+
+	for _, seq := range []uint64{1, 2, 5} {
+		msg := api.StdStreamMessage{StreamType: api.Stdout, Data: fmt.Appendf(nil, "line %d\n", seq), Seq: seq}
+		if err := pl.Write(msg); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := pl.Flush(); err == nil || !strings.Contains(err.Error(), "2 lines") {
+		t.Errorf("Flush = %v, want an error about 2 dropped lines", err)
+	}
+
+	content, _ := os.ReadFile(pl.files[0].path)
+	want := "line 1\nline 2\njpm: 2 lines of output were dropped, the log could not keep up\nline 5\n"
+	if string(content) != want {
+		t.Errorf("log file = %q, want %q", content, want)
+	}
+
+	// Logging is fine again once a flush went by without drops.
+	pl.Write(api.StdStreamMessage{StreamType: api.Stdout, Data: []byte("line 6\n"), Seq: 6})
+	if err := pl.Flush(); err != nil {
+		t.Errorf("Flush = %v, want no error", err)
+	}
+	if len(reported) != 2 || reported[0] == nil || reported[1] != nil {
+		t.Errorf("error handler got %v, want the drop and the recovery", reported)
+	}
+}
+
+func TestProcessLogger_DiskFull(t *testing.T) {
+	full, err := os.OpenFile("/dev/full", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skip("/dev/full is not available")
+	}
+	defer full.Close()
+
+	dir := t.TempDir()
+	pl, err := NewProcessLogger(dir, "9", "fulltest", Options{RetentionDays: DefaultRetentionDays})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+	defer pl.Close()
+
+	// Swap the log file for one on a full disk.
+	pl.mu.Lock()
+	pl.files[0].file.Close()
+	pl.files[0].file = full
+	pl.files[0].writer.Reset(full)
+	pl.mu.Unlock()
+
+	pl.Write(api.StdStreamMessage{StreamType: api.Stdout, Data: []byte("lost\n")})
+	if err := pl.Flush(); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("Flush = %v, want a disk full error", err)
+	}
+	if err := pl.Err(); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("Err = %v, want a disk full error", err)
+	}
+}
+
+func TestProcessLogger_Files(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "2-app-2000-01-01.log.gz"), nil, 0644)
+	os.WriteFile(filepath.Join(dir, "2-app-stderr-2000-01-02.log"), nil, 0644)
+	os.WriteFile(filepath.Join(dir, "3-app-2000-01-01.log"), nil, 0644)
+
+	pl, err := NewProcessLogger(dir, "2", "app", Options{RetentionDays: 36500})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+	defer pl.Close()
+
+	today := time.Now().UTC().Format("2006-01-02")
+	want := []string{
+		filepath.Join(dir, "2-app-2000-01-01.log.gz"),
+		filepath.Join(dir, "2-app-stderr-2000-01-02.log"),
+		filepath.Join(dir, "2-app-"+today+".log"),
+	}
+	if got := pl.Files(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Files() = %q, want %q", got, want)
+	}
+}
+
+func TestProcessLogger_ReconfigureWhileListing(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	pl, err := NewProcessLogger(dirs[0], "4", "moving", Options{RetentionDays: DefaultRetentionDays})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+	defer pl.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 20 {
+			if err := pl.Reconfigure(dirs[i%2], Options{RetentionDays: DefaultRetentionDays, Split: i%3 == 0}); err != nil {
+				t.Errorf("Reconfigure: %v", err)
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			pl.Files()
+		}
+	}
+}
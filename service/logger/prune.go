@@ -0,0 +1,134 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PruneProcess is a process whose log files are in the pruned directory.
+type PruneProcess struct {
+	ProcessId   string
+	ProcessName string
+	// RetentionDays is how long the files of the process are kept.
+	RetentionDays int
+}
+
+// PruneOptions select the log files Prune removes.
+type PruneOptions struct {
+	// Before, if set, removes the files of all days before it regardless of
+	// the retention of each process.
+	Before time.Time
+	// Processes are the known processes writing to the directory.
+	Processes []PruneProcess
+	// OrphanRetentionDays is how long files of processes that are gone are
+	// kept. Zero leaves them alone, unless Before is set.
+	OrphanRetentionDays int
+	// SkipOrphans leaves files of processes that are gone alone in any case.
+	SkipOrphans bool
+	// Keep are the files being written, which are never removed.
+	Keep map[string]bool
+	// DryRun only reports the files that would be removed.
+	DryRun bool
+}
+
+// PrunedFile is a log file removed by Prune.
+type PrunedFile struct {
+	Path string
+	Size int64
+}
+
+// logFileName matches the names of the files a ProcessLogger writes.
+var logFileName = regexp.MustCompile(`^\d+-.+-(\d{4}-\d{2}-\d{2})(?:\.\d+)?\.log(?:\.gz)?$`)
+
+// Prune removes old log files of all processes from dir, including the files
+// of processes that no longer exist.
+func Prune(dir string, options PruneOptions) ([]PrunedFile, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var pruned []PrunedFile
+	var errs []error
+	for _, entry := range entries {
+		m := logFileName.FindStringSubmatch(entry.Name())
+		if m == nil || entry.IsDir() {
+			continue
+		}
+		filePath := filepath.Join(dir, entry.Name())
+		if options.Keep[filePath] {
+			continue
+		}
+		fileDate, err := time.Parse("2006-01-02", m[1])
+		if err != nil {
+			continue
+		}
+
+		owner := options.owner(entry.Name())
+		if owner == nil && options.SkipOrphans {
+			continue
+		}
+
+		cutoff := options.Before
+		if cutoff.IsZero() {
+			retentionDays := options.OrphanRetentionDays
+			if owner != nil {
+				retentionDays = owner.RetentionDays
+			}
+			if retentionDays <= 0 {
+				continue
+			}
+			cutoff = now.AddDate(0, 0, -retentionDays)
+		}
+		if !fileDate.Before(cutoff) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if !options.DryRun {
+			if err := os.Remove(filePath); err != nil {
+				// Compressed in the meantime, the next run removes the new file.
+				if !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err)
+				}
+				continue
+			}
+		}
+		pruned = append(pruned, PrunedFile{Path: filePath, Size: info.Size()})
+	}
+
+	return pruned, errors.Join(errs...)
+}
+
+// owner returns the process that wrote the file name, nil if it is gone.
+func (o PruneOptions) owner(name string) *PruneProcess {
+	for i, p := range o.Processes {
+		prefix := fmt.Sprintf("%s-%s-", p.ProcessId, sanitizeName(p.ProcessName))
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, prefix)
+		rest = strings.TrimPrefix(strings.TrimPrefix(rest, "stdout-"), "stderr-")
+		if segmentSuffix.MatchString(rest) {
+			return &o.Processes[i]
+		}
+	}
+	return nil
+}
+
+// CurrentPaths returns the log files being written.
+func (l *ProcessLogger) CurrentPaths() map[string]bool {
+	return l.currentPaths()
+}
@@ -0,0 +1,112 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	day := func(daysAgo int) string {
+		return time.Now().UTC().AddDate(0, 0, -daysAgo).Format("2006-01-02")
+	}
+
+	files := []string{
+		// Process 0 keeps 3 days.
+		"0-web-" + day(5) + ".log.gz",
+		"0-web-stderr-" + day(5) + ".1.log.gz",
+		"0-web-" + day(1) + ".log",
+		// Process 1 keeps the service default.
+		"1-worker-" + day(5) + ".log.gz",
+		// Process 2 was deleted, its files are orphans.
+		"2-old-" + day(12) + ".log.gz",
+		"2-old-" + day(5) + ".log.gz",
+		// Not a log file.
+		"notes-" + day(40) + ".txt",
+	}
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	options := PruneOptions{
+		Processes: []PruneProcess{
+			{ProcessId: "0", ProcessName: "web", RetentionDays: 3},
+			{ProcessId: "1", ProcessName: "worker", RetentionDays: 30},
+		},
+		OrphanRetentionDays: 10,
+	}
+
+	dryRun := options
+	dryRun.DryRun = true
+	pruned, err := Prune(dir, dryRun)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	want := []string{"0-web-" + day(5) + ".log.gz", "0-web-stderr-" + day(5) + ".1.log.gz", "2-old-" + day(12) + ".log.gz"}
+	assertPruned(t, pruned, want)
+	for _, name := range files {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("dry run removed %s", name)
+		}
+	}
+
+	pruned, err = Prune(dir, options)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	assertPruned(t, pruned, want)
+	for _, name := range want {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should have been removed", name)
+		}
+	}
+}
+
+func TestPrune_Before(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"0-web-2026-01-01.log.gz", "0-web-2026-01-02.log", "3-gone-2026-01-01.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	options := PruneOptions{
+		Before:      time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC),
+		Processes:   []PruneProcess{{ProcessId: "0", ProcessName: "web", RetentionDays: 30}},
+		Keep:        map[string]bool{filepath.Join(dir, "0-web-2026-01-02.log"): true},
+		SkipOrphans: true,
+	}
+	pruned, err := Prune(dir, options)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	// The current file and the file of the unknown process stay.
+	assertPruned(t, pruned, []string{"0-web-2026-01-01.log.gz"})
+}
+
+func assertPruned(t *testing.T, pruned []PrunedFile, want []string) {
+	t.Helper()
+
+	var got []string
+	for _, f := range pruned {
+		got = append(got, filepath.Base(f.Path))
+		if f.Size != 4 {
+			t.Errorf("size of %s = %d, want 4", f.Path, f.Size)
+		}
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Fatalf("pruned %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("pruned %q, want %q", got, want)
+		}
+	}
+}
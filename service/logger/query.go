@@ -0,0 +1,283 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"jstarpl/jpm/api"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultQueryLimit = 1000
+	MaxQueryLimit     = 10000
+	// maxQueryBytes caps the output returned at once, so that a page of
+	// results fits in an IPC message.
+	maxQueryBytes = 1 << 20
+)
+
+// Query selects lines from the log files of a process.
+type Query struct {
+	// Since and Until limit the lines to a period of time, zero values don't.
+	Since time.Time
+	Until time.Time
+	// Grep, if set, is matched against the output of each line.
+	Grep *regexp.Regexp
+	// Stream, if set, only returns output of that stream.
+	Stream api.StreamType
+	// Limit is the maximum number of lines returned, DefaultQueryLimit if zero.
+	Limit int
+	// After continues a previous query, it is the Next value of its result.
+	After string
+}
+
+// QueryResult is a page of lines matching a Query, oldest first.
+type QueryResult struct {
+	Lines []api.LogLine
+	// Next is where the following page starts, empty when all files were read.
+	Next string
+}
+
+// ParseTime parses a point in time: an RFC 3339 time, a date (midnight UTC)
+// or a duration before now, like "2h" or "7d".
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use an RFC 3339 time, a date or a duration like 2h or 7d", s)
+}
+
+// position is where a query stopped: after line lines of a log file.
+type position struct {
+	date    time.Time
+	segment int
+	stream  api.StreamType
+	line    int
+}
+
+func (p position) String() string {
+	stream := string(p.stream)
+	if stream == "" {
+		stream = "-"
+	}
+	return fmt.Sprintf("%s.%d.%s.%d", p.date.Format("2006-01-02"), p.segment, stream, p.line)
+}
+
+func parsePosition(s string) (position, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return position{}, fmt.Errorf("invalid cursor %q", s)
+	}
+
+	date, err := time.Parse("2006-01-02", parts[0])
+	if err != nil {
+		return position{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	segment, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return position{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	line, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return position{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	stream := api.StreamType(parts[2])
+	if stream == "-" {
+		stream = ""
+	}
+
+	return position{date: date, segment: segment, stream: stream, line: line}, nil
+}
+
+// ValidateCursor checks a Next value passed back in Query.After.
+func ValidateCursor(s string) error {
+	_, err := parsePosition(s)
+	return err
+}
+
+// compare orders f relative to the file of p.
+func (p position) compare(f logFile) int {
+	switch {
+	case !f.date.Equal(p.date):
+		return f.date.Compare(p.date)
+	case f.segment != p.segment:
+		return f.segment - p.segment
+	}
+	return streamRank(f.stream) - streamRank(p.stream)
+}
+
+// QueryFiles searches the log files, rotated and compressed ones included,
+// that a ProcessLogger for processId and processName wrote to logDir.
+func QueryFiles(logDir, processId, processName string, query Query) (QueryResult, error) {
+	l := &ProcessLogger{logDir: logDir, processId: processId, processName: sanitizeName(processName)}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	limit = min(limit, MaxQueryLimit)
+
+	var after *position
+	if query.After != "" {
+		p, err := parsePosition(query.After)
+		if err != nil {
+			return QueryResult{}, err
+		}
+		after = &p
+	}
+
+	result := QueryResult{Lines: []api.LogLine{}}
+	size := 0
+	for _, f := range l.listAllFiles() {
+		skip := 0
+		if after != nil {
+			switch cmp := after.compare(f); {
+			case cmp < 0:
+				continue
+			case cmp == 0:
+				skip = after.line
+			}
+		}
+		if query.Stream != "" && f.stream != "" && f.stream != query.Stream {
+			continue
+		}
+		// A file only holds lines of the day it is named after.
+		if !query.Since.IsZero() && !f.date.AddDate(0, 0, 1).After(query.Since) {
+			continue
+		}
+		if !query.Until.IsZero() && f.date.After(query.Until) {
+			continue
+		}
+
+		read := skip
+		err := scanLogFile(f, skip, func(line api.LogLine) bool {
+			read++
+			if !query.matches(f, line) {
+				return true
+			}
+
+			result.Lines = append(result.Lines, line)
+			size += len(line.Msg)
+			if len(result.Lines) >= limit || size >= maxQueryBytes {
+				result.Next = position{date: f.date, segment: f.segment, stream: f.stream, line: read}.String()
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return QueryResult{}, fmt.Errorf("could not read log file %s: %w", f.path, err)
+		}
+		if result.Next != "" {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// matches reports whether line of file f is selected by the query.
+func (q Query) matches(f logFile, line api.LogLine) bool {
+	if q.Stream != "" && line.Stream != q.Stream {
+		return false
+	}
+
+	if line.Time != "" {
+		t, err := time.Parse(timestampFormat, line.Time)
+		if err == nil && !q.Since.IsZero() && t.Before(q.Since) {
+			return false
+		}
+		if err == nil && !q.Until.IsZero() && t.After(q.Until) {
+			return false
+		}
+	}
+
+	return q.Grep == nil || q.Grep.MatchString(line.Msg)
+}
+
+// scanLogFile calls fn for every line of f after the first skip ones, until
+// fn returns false.
+func scanLogFile(f logFile, skip int, fn func(line api.LogLine) bool) error {
+	file, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) && !f.compressed {
+		// Compressed since it was listed.
+		f.path, f.compressed = f.path+".gz", true
+		file, err = os.Open(f.path)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if f.compressed {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	br := bufio.NewReader(r)
+	for n := 0; ; n++ {
+		text, err := br.ReadBytes('\n')
+		if len(text) > 0 && n >= skip {
+			if !fn(parseLogLine(bytes.TrimRight(text, "\r\n"), f.stream)) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+var textLinePattern = regexp.MustCompile(`^\[(\d{4}-\d{2}-\d{2}T[\d:.]+Z)\] \[(\w+)\] ?`)
+
+// parseLogLine reads a line written in any of the formats. Lines in the raw
+// format have no time, their stream is that of the file, if any.
+func parseLogLine(text []byte, stream api.StreamType) api.LogLine {
+	if m := textLinePattern.FindSubmatch(text); m != nil {
+		return api.LogLine{Time: string(m[1]), Stream: api.StreamType(m[2]), Msg: string(text[len(m[0]):])}
+	}
+
+	if len(text) > 0 && text[0] == '{' {
+		var line struct {
+			Ts     string          `json:"ts"`
+			Stream api.StreamType  `json:"stream"`
+			Msg    json.RawMessage `json:"msg"`
+		}
+		if json.Unmarshal(text, &line) == nil && line.Ts != "" && line.Msg != nil {
+			var msg string
+			if json.Unmarshal(line.Msg, &msg) != nil {
+				// Output that was a JSON object itself.
+				msg = string(line.Msg)
+			}
+			return api.LogLine{Time: line.Ts, Stream: line.Stream, Msg: msg}
+		}
+	}
+
+	return api.LogLine{Stream: stream, Msg: string(text)}
+}
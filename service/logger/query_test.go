@@ -0,0 +1,187 @@
+package logger
+
+import (
+	"compress/gzip"
+	"jstarpl/jpm/api"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// writeQueryFixture writes the log files of process "3-web" over two days:
+// a compressed text file, then a JSON file with a raw line in between.
+func writeQueryFixture(t *testing.T, dir string) {
+	t.Helper()
+
+	f, err := os.Create(filepath.Join(dir, "3-web-2026-01-01.log.gz"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("[2026-01-01T10:00:00.000Z] [stdout] starting\n" +
+		"[2026-01-01T11:00:00.000Z] [stderr] error: disk full\n" +
+		"[2026-01-01T12:00:00.000Z] [stdout] recovered\n"))
+	gz.Close()
+	f.Close()
+
+	content := `{"ts":"2026-01-02T09:00:00.000Z","stream":"stdout","process_id":"3","msg":"hello"}
+plain raw output
+{"ts":"2026-01-02T10:00:00.000Z","stream":"stderr","process_id":"3","msg":{"level":"error","error":"timeout"}}
+`
+	if err := os.WriteFile(filepath.Join(dir, "3-web-2026-01-02.log"), []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	// Another process, which must not show up.
+	if err := os.WriteFile(filepath.Join(dir, "4-web-2026-01-02.log"), []byte("[2026-01-02T09:00:00.000Z] [stdout] other\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func msgs(lines []api.LogLine) []string {
+	var out []string
+	for _, line := range lines {
+		out = append(out, line.Msg)
+	}
+	return out
+}
+
+func TestQueryFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFixture(t, dir)
+
+	result, err := QueryFiles(dir, "3", "web", Query{})
+	if err != nil {
+		t.Fatalf("QueryFiles: %v", err)
+	}
+	want := []api.LogLine{
+		{Time: "2026-01-01T10:00:00.000Z", Stream: api.Stdout, Msg: "starting"},
+		{Time: "2026-01-01T11:00:00.000Z", Stream: api.Stderr, Msg: "error: disk full"},
+		{Time: "2026-01-01T12:00:00.000Z", Stream: api.Stdout, Msg: "recovered"},
+		{Time: "2026-01-02T09:00:00.000Z", Stream: api.Stdout, Msg: "hello"},
+		{Msg: "plain raw output"},
+		{Time: "2026-01-02T10:00:00.000Z", Stream: api.Stderr, Msg: `{"level":"error","error":"timeout"}`},
+	}
+	if len(result.Lines) != len(want) {
+		t.Fatalf("got %q, want %d lines", msgs(result.Lines), len(want))
+	}
+	for i := range want {
+		if result.Lines[i] != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, result.Lines[i], want[i])
+		}
+	}
+	if result.Next != "" {
+		t.Errorf("Next = %q, want none after the last file", result.Next)
+	}
+}
+
+func TestQueryFiles_Filters(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFixture(t, dir)
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"grep", Query{Grep: regexp.MustCompile(`(?i)ERROR`)}, []string{"error: disk full", `{"level":"error","error":"timeout"}`}},
+		{"stream", Query{Stream: api.Stderr}, []string{"error: disk full", `{"level":"error","error":"timeout"}`}},
+		{"since", Query{Since: time.Date(2026, 1, 1, 11, 30, 0, 0, time.UTC)}, []string{"recovered", "hello", "plain raw output", `{"level":"error","error":"timeout"}`}},
+		{"until", Query{Until: time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC)}, []string{"starting", "error: disk full"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := QueryFiles(dir, "3", "web", tt.query)
+			if err != nil {
+				t.Fatalf("QueryFiles: %v", err)
+			}
+			got := msgs(result.Lines)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %q, want %q", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestQueryFiles_Pagination(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFixture(t, dir)
+
+	var got []string
+	query := Query{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatalf("pagination does not end, got %q", got)
+		}
+		result, err := QueryFiles(dir, "3", "web", query)
+		if err != nil {
+			t.Fatalf("QueryFiles: %v", err)
+		}
+		if len(result.Lines) > 2 {
+			t.Fatalf("page has %d lines, want at most 2", len(result.Lines))
+		}
+		got = append(got, msgs(result.Lines)...)
+		if result.Next == "" {
+			break
+		}
+		query.After = result.Next
+	}
+
+	if len(got) != 6 || got[0] != "starting" || got[5] != `{"level":"error","error":"timeout"}` {
+		t.Errorf("pages returned %q, want every line once in order", got)
+	}
+}
+
+func TestQueryFiles_Split(t *testing.T) {
+	dir := t.TempDir()
+	pl, err := NewProcessLogger(dir, "5", "split", Options{RetentionDays: DefaultRetentionDays, Split: true, Format: FormatRaw})
+	if err != nil {
+		t.Fatalf("NewProcessLogger: %v", err)
+	}
+	pl.Write(api.StdStreamMessage{StreamType: api.Stdout, Data: []byte("out\n")})
+	pl.Write(api.StdStreamMessage{StreamType: api.Stderr, Data: []byte("err\n")})
+	pl.Close()
+
+	result, err := QueryFiles(dir, "5", "split", Query{Stream: api.Stderr})
+	if err != nil {
+		t.Fatalf("QueryFiles: %v", err)
+	}
+	if len(result.Lines) != 1 || result.Lines[0] != (api.LogLine{Stream: api.Stderr, Msg: "err"}) {
+		t.Errorf("got %+v, want the raw stderr line with the stream of its file", result.Lines)
+	}
+}
+
+func TestQueryFiles_InvalidCursor(t *testing.T) {
+	if _, err := QueryFiles(t.TempDir(), "3", "web", Query{After: "nope"}); err == nil {
+		t.Error("expected an error for an invalid cursor")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input string
+		want  time.Time
+	}{
+		{"2026-01-01T08:30:00Z", time.Date(2026, 1, 1, 8, 30, 0, 0, time.UTC)},
+		{"2026-01-01", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"2h", time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{"7d", time.Date(2025, 12, 26, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.input, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, %v, want %v", tt.input, got, err, tt.want)
+		}
+	}
+	if _, err := ParseTime("yesterday", now); err == nil {
+		t.Error("expected an error for an invalid time")
+	}
+}
@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+)
+
+// RedactedText replaces secrets in process output.
+const RedactedText = "[REDACTED]"
+
+// minSecretLength is the length below which a secret value is not masked,
+// as it would mask ordinary output all over.
+const minSecretLength = 4
+
+// Redactor masks secrets in process output before it is logged or shown.
+// A nil Redactor leaves output as is.
+type Redactor struct {
+	patterns []*regexp.Regexp
+	secrets  [][]byte
+}
+
+// NewRedactor masks matches of patterns and occurrences of secrets. For a
+// pattern with capture groups only the groups are masked, so `token=(\S+)`
+// keeps "token=". It returns nil if there is nothing to mask.
+func NewRedactor(patterns []*regexp.Regexp, secrets []string) *Redactor {
+	r := &Redactor{patterns: patterns}
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength {
+			r.secrets = append(r.secrets, []byte(secret))
+		}
+	}
+	// Longer secrets first, in case one contains another.
+	sort.Slice(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+
+	if len(r.patterns) == 0 && len(r.secrets) == 0 {
+		return nil
+	}
+	return r
+}
+
+// Redact returns data with secrets masked. data is returned unchanged, not
+// copied, if it holds none.
+func (r *Redactor) Redact(data []byte) []byte {
+	if r == nil {
+		return data
+	}
+
+	for _, secret := range r.secrets {
+		if bytes.Contains(data, secret) {
+			data = bytes.ReplaceAll(data, secret, []byte(RedactedText))
+		}
+	}
+
+	for _, pattern := range r.patterns {
+		matches := pattern.FindAllSubmatchIndex(data, -1)
+		if matches == nil {
+			continue
+		}
+
+		var out []byte
+		last := 0
+		for _, m := range matches {
+			spans := [][]int{m[:2]}
+			if len(m) > 2 {
+				spans = nil
+				for i := 2; i+1 < len(m); i += 2 {
+					if m[i] >= 0 {
+						spans = append(spans, m[i:i+2])
+					}
+				}
+			}
+			for _, span := range spans {
+				// Nested groups overlap the group they are in.
+				if span[0] < last || span[0] == span[1] {
+					continue
+				}
+				out = append(out, data[last:span[0]]...)
+				out = append(out, RedactedText...)
+				last = span[1]
+			}
+		}
+		data = append(out, data[last:]...)
+	}
+
+	return data
+}
@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"jstarpl/jpm/api"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	r := NewRedactor([]*regexp.Regexp{
+		regexp.MustCompile(`postgres://[^@\s]+@`),
+		regexp.MustCompile(`(?i)password=(\S+)`),
+	}, []string{"s3cr3t-token", "s3cr3t", "abc"})
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"nothing to see", "nothing to see"},
+		{"Bearer s3cr3t-token", "Bearer [REDACTED]"},
+		{"s3cr3t and s3cr3t", "[REDACTED] and [REDACTED]"},
+		{"connecting to postgres://app:hunter2@db:5432", "connecting to [REDACTED]db:5432"},
+		{"login PASSWORD=hunter2 user=app", "login PASSWORD=[REDACTED] user=app"},
+		// Secrets shorter than minSecretLength are not masked.
+		{"abc", "abc"},
+	}
+	for _, tt := range tests {
+		got := string(r.Redact([]byte(tt.input)))
+		if got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestRedactor_Nil(t *testing.T) {
+	r := NewRedactor(nil, []string{"", "ab"})
+	if r != nil {
+		t.Fatalf("NewRedactor without anything to mask = %v, want nil", r)
+	}
+	if got := string(r.Redact([]byte("s3cr3t"))); got != "s3cr3t" {
+		t.Errorf("nil Redactor changed output to %q", got)
+	}
+}
+
+// TestRedactor_NeverHitsDisk writes redacted output the way the service
+// does and checks that no log format puts the secrets on disk.
+func TestRedactor_NeverHitsDisk(t *testing.T) {
+	const token = "ghp_0123456789abcdef"
+	r := NewRedactor([]*regexp.Regexp{regexp.MustCompile(`api_key=(\w+)`)}, []string{token})
+
+	output := []api.StdStreamMessage{
+		{StreamType: api.Stdout, Data: []byte("using token " + token + "\n")},
+		{StreamType: api.Stderr, Data: []byte(`{"msg":"auth failed","token":"` + token + `"}` + "\n")},
+		{StreamType: api.Stdout, Data: []byte("GET /?api_key=hunter2xyz\n")},
+	}
+
+	for _, format := range []Format{FormatText, FormatRaw, FormatJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			dir := t.TempDir()
+			pl, err := NewProcessLogger(dir, "0", "app", Options{RetentionDays: DefaultRetentionDays, Format: format})
+			if err != nil {
+				t.Fatalf("NewProcessLogger: %v", err)
+			}
+			for _, msg := range output {
+				msg.Data = r.Redact(msg.Data)
+				if err := pl.Write(msg); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			pl.Close()
+
+			content, err := os.ReadFile(filepath.Join(dir, filepath.Base(pl.files[0].path)))
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			for _, secret := range []string{token, "hunter2xyz"} {
+				if strings.Contains(string(content), secret) {
+					t.Errorf("log file contains secret %q:\n%s", secret, content)
+				}
+			}
+			if strings.Count(string(content), RedactedText) != 3 {
+				t.Errorf("log file should mask 3 secrets, got:\n%s", content)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+	"jstarpl/jpm/service/logger"
+	"regexp"
+	"time"
+)
+
+// logQuery validates the parameters of a log query.
+func logQuery(params api.RequestQueryLogsParams) (logger.Query, error) {
+	now := time.Now().UTC()
+	query := logger.Query{
+		Stream: params.Stream,
+		Limit:  params.Limit,
+		After:  params.After,
+	}
+
+	var err error
+	if params.Since != "" {
+		if query.Since, err = logger.ParseTime(params.Since, now); err != nil {
+			return query, fmt.Errorf("since: %w", err)
+		}
+	}
+	if params.Until != "" {
+		if query.Until, err = logger.ParseTime(params.Until, now); err != nil {
+			return query, fmt.Errorf("until: %w", err)
+		}
+	}
+	if params.Grep != "" {
+		if query.Grep, err = regexp.Compile(params.Grep); err != nil {
+			return query, fmt.Errorf("grep: %w", err)
+		}
+	}
+
+	switch params.Stream {
+	case "", api.Stdout, api.Stderr:
+	default:
+		return query, fmt.Errorf("stream: unknown stream %q", params.Stream)
+	}
+	if params.Limit < 0 || params.Limit > logger.MaxQueryLimit {
+		return query, fmt.Errorf("limit: must be between 0 and %d", logger.MaxQueryLimit)
+	}
+	if params.After != "" {
+		if err := logger.ValidateCursor(params.After); err != nil {
+			return query, fmt.Errorf("after: %w", err)
+		}
+	}
+
+	return query, nil
+}
+
+// queryLogsResult runs a log query for the process in params.
+func queryLogsResult(params api.RequestQueryLogsParams, query logger.Query) (*api.ResponseResult, error) {
+	result, err := executor.QueryLogs(params.Id, query)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &api.ResponseResult{LogLines: &result.Lines}
+	if result.Next != "" {
+		res.Next = &result.Next
+	}
+	return res, nil
+}
+
+// pruneBefore validates the parameters of a prune request, it returns the
+// time files must be older than, zero for the retention of each process.
+func pruneBefore(params api.RequestPruneLogsParams) (time.Time, error) {
+	if params.OlderThan == "" {
+		return time.Time{}, nil
+	}
+	before, err := logger.ParseTime(params.OlderThan, time.Now().UTC())
+	if err != nil {
+		return before, fmt.Errorf("older than: %w", err)
+	}
+	return before, nil
+}
+
+// pruneLogs removes the log files older than before. It fails if any of them
+// could not be removed.
+func pruneLogs(before time.Time, dryRun bool) (*api.ResponseResult, error) {
+	files, err := executor.PruneLogs(before, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("removed %d files, but not all: %w", len(files), err)
+	}
+
+	pruned := make([]api.PrunedFile, 0, len(files))
+	for _, f := range files {
+		pruned = append(pruned, api.PrunedFile{Path: f.Path, Size: f.Size})
+	}
+	return &api.ResponseResult{PrunedFiles: &pruned}, nil
+}
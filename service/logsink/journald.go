@@ -0,0 +1,108 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"jstarpl/jpm/service/logger"
+	"net"
+	"sync"
+)
+
+const defaultJournalSocket = "/run/systemd/journal/socket"
+
+// journaldSink sends entries to the systemd journal using its native
+// protocol, see https://systemd.io/JOURNAL_NATIVE_PROTOCOL/.
+type journaldSink struct {
+	addr string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	failing bool
+	closed  bool
+}
+
+func newJournaldSink(config Config) (*journaldSink, error) {
+	addr := config.Address
+	if addr == "" {
+		addr = defaultJournalSocket
+	}
+	return &journaldSink{addr: addr}, nil
+}
+
+// format renders entry as journal fields. SYSLOG_IDENTIFIER is the process
+// name, so `journalctl -t <name>` shows the output of a process.
+func (s *journaldSink) format(entry logger.Entry) []byte {
+	var buf bytes.Buffer
+	appendJournalField(&buf, "MESSAGE", entry.Msg)
+	appendJournalField(&buf, "PRIORITY", []byte{byte('0' + severity(entry.Stream))})
+	appendJournalField(&buf, "SYSLOG_IDENTIFIER", []byte(identifier(entry)))
+	appendJournalField(&buf, "JPM_PROCESS_ID", []byte(entry.ProcessId))
+	appendJournalField(&buf, "JPM_STREAM", []byte(entry.Stream))
+	if entry.Namespace != "" {
+		appendJournalField(&buf, "JPM_NAMESPACE", []byte(entry.Namespace))
+	}
+	if entry.Instance != "" {
+		appendJournalField(&buf, "JPM_INSTANCE", []byte(entry.Instance))
+	}
+	return buf.Bytes()
+}
+
+// appendJournalField writes KEY=value, or the length-prefixed binary form
+// when value contains a newline.
+func appendJournalField(buf *bytes.Buffer, key string, value []byte) {
+	buf.WriteString(key)
+	if bytes.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.Write(value)
+		buf.WriteByte('\n')
+		return
+	}
+
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.Write(value)
+	buf.WriteByte('\n')
+}
+
+func (s *journaldSink) Send(entry logger.Entry) {
+	msg := s.format(entry)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	var err error
+	for range 2 {
+		if s.conn == nil {
+			if s.conn, err = net.Dial("unixgram", s.addr); err != nil {
+				continue
+			}
+		}
+		if _, err = s.conn.Write(msg); err == nil {
+			break
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	if err != nil && !s.failing {
+		sinkLog.Printf("Could not send to the journal, dropping output until it is reachable: %v", err)
+	}
+	s.failing = err != nil
+}
+
+func (s *journaldSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,67 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"jstarpl/jpm/api"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// parseJournalFields decodes a native protocol datagram.
+func parseJournalFields(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			t.Fatalf("unterminated field in %q", data)
+		}
+		line := data[:nl]
+		if eq := bytes.IndexByte(line, '='); eq >= 0 {
+			fields[string(line[:eq])] = string(line[eq+1:])
+			data = data[nl+1:]
+			continue
+		}
+
+		key := string(line)
+		data = data[nl+1:]
+		size := binary.LittleEndian.Uint64(data[:8])
+		fields[key] = string(data[8 : 8+size])
+		data = data[8+size+1:]
+	}
+	return fields
+}
+
+func TestJournaldSink(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "journal.sock")
+	ln, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer ln.Close()
+
+	sink, err := New(Config{Type: TypeJournald, Address: sock})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer sink.Close()
+
+	sink.Send(testEntry(api.Stdout, "first\nsecond"))
+
+	fields := parseJournalFields(t, []byte(readDatagram(t, ln)))
+	for key, want := range map[string]string{
+		"MESSAGE":           "first\nsecond",
+		"PRIORITY":          "6",
+		"SYSLOG_IDENTIFIER": "my app",
+		"JPM_PROCESS_ID":    "3",
+		"JPM_STREAM":        "stdout",
+		"JPM_NAMESPACE":     "web",
+		"JPM_INSTANCE":      "default",
+	} {
+		if fields[key] != want {
+			t.Errorf("%s = %q, want %q", key, fields[key], want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package logsink
+
+import (
+	"errors"
+	"fmt"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/logger"
+	"log"
+	"time"
+)
+
+const logProps = log.Lmicroseconds | log.Ltime | log.Ldate | log.LUTC
+
+var sinkLog = log.New(log.Default().Writer(), "logsink: ", logProps)
+
+type SinkType string
+
+const (
+	TypeSyslog   SinkType = "syslog"
+	TypeJournald SinkType = "journald"
+	TypeTCP      SinkType = "tcp"
+	TypeHTTP     SinkType = "http"
+)
+
+// Config describes a sink receiving the output of all processes.
+type Config struct {
+	Type SinkType `json:"type" yaml:"type"`
+	// Address is where to send the output to:
+	//   - syslog: "unix:///dev/log" or "udp://host:514", the local syslog if empty
+	//   - journald: the journal socket, "/run/systemd/journal/socket" if empty
+	//   - tcp: "host:port"
+	//   - http: the URL lines are POSTed to
+	Address string `json:"address,omitempty" yaml:"address,omitempty"`
+	// Facility is the syslog facility, "user" if empty.
+	Facility string `json:"facility,omitempty" yaml:"facility,omitempty"`
+	// Headers are added to HTTP requests.
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// Buffer is the number of lines the tcp and http sinks keep while the
+	// collector is unreachable. The oldest lines are dropped beyond it.
+	Buffer int `json:"buffer,omitempty" yaml:"buffer,omitempty"`
+	// Batch is the maximum number of lines sent at once by the tcp and http sinks.
+	Batch int `json:"batch,omitempty" yaml:"batch,omitempty"`
+	// RetryInterval is the delay before the first retry of a failed send; it
+	// doubles on every attempt.
+	RetryInterval time.Duration `json:"retry_interval,omitempty" yaml:"retry_interval,omitempty"`
+}
+
+// Sink receives lines of process output. Send must not block for long, as
+// it is called on the path relaying output of a process. Send after Close
+// drops the entry, since relays may still hold a replaced sink.
+type Sink interface {
+	Send(entry logger.Entry)
+	Close() error
+}
+
+// New creates the sink described by config.
+func New(config Config) (Sink, error) {
+	switch config.Type {
+	case TypeSyslog:
+		return newSyslogSink(config)
+	case TypeJournald:
+		return newJournaldSink(config)
+	case TypeTCP:
+		return newTCPShipper(config)
+	case TypeHTTP:
+		return newHTTPShipper(config)
+	}
+	return nil, fmt.Errorf("unknown log sink type %q", config.Type)
+}
+
+// Set sends output to several sinks.
+type Set struct {
+	sinks []Sink
+}
+
+// NewSet creates a sink for every config. On error, the sinks created so
+// far are closed.
+func NewSet(configs []Config) (*Set, error) {
+	set := &Set{}
+	for _, config := range configs {
+		sink, err := New(config)
+		if err != nil {
+			set.Close()
+			return nil, fmt.Errorf("could not create %s log sink: %w", config.Type, err)
+		}
+		set.sinks = append(set.sinks, sink)
+	}
+	return set, nil
+}
+
+// Len returns the number of sinks in the set.
+func (s *Set) Len() int {
+	return len(s.sinks)
+}
+
+func (s *Set) Send(entry logger.Entry) {
+	for _, sink := range s.sinks {
+		sink.Send(entry)
+	}
+}
+
+func (s *Set) Close() error {
+	var errs []error
+	for _, sink := range s.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// severity returns the syslog severity for output of stream: error for
+// stderr, informational otherwise.
+func severity(stream api.StreamType) int {
+	if stream == api.Stderr {
+		return 3
+	}
+	return 6
+}
+
+// identifier names the process in syslog and the journal.
+func identifier(entry logger.Entry) string {
+	if entry.Name != "" {
+		return entry.Name
+	}
+	return "jpm-" + entry.ProcessId
+}
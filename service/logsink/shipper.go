@@ -0,0 +1,262 @@
+package logsink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"jstarpl/jpm/service/logger"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultShipperBuffer = 10000
+	defaultShipperBatch  = 500
+	defaultRetryInterval = 1 * time.Second
+	maxRetryInterval     = 1 * time.Minute
+	shipperTimeout       = 10 * time.Second
+)
+
+// shipper sends entries as JSON Lines to a remote collector. Lines are
+// buffered, so a collector that is slow or down does not hold up the
+// process, and resent until they are accepted.
+type shipper struct {
+	name          string
+	send          func(batch [][]byte) error
+	close         func() error
+	maxBuffer     int
+	batch         int
+	retryInterval time.Duration
+
+	mu     sync.Mutex
+	buffer [][]byte
+	// inFlight is the number of lines at the start of buffer being sent.
+	inFlight int
+	dropped  int
+	closed   bool
+
+	wake chan struct{}
+	done chan struct{}
+	// stopped is closed when run has returned.
+	stopped chan struct{}
+}
+
+func newShipper(name string, config Config, send func([][]byte) error, close func() error) *shipper {
+	s := &shipper{
+		name:          name,
+		send:          send,
+		close:         close,
+		maxBuffer:     config.Buffer,
+		batch:         config.Batch,
+		retryInterval: config.RetryInterval,
+		wake:          make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	if s.maxBuffer <= 0 {
+		s.maxBuffer = defaultShipperBuffer
+	}
+	if s.batch <= 0 {
+		s.batch = defaultShipperBatch
+	}
+	if s.retryInterval <= 0 {
+		s.retryInterval = defaultRetryInterval
+	}
+
+	go s.run()
+	return s
+}
+
+func (s *shipper) Send(entry logger.Entry) {
+	line, err := entry.MarshalJSON()
+	if err != nil {
+		sinkLog.Printf("Could not encode output for %s: %v", s.name, err)
+		return
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.buffer = append(s.buffer, line)
+	if len(s.buffer) > s.maxBuffer && len(s.buffer) > s.inFlight {
+		// Drop the oldest line that is not being sent.
+		s.buffer = append(s.buffer[:s.inFlight], s.buffer[s.inFlight+1:]...)
+		s.dropped++
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends buffered lines until Close is called.
+func (s *shipper) run() {
+	defer close(s.stopped)
+
+	delay := s.retryInterval
+	for {
+		select {
+		case <-s.wake:
+		case <-s.done:
+			// Try once more to deliver what is left.
+			s.sendBatch()
+			return
+		}
+
+		for {
+			sent, err := s.sendBatch()
+			if err != nil {
+				if delay == s.retryInterval {
+					sinkLog.Printf("Could not send output to %s, retrying: %v", s.name, err)
+				}
+				select {
+				case <-time.After(delay):
+				case <-s.done:
+					return
+				}
+				delay = min(delay*2, maxRetryInterval)
+				continue
+			}
+			if delay != s.retryInterval {
+				sinkLog.Printf("Sending output to %s again", s.name)
+				delay = s.retryInterval
+			}
+			if sent == 0 {
+				break
+			}
+		}
+	}
+}
+
+// sendBatch sends the oldest buffered lines and returns how many were sent.
+func (s *shipper) sendBatch() (int, error) {
+	s.mu.Lock()
+	if s.dropped > 0 {
+		sinkLog.Printf("Dropped %d lines of output for %s, buffer was full", s.dropped, s.name)
+		s.dropped = 0
+	}
+	s.inFlight = min(len(s.buffer), s.batch)
+	batch := s.buffer[:s.inFlight:s.inFlight]
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	err := s.send(batch)
+
+	s.mu.Lock()
+	if err == nil {
+		s.buffer = s.buffer[s.inFlight:]
+	}
+	s.inFlight = 0
+	s.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+func (s *shipper) Close() error {
+	s.mu.Lock()
+	closed := s.closed
+	s.closed = true
+	s.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	close(s.done)
+	<-s.stopped
+	if s.close != nil {
+		return s.close()
+	}
+	return nil
+}
+
+// newTCPShipper sends lines over a TCP connection, reconnecting as needed.
+func newTCPShipper(config Config) (*shipper, error) {
+	if config.Address == "" {
+		return nil, errors.New("address is required")
+	}
+
+	var conn net.Conn
+	var mu sync.Mutex
+	send := func(batch [][]byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if conn == nil {
+			c, err := net.DialTimeout("tcp", config.Address, shipperTimeout)
+			if err != nil {
+				return err
+			}
+			conn = c
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(shipperTimeout))
+		buffers := net.Buffers(batch)
+		if _, err := buffers.WriteTo(conn); err != nil {
+			conn.Close()
+			conn = nil
+			return err
+		}
+		return nil
+	}
+	closeConn := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if conn == nil {
+			return nil
+		}
+		return conn.Close()
+	}
+
+	return newShipper("tcp://"+config.Address, config, send, closeConn), nil
+}
+
+// newHTTPShipper POSTs batches of lines as application/x-ndjson.
+func newHTTPShipper(config Config) (*shipper, error) {
+	u, err := url.Parse(config.Address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL %q", config.Address)
+	}
+
+	client := &http.Client{Timeout: shipperTimeout}
+	send := func(batch [][]byte) error {
+		req, err := http.NewRequest(http.MethodPost, config.Address, bytes.NewReader(bytes.Join(batch, nil)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		for key, value := range config.Headers {
+			req.Header.Set(key, value)
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			return fmt.Errorf("collector responded with %s", res.Status)
+		}
+		return nil
+	}
+
+	return newShipper(u.Redacted(), config, send, nil), nil
+}
@@ -0,0 +1,167 @@
+package logsink
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"jstarpl/jpm/api"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTCPShipper(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	lines := make(chan string, 10)
+	go (func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	})()
+
+	sink, err := New(Config{Type: TypeTCP, Address: ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer sink.Close()
+
+	sink.Send(testEntry(api.Stdout, "one"))
+	sink.Send(testEntry(api.Stdout, `{"level":"warn"}`))
+
+	for _, want := range []string{`"msg":"one"`, `"msg":{"level":"warn"}`} {
+		select {
+		case line := <-lines:
+			if !strings.Contains(line, want) || !strings.Contains(line, `"process_id":"3"`) {
+				t.Errorf("line %s does not contain %s", line, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("line was not shipped")
+		}
+	}
+}
+
+func TestHTTPShipper_RetriesAndBuffers(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/x-ndjson" || r.Header.Get("Authorization") != "Bearer x" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Errorf("line %q is not JSON: %v", line, err)
+			}
+			received = append(received, entry["msg"].(string))
+		}
+	}))
+	defer srv.Close()
+
+	sink, err := New(Config{
+		Type:          TypeHTTP,
+		Address:       srv.URL,
+		Headers:       map[string]string{"Authorization": "Bearer x"},
+		RetryInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	sink.Send(testEntry(api.Stdout, "a"))
+	sink.Send(testEntry(api.Stderr, "b"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		done := len(received) == 2
+		mu.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sink.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(received, ",") != "a,b" {
+		t.Errorf("received %v, want a and b once, in order", received)
+	}
+	if attempts < 2 {
+		t.Errorf("expected a retry after the failure, got %d attempts", attempts)
+	}
+}
+
+func TestShipper_DropsOldestWhenFull(t *testing.T) {
+	block := make(chan struct{})
+	var sent []string
+	s := newShipper("test", Config{Buffer: 2, Batch: 10}, func(batch [][]byte) error {
+		<-block
+		for _, line := range batch {
+			var entry map[string]any
+			json.Unmarshal(line, &entry)
+			sent = append(sent, entry["msg"].(string))
+		}
+		return nil
+	}, nil)
+
+	s.Send(testEntry(api.Stdout, "1"))
+	// Wait for "1" to be in flight, it must not be dropped.
+	for {
+		s.mu.Lock()
+		inFlight := s.inFlight
+		s.mu.Unlock()
+		if inFlight == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, msg := range []string{"2", "3", "4"} {
+		s.Send(testEntry(api.Stdout, msg))
+	}
+	close(block)
+	s.Close()
+
+	if got := strings.Join(sent, ","); got != "1,4" {
+		t.Errorf("sent %s, want the line in flight and the newest one", got)
+	}
+}
+
+func TestShipper_SendAfterClose(t *testing.T) {
+	s := newShipper("test", Config{}, func(batch [][]byte) error { return nil }, nil)
+	s.Close()
+
+	s.Send(testEntry(api.Stdout, "late"))
+	if len(s.buffer) != 0 {
+		t.Errorf("Send after Close buffered %d lines", len(s.buffer))
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
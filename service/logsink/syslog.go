@@ -0,0 +1,162 @@
+package logsink
+
+import (
+	"errors"
+	"fmt"
+	"jstarpl/jpm/service/logger"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Local syslog sockets, as probed by log/syslog.
+var localSyslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+const syslogTimestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// syslogSink sends RFC 5424 messages over a Unix datagram socket or UDP.
+type syslogSink struct {
+	network  string
+	addrs    []string
+	facility int
+	hostname string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	failing bool
+	closed  bool
+}
+
+func newSyslogSink(config Config) (*syslogSink, error) {
+	s := &syslogSink{facility: facilities["user"]}
+
+	if config.Facility != "" {
+		facility, ok := facilities[strings.ToLower(config.Facility)]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog facility %q", config.Facility)
+		}
+		s.facility = facility
+	}
+
+	switch {
+	case config.Address == "":
+		s.network, s.addrs = "unixgram", localSyslogPaths
+	default:
+		u, err := url.Parse(config.Address)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "unix", "unixgram":
+			s.network, s.addrs = "unixgram", []string{u.Path}
+		case "udp":
+			s.network, s.addrs = "udp", []string{u.Host}
+		default:
+			return nil, fmt.Errorf("unsupported syslog address %q, use unix:// or udp://", config.Address)
+		}
+	}
+
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+
+	return s, nil
+}
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	var errs []error
+	for _, addr := range s.addrs {
+		conn, err := net.Dial(s.network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// format renders entry as an RFC 5424 message. The process id is the
+// PROCID and the stream the MSGID.
+func (s *syslogSink) format(entry logger.Entry) []byte {
+	return fmt.Appendf(nil, "<%d>1 %s %s %s %s %s - %s",
+		s.facility*8+severity(entry.Stream),
+		entry.Time.Format(syslogTimestampFormat),
+		s.hostname,
+		syslogField(identifier(entry), 48),
+		syslogField(entry.ProcessId, 128),
+		syslogField(string(entry.Stream), 32),
+		entry.Msg,
+	)
+}
+
+func (s *syslogSink) Send(entry logger.Entry) {
+	msg := s.format(entry)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	// Retry once on a fresh connection, the syslog daemon may have restarted.
+	var err error
+	for range 2 {
+		if s.conn == nil {
+			if s.conn, err = s.dial(); err != nil {
+				continue
+			}
+		}
+		if _, err = s.conn.Write(msg); err == nil {
+			break
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	if err != nil && !s.failing {
+		sinkLog.Printf("Could not send to syslog, dropping output until it is reachable: %v", err)
+	}
+	s.failing = err != nil
+}
+
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// syslogField makes value a valid RFC 5424 header field: printable ASCII
+// without spaces, at most maxLength long, "-" if empty.
+func syslogField(value string, maxLength int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(field) > maxLength {
+		field = field[:maxLength]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
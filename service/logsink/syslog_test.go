@@ -0,0 +1,124 @@
+package logsink
+
+import (
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/logger"
+	"net"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func testEntry(stream api.StreamType, msg string) logger.Entry {
+	return logger.Entry{
+		Time:      time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+		Stream:    stream,
+		ProcessId: "3",
+		Name:      "my app",
+		Namespace: "web",
+		Instance:  "default",
+		Msg:       []byte(msg),
+	}
+}
+
+func readDatagram(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 65536)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	return string(buf[:n])
+}
+
+var rfc5424 = regexp.MustCompile(`^<(\d+)>1 (\S+) (\S+) (\S+) (\S+) (\S+) - (.*)$`)
+
+func TestSyslogSink_Unix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "log.sock")
+	ln, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer ln.Close()
+
+	sink, err := New(Config{Type: TypeSyslog, Address: "unix://" + sock, Facility: "local0"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer sink.Close()
+
+	sink.Send(testEntry(api.Stderr, "something failed"))
+
+	m := rfc5424.FindStringSubmatch(readDatagram(t, ln))
+	if m == nil {
+		t.Fatalf("message is not RFC 5424")
+	}
+	// local0 (16) * 8 + err (3)
+	if m[1] != "131" {
+		t.Errorf("PRI = %s, want 131", m[1])
+	}
+	if m[2] != "2024-05-01T12:30:00.000000Z" {
+		t.Errorf("TIMESTAMP = %s", m[2])
+	}
+	if m[4] != "my_app" || m[5] != "3" || m[6] != "stderr" {
+		t.Errorf("APP-NAME PROCID MSGID = %s %s %s", m[4], m[5], m[6])
+	}
+	if m[7] != "something failed" {
+		t.Errorf("MSG = %q", m[7])
+	}
+}
+
+func TestSyslogSink_SendAfterClose(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "log.sock")
+	ln, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer ln.Close()
+
+	sink, err := newSyslogSink(Config{Type: TypeSyslog, Address: "unix://" + sock})
+	if err != nil {
+		t.Fatalf("newSyslogSink: %v", err)
+	}
+	sink.Send(testEntry(api.Stdout, "before"))
+	readDatagram(t, ln)
+	sink.Close()
+
+	sink.Send(testEntry(api.Stdout, "after"))
+	if sink.conn != nil {
+		t.Errorf("Send after Close reconnected")
+	}
+}
+
+func TestSyslogSink_UDP(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer ln.Close()
+
+	sink, err := New(Config{Type: TypeSyslog, Address: "udp://" + ln.LocalAddr().String()})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer sink.Close()
+
+	sink.Send(testEntry(api.Stdout, "hello"))
+
+	m := rfc5424.FindStringSubmatch(readDatagram(t, ln))
+	if m == nil {
+		t.Fatalf("message is not RFC 5424")
+	}
+	// user (1) * 8 + info (6)
+	if m[1] != "14" || m[7] != "hello" {
+		t.Errorf("PRI = %s, MSG = %q", m[1], m[7])
+	}
+}
+
+func TestSyslogSink_UnknownFacility(t *testing.T) {
+	if _, err := New(Config{Type: TypeSyslog, Facility: "nope"}); err == nil {
+		t.Errorf("expected an error for an unknown facility")
+	}
+}
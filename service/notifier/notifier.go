@@ -0,0 +1,340 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type EventType string
+
+const (
+	EventStart EventType = "start"
+	EventExit  EventType = "exit"
+	EventFail  EventType = "fail"
+)
+
+const (
+	defaultWebhookRetries = 3
+	defaultWebhookTimeout = 5 * time.Second
+
+	// SignatureHeader carries the hex encoded HMAC-SHA256 of the request body,
+	// prefixed with "sha256=", when a webhook secret is configured.
+	SignatureHeader = "X-JPM-Signature"
+	EventHeader     = "X-JPM-Event"
+)
+
+// webhookRetryDelay is the delay before the first retry; it doubles on every attempt.
+var webhookRetryDelay = 1 * time.Second
+
+const logProps = log.Lmicroseconds | log.Ltime | log.Ldate | log.LUTC
+
+var notifyLog = log.New(log.Default().Writer(), "notifier: ", logProps)
+
+// WebhookConfig describes an HTTP endpoint receiving JSON event payloads.
+type WebhookConfig struct {
+	URL     string        `json:"url" yaml:"url"`
+	Secret  string        `json:"secret,omitempty" yaml:"secret,omitempty"`
+	Events  []EventType   `json:"events,omitempty" yaml:"events,omitempty"`
+	Retries int           `json:"retries,omitempty" yaml:"retries,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// CommandsConfig holds local commands run through the system shell when a
+// process event occurs. The event is passed to the command as JPM_* env vars.
+type CommandsConfig struct {
+	OnStart string `json:"on_start,omitempty" yaml:"on_start,omitempty"`
+	OnExit  string `json:"on_exit,omitempty" yaml:"on_exit,omitempty"`
+	OnFail  string `json:"on_fail,omitempty" yaml:"on_fail,omitempty"`
+}
+
+// Config lists all notification sinks.
+type Config struct {
+	Webhooks []WebhookConfig `json:"webhooks,omitempty" yaml:"webhooks,omitempty"`
+	Commands CommandsConfig  `json:"commands,omitempty" yaml:"commands,omitempty"`
+}
+
+// Notification is the JSON payload sent to webhooks.
+type Notification struct {
+	Event     EventType   `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	Process   api.Process `json:"process"`
+}
+
+// LoadConfig reads a notification Config from a YAML file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read notifications config %s: %w", path, err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse notifications config %s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// Notifier dispatches executor lifecycle events to the configured sinks.
+type Notifier struct {
+	config Config
+	client *http.Client
+	mu     sync.RWMutex
+
+	// queues holds the notifications waiting for each process that has a
+	// worker delivering them, in the order of its events.
+	queues   map[string][]Notification
+	queuesMu sync.Mutex
+}
+
+func New(cfg Config) *Notifier {
+	return &Notifier{
+		config: cfg,
+		client: &http.Client{},
+		queues: map[string][]Notification{},
+	}
+}
+
+// SetConfig replaces the notification sinks. Deliveries already in flight
+// finish with the previous configuration.
+func (n *Notifier) SetConfig(cfg Config) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.config = cfg
+}
+
+// Run subscribes to executor events and dispatches them until the event
+// relay is closed. It is meant to be run in its own goroutine.
+func (n *Notifier) Run() {
+	l := executor.SubscribeEvents()
+	defer l.Close()
+
+	for e := range l.Ch() {
+		for _, notification := range notificationsForEvent(e) {
+			n.enqueue(notification)
+		}
+	}
+}
+
+// enqueue has notification dispatched after the earlier ones of its process,
+// starting a worker for the process unless one is running.
+func (n *Notifier) enqueue(notification Notification) {
+	n.queuesMu.Lock()
+	defer n.queuesMu.Unlock()
+
+	id := notification.Process.Id
+	queue, running := n.queues[id]
+	n.queues[id] = append(queue, notification)
+	if !running {
+		go n.deliver(id)
+	}
+}
+
+// deliver dispatches the queued notifications of a process one at a time,
+// until none are left.
+func (n *Notifier) deliver(id string) {
+	for {
+		n.queuesMu.Lock()
+		queue := n.queues[id]
+		if len(queue) == 0 {
+			delete(n.queues, id)
+			n.queuesMu.Unlock()
+			return
+		}
+		n.queues[id] = queue[1:]
+		n.queuesMu.Unlock()
+
+		n.Dispatch(queue[0])
+	}
+}
+
+// notificationsForEvent maps an executor event to zero or more notifications.
+// A crash, an exit that was not requested with a non-zero code or by a
+// signal, produces both an "exit" and a "fail" notification.
+func notificationsForEvent(e executor.Event) []Notification {
+	now := time.Now().UTC()
+
+	switch {
+	case e.ProcessStatusChangeEvent != nil:
+		proc := e.ProcessStatusChangeEvent.Process
+		switch proc.Status {
+		case api.Running:
+			return []Notification{{Event: EventStart, Timestamp: now, Process: proc.ToApiProcess()}}
+		case api.Failed:
+			return []Notification{{Event: EventFail, Timestamp: now, Process: proc.ToApiProcess()}}
+		}
+	case e.ProcessExitedEvent != nil:
+		proc := e.ProcessExitedEvent.Process.ToApiProcess()
+		result := []Notification{{Event: EventExit, Timestamp: now, Process: proc}}
+		if crashed(e.ProcessExitedEvent) {
+			result = append(result, Notification{Event: EventFail, Timestamp: now, Process: proc})
+		}
+		return result
+	}
+
+	return nil
+}
+
+func crashed(e *executor.ProcessExitedEvent) bool {
+	return !e.Requested && (e.Process.ExitCode != 0 || e.Signal != "")
+}
+
+// Dispatch delivers a single notification to every sink subscribed to it.
+func (n *Notifier) Dispatch(notification Notification) {
+	n.mu.RLock()
+	cfg := n.config
+	n.mu.RUnlock()
+
+	for _, hook := range cfg.Webhooks {
+		if !hook.wants(notification.Event) {
+			continue
+		}
+		if err := n.sendWebhook(hook, notification); err != nil {
+			notifyLog.Printf("Webhook %s failed: %v", hook.URL, err)
+		}
+	}
+
+	command := cfg.Commands.forEvent(notification.Event)
+	if command != "" {
+		if err := runCommand(command, notification); err != nil {
+			notifyLog.Printf("Command hook for %s failed: %v", notification.Event, err)
+		}
+	}
+}
+
+func (w WebhookConfig) wants(event EventType) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (c CommandsConfig) forEvent(event EventType) string {
+	switch event {
+	case EventStart:
+		return c.OnStart
+	case EventExit:
+		return c.OnExit
+	case EventFail:
+		return c.OnFail
+	}
+	return ""
+}
+
+// Sign returns the signature header value for body using secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Notifier) sendWebhook(hook WebhookConfig, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	retries := hook.Retries
+	if retries <= 0 {
+		retries = defaultWebhookRetries
+	}
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 0; ; attempt++ {
+		err = n.postWebhook(hook, notification.Event, body, timeout)
+		if err == nil || attempt >= retries {
+			return err
+		}
+
+		notifyLog.Printf("Webhook %s attempt %d failed: %v, retrying in %v", hook.URL, attempt+1, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (n *Notifier) postWebhook(hook WebhookConfig, event EventType, body []byte, timeout time.Duration) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(event))
+	if hook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+	}
+
+	client := *n.client
+	client.Timeout = timeout
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	return nil
+}
+
+// commandEnv renders a notification as environment variables for command hooks.
+func commandEnv(notification Notification) []string {
+	proc := notification.Process
+	return []string{
+		"JPM_EVENT=" + string(notification.Event),
+		"JPM_TIMESTAMP=" + notification.Timestamp.Format(time.RFC3339),
+		"JPM_PROCESS_ID=" + proc.Id,
+		"JPM_PROCESS_NAME=" + proc.Name,
+		"JPM_PROCESS_NAMESPACE=" + proc.Namespace,
+		"JPM_PROCESS_EXEC=" + proc.Exec,
+		"JPM_PROCESS_STATUS=" + proc.Status.String(),
+		"JPM_EXIT_CODE=" + strconv.Itoa(proc.ExitCode),
+		"JPM_START_COUNT=" + strconv.Itoa(proc.StartCount),
+		"JPM_FAIL_COUNT=" + strconv.Itoa(proc.FailCount),
+	}
+}
+
+func runCommand(command string, notification Notification) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), commandEnv(notification)...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, bytes.TrimSpace(output))
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookStub struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (s *webhookStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func testNotification(event EventType) Notification {
+	return Notification{
+		Event:     event,
+		Timestamp: time.Now().UTC(),
+		Process: api.Process{
+			Id:        "7",
+			Name:      "web",
+			Namespace: "prod",
+			Exec:      "node",
+			Status:    api.Respawn,
+			ExitCode:  3,
+			FailCount: 2,
+		},
+	}
+}
+
+func TestWebhook_PayloadAndSignature(t *testing.T) {
+	stub := &webhookStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	n := New(Config{Webhooks: []WebhookConfig{{URL: srv.URL, Secret: "s3cret"}}})
+	n.Dispatch(testNotification(EventFail))
+
+	if len(stub.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(stub.requests))
+	}
+
+	req, body := stub.requests[0], stub.bodies[0]
+	if got := req.Header.Get(EventHeader); got != "fail" {
+		t.Errorf("%s = %q, want %q", EventHeader, got, "fail")
+	}
+	if got, want := req.Header.Get(SignatureHeader), Sign("s3cret", body); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+
+	var payload Notification
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("Unmarshal payload: %v", err)
+	}
+	if payload.Event != EventFail || payload.Process.Id != "7" || payload.Process.ExitCode != 3 {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestWebhook_Retries(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+	defer func() { webhookRetryDelay = time.Second }()
+
+	stub := &webhookStub{failures: 2}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	n := New(Config{Webhooks: []WebhookConfig{{URL: srv.URL, Retries: 3}}})
+	n.Dispatch(testNotification(EventExit))
+
+	if len(stub.requests) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(stub.requests))
+	}
+	if req := stub.requests[0]; req.Header.Get(SignatureHeader) != "" {
+		t.Errorf("signature header should be omitted without a secret")
+	}
+}
+
+func TestWebhook_EventFilter(t *testing.T) {
+	stub := &webhookStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	n := New(Config{Webhooks: []WebhookConfig{{URL: srv.URL, Events: []EventType{EventFail}}}})
+	n.Dispatch(testNotification(EventStart))
+	n.Dispatch(testNotification(EventFail))
+
+	if len(stub.requests) != 1 {
+		t.Errorf("expected only the fail event to be delivered, got %d requests", len(stub.requests))
+	}
+}
+
+func TestNotificationsForEvent(t *testing.T) {
+	proc := executor.Process{Id: "1", Status: api.Respawn, ExitCode: 1}
+
+	crash := notificationsForEvent(executor.Event{ProcessExitedEvent: &executor.ProcessExitedEvent{Process: proc}})
+	if len(crash) != 2 || crash[0].Event != EventExit || crash[1].Event != EventFail {
+		t.Errorf("crash should produce exit and fail notifications, got %+v", crash)
+	}
+
+	killed := notificationsForEvent(executor.Event{ProcessExitedEvent: &executor.ProcessExitedEvent{Process: executor.Process{Id: "1", Status: api.Respawn, ExitCode: -1}, Signal: "SIGKILL"}})
+	if len(killed) != 2 || killed[1].Event != EventFail {
+		t.Errorf("exit by a signal should produce exit and fail notifications, got %+v", killed)
+	}
+
+	done := notificationsForEvent(executor.Event{ProcessExitedEvent: &executor.ProcessExitedEvent{Process: executor.Process{Id: "1", Status: api.Respawn}}})
+	if len(done) != 1 || done[0].Event != EventExit {
+		t.Errorf("unrequested exit with code 0 should only produce exit notification, got %+v", done)
+	}
+
+	proc.Status = api.Stopped
+	stop := notificationsForEvent(executor.Event{ProcessExitedEvent: &executor.ProcessExitedEvent{Process: proc, Requested: true}})
+	if len(stop) != 1 || stop[0].Event != EventExit {
+		t.Errorf("requested stop should only produce exit notification, got %+v", stop)
+	}
+
+	proc.Status = api.Running
+	start := notificationsForEvent(executor.Event{ProcessStatusChangeEvent: &executor.ProcessStatusChangeEvent{Process: proc}})
+	if len(start) != 1 || start[0].Event != EventStart {
+		t.Errorf("running status should produce start notification, got %+v", start)
+	}
+}
+
+func TestCommandHook_Env(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("command hook test uses /bin/sh")
+	}
+
+	out := filepath.Join(t.TempDir(), "env.txt")
+	n := New(Config{Commands: CommandsConfig{
+		OnFail: `echo "$JPM_EVENT $JPM_PROCESS_ID $JPM_PROCESS_NAME $JPM_EXIT_CODE" > "` + out + `"`,
+	}})
+	n.Dispatch(testNotification(EventFail))
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if got := strings.TrimSpace(string(content)); got != "fail 7 web 3" {
+		t.Errorf("command output = %q, want %q", got, "fail 7 web 3")
+	}
+}
+
+func TestWebhook_MasksSecretEnv(t *testing.T) {
+	stub := &webhookStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	proc := executor.Process{Id: "1", Status: api.Running, Env: []string{"API_TOKEN=tok-123"}, Log: api.LogOptions{SecretEnv: []string{"API_*"}}}
+	n := New(Config{Webhooks: []WebhookConfig{{URL: srv.URL}}})
+	for _, notification := range notificationsForEvent(executor.Event{ProcessStatusChangeEvent: &executor.ProcessStatusChangeEvent{Process: proc}}) {
+		n.Dispatch(notification)
+	}
+
+	if len(stub.bodies) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(stub.bodies))
+	}
+	var payload Notification
+	if err := json.Unmarshal(stub.bodies[0], &payload); err != nil {
+		t.Fatalf("Unmarshal payload: %v", err)
+	}
+	if env := payload.Process.Env; len(env) != 1 || env[0] != "API_TOKEN=[REDACTED]" {
+		t.Errorf("payload env = %q", env)
+	}
+}
+
+func TestEnqueue_KeepsOrderOfProcess(t *testing.T) {
+	stub := &webhookStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	n := New(Config{Webhooks: []WebhookConfig{{URL: srv.URL}}})
+	events := []EventType{EventExit, EventFail, EventStart, EventExit, EventFail}
+	for _, event := range events {
+		n.enqueue(testNotification(event))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n.queuesMu.Lock()
+		idle := len(n.queues) == 0
+		n.queuesMu.Unlock()
+		if idle {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("notifications were not delivered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	var got []EventType
+	for _, req := range stub.requests {
+		got = append(got, EventType(req.Header.Get(EventHeader)))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(events) {
+		t.Errorf("delivered %v, want %v", got, events)
+	}
+}
@@ -0,0 +1,323 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"jstarpl/jpm/service/executor"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const testToken = "secret"
+
+type openAPI struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas   map[string]*schema `json:"schemas"`
+		Responses map[string]struct {
+			Content map[string]struct {
+				Schema *schema `json:"schema"`
+			} `json:"content"`
+		} `json:"responses"`
+	} `json:"components"`
+}
+
+// schema is the part of an OpenAPI schema the contract tests check.
+type schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Required   []string           `json:"required"`
+	Properties map[string]*schema `json:"properties"`
+	Items      *schema            `json:"items"`
+	Enum       []any              `json:"enum"`
+	AllOf      []*schema          `json:"allOf"`
+	Nullable   bool               `json:"nullable"`
+	Pattern    string             `json:"pattern"`
+}
+
+type operation struct {
+	Responses map[string]struct {
+		Ref     string `json:"$ref"`
+		Content map[string]struct {
+			Schema *schema `json:"schema"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+func loadSpec(t *testing.T) *openAPI {
+	t.Helper()
+
+	var spec openAPI
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return &spec
+}
+
+// responseSchema returns the schema of the JSON body of a response of the
+// operation, and whether the status is in the specification at all.
+func (spec *openAPI) responseSchema(t *testing.T, method, path string, status int) (*schema, bool) {
+	t.Helper()
+
+	raw, ok := spec.Paths[path][strings.ToLower(method)]
+	if !ok {
+		t.Fatalf("%s %s is not in the specification", method, path)
+	}
+	var op operation
+	if err := json.Unmarshal(raw, &op); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	res, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		return nil, false
+	}
+	content := res.Content
+	if res.Ref != "" {
+		content = spec.Components.Responses[strings.TrimPrefix(res.Ref, "#/components/responses/")].Content
+	}
+	return content["application/json"].Schema, true
+}
+
+// validate checks value, decoded from JSON, against s.
+func (spec *openAPI) validate(s *schema, value any, at string) error {
+	if s.Ref != "" {
+		return spec.validate(spec.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")], value, at)
+	}
+	for _, sub := range s.AllOf {
+		if err := spec.validate(sub, value, at); err != nil {
+			return err
+		}
+	}
+	if value == nil {
+		if s.Type == "" || s.Nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: null, want %s", at, s.Type)
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, value) {
+		return fmt.Errorf("%s: %v is not one of %v", at, value, s.Enum)
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an object", at, value)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: %s is missing", at, name)
+			}
+		}
+		for name, prop := range s.Properties {
+			if v, ok := obj[name]; ok {
+				if err := spec.validate(prop, v, at+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an array", at, value)
+		}
+		for i, item := range items {
+			if err := spec.validate(s.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a string", at, value)
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(str) {
+			return fmt.Errorf("%s: %q does not match %s", at, str, s.Pattern)
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: %v is not an integer", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: %v is not a boolean", at, value)
+		}
+	}
+	return nil
+}
+
+func newTestApp(t *testing.T) *fiber.App {
+	t.Helper()
+
+	config = &Service{}
+	config.Start.Token = testToken
+	return newHTTPApp()
+}
+
+// call makes a request to the app and checks the response against the
+// specification, returning the decoded body.
+func call(t *testing.T, app *fiber.App, spec *openAPI, method, path, route string, body string, want int) map[string]any {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+testToken)
+	if body != "" {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != want {
+		data, _ := io.ReadAll(res.Body)
+		t.Fatalf("%s %s: status %d, want %d: %s", method, path, res.StatusCode, want, data)
+	}
+	s, ok := spec.responseSchema(t, method, route, res.StatusCode)
+	if !ok {
+		t.Fatalf("%s %s: status %d is not in the specification", method, route, res.StatusCode)
+	}
+	if s == nil {
+		return nil
+	}
+
+	var decoded map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&decoded); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	if err := spec.validate(s, decoded, "body"); err != nil {
+		t.Errorf("%s %s: %v", method, path, err)
+	}
+	return decoded
+}
+
+func TestOpenAPI_Routes(t *testing.T) {
+	spec := loadSpec(t)
+	app := newTestApp(t)
+
+	routes := map[string]bool{}
+	for _, route := range app.GetRoutes(true) {
+		if route.Method == fiber.MethodHead || !strings.HasPrefix(route.Path, "/api") {
+			continue
+		}
+		path := strings.TrimPrefix(route.Path, "/api")
+		if path == "" {
+			path = "/"
+		}
+		path = strings.ReplaceAll(path, ":id", "{id}")
+		routes[route.Method+" "+path] = true
+
+		if _, ok := spec.Paths[path][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("%s %s is served but not in the specification", route.Method, path)
+		}
+	}
+
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if method == "parameters" {
+				continue
+			}
+			if !routes[strings.ToUpper(method)+" "+path] {
+				t.Errorf("%s %s is in the specification but not served", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPI_Unauthorized(t *testing.T) {
+	app := newTestApp(t)
+
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/processes", nil))
+	if err != nil {
+		t.Fatalf("GET /api/processes: %v", err)
+	}
+	if res.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("status without token = %d", res.StatusCode)
+	}
+
+	res, err = app.Test(httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if err != nil {
+		t.Fatalf("GET /api/openapi.json: %v", err)
+	}
+	if res.StatusCode != fiber.StatusOK {
+		t.Errorf("status of the specification without token = %d", res.StatusCode)
+	}
+}
+
+func TestOpenAPI_Contract(t *testing.T) {
+	spec := loadSpec(t)
+	app := newTestApp(t)
+
+	call(t, app, spec, http.MethodGet, "/api/", "/", "", fiber.StatusNoContent)
+	call(t, app, spec, http.MethodGet, "/api/info", "/info", "", fiber.StatusOK)
+
+	call(t, app, spec, http.MethodPost, "/api/processes/start", "/processes/start", `{"exec": "sleep", "unknown": 1}`, fiber.StatusBadRequest)
+	call(t, app, spec, http.MethodPost, "/api/processes/start", "/processes/start", `{"exec": ""}`, fiber.StatusBadRequest)
+	started := call(t, app, spec, http.MethodPost, "/api/processes/start", "/processes/start", `{"name": "contract", "exec": "sleep", "args": ["60"]}`, fiber.StatusCreated)
+	id := started["result"].(map[string]any)["processId"].(string)
+	t.Cleanup(func() {
+		executor.DeleteProcess(id)
+	})
+
+	list := call(t, app, spec, http.MethodGet, "/api/processes", "/processes", "", fiber.StatusOK)
+	if processes := list["result"].(map[string]any)["processList"].([]any); len(processes) == 0 {
+		t.Errorf("process list is empty")
+	}
+
+	call(t, app, spec, http.MethodGet, "/api/processes/"+id, "/processes/{id}", "", fiber.StatusOK)
+	call(t, app, spec, http.MethodGet, "/api/processes/x", "/processes/{id}", "", fiber.StatusBadRequest)
+	call(t, app, spec, http.MethodGet, "/api/processes/99999", "/processes/{id}", "", fiber.StatusNotFound)
+	call(t, app, spec, http.MethodGet, "/api/processes/"+id+"/describe", "/processes/{id}/describe", "", fiber.StatusOK)
+	call(t, app, spec, http.MethodGet, "/api/processes/x/describe", "/processes/{id}/describe", "", fiber.StatusBadRequest)
+	call(t, app, spec, http.MethodGet, "/api/processes/99999/describe", "/processes/{id}/describe", "", fiber.StatusNotFound)
+
+	call(t, app, spec, http.MethodPost, "/api/processes/"+id+"/stdin", "/processes/{id}/stdin", "", fiber.StatusBadRequest)
+	call(t, app, spec, http.MethodPost, "/api/processes/"+id+"/stdin", "/processes/{id}/stdin", "hello\n", fiber.StatusOK)
+	call(t, app, spec, http.MethodPost, "/api/processes/99999/stdin", "/processes/{id}/stdin", "hello\n", fiber.StatusNotFound)
+
+	// File logging is not configured in the tests.
+	call(t, app, spec, http.MethodGet, "/api/processes/"+id+"/logs?limit=x", "/processes/{id}/logs", "", fiber.StatusBadRequest)
+	call(t, app, spec, http.MethodGet, "/api/processes/"+id+"/logs?after=x", "/processes/{id}/logs", "", fiber.StatusBadRequest)
+	call(t, app, spec, http.MethodGet, "/api/processes/"+id+"/logs", "/processes/{id}/logs", "", fiber.StatusConflict)
+	call(t, app, spec, http.MethodGet, "/api/processes/99999/logs", "/processes/{id}/logs", "", fiber.StatusNotFound)
+
+	call(t, app, spec, http.MethodGet, "/api/processes/99999/stdouterr", "/processes/{id}/stdouterr", "", fiber.StatusNotFound)
+	call(t, app, spec, http.MethodGet, "/api/processes/"+id+"/terminal", "/processes/{id}/terminal", "", fiber.StatusBadRequest)
+
+	call(t, app, spec, http.MethodPost, "/api/processes/"+id+"/restart", "/processes/{id}/restart", "", fiber.StatusOK)
+	call(t, app, spec, http.MethodPost, "/api/processes/99999/restart", "/processes/{id}/restart", "", fiber.StatusNotFound)
+
+	signal := "/processes/{id}/signal"
+	call(t, app, spec, http.MethodPost, "/api/processes/"+id+"/signal", signal, `{"signal": "SIGNOPE"}`, fiber.StatusBadRequest)
+	call(t, app, spec, http.MethodPost, "/api/processes/99999/signal", signal, `{"signal": "SIGCONT"}`, fiber.StatusNotFound)
+	signalled := call(t, app, spec, http.MethodPost, "/api/processes/"+id+"/signal", signal, `{"signal": "cont"}`, fiber.StatusOK)
+	if ids := signalled["result"].(map[string]any)["processIds"].([]any); len(ids) != 1 || ids[0] != id {
+		t.Errorf("signalled processes = %v", ids)
+	}
+
+	call(t, app, spec, http.MethodPost, "/api/processes/"+id+"/stop", "/processes/{id}/stop", "", fiber.StatusOK)
+	call(t, app, spec, http.MethodPost, "/api/processes/"+id+"/stop", "/processes/{id}/stop", "", fiber.StatusConflict)
+	call(t, app, spec, http.MethodPost, "/api/processes/99999/stop", "/processes/{id}/stop", "", fiber.StatusNotFound)
+	call(t, app, spec, http.MethodPost, "/api/processes/"+id+"/stdin", "/processes/{id}/stdin", "hello\n", fiber.StatusConflict)
+	call(t, app, spec, http.MethodPost, "/api/processes/"+id+"/signal", signal, `{"signal": "SIGCONT"}`, fiber.StatusConflict)
+	described := call(t, app, spec, http.MethodGet, "/api/processes/"+id+"/describe", "/processes/{id}/describe", "", fiber.StatusOK)
+	if detail := described["result"].(map[string]any)["processDetail"].(map[string]any); detail["health"] != "stopped" || len(detail["exits"].([]any)) == 0 {
+		t.Errorf("details after stop = %v", detail)
+	}
+
+	call(t, app, spec, http.MethodDelete, "/api/processes/"+id, "/processes/{id}", "", fiber.StatusOK)
+	call(t, app, spec, http.MethodDelete, "/api/processes/"+id, "/processes/{id}", "", fiber.StatusNotFound)
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerUID returns the user of the process on the other end of a socket
+// connection, ok is false if it can't be told.
+func peerUID(conn net.Conn) (uid int, ok bool) {
+	_, uid, ok = peerCred(conn)
+	return uid, ok
+}
+
+// peerCred returns the process and user on the other end of a socket
+// connection when it was connected, ok is false if they can't be told.
+func peerCred(conn net.Conn) (pid int, uid int, ok bool) {
+	unixConn, isUnix := conn.(*net.UnixConn)
+	if !isUnix {
+		return 0, 0, false
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, 0, false
+	}
+
+	var cred *syscall.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return 0, 0, false
+	}
+	return int(cred.Pid), int(cred.Uid), true
+}
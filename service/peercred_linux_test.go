@@ -0,0 +1,42 @@
+package service
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPeerUID(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "jpm.sock"))
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer conn.Close()
+
+	uid, ok := peerUID(conn)
+	if !ok || uid != os.Getuid() {
+		t.Errorf("peerUID = %d, %v, want %d", uid, ok, os.Getuid())
+	}
+	if pid, _, _ := peerCred(conn); pid != os.Getpid() {
+		t.Errorf("peerCred pid = %d, want %d", pid, os.Getpid())
+	}
+	if !sessionAllowed(uid) {
+		t.Errorf("session of the own user is not allowed")
+	}
+
+	if _, ok := peerUID(&net.TCPConn{}); ok {
+		t.Errorf("peerUID of a TCP connection is ok")
+	}
+}
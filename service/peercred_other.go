@@ -0,0 +1,17 @@
+//go:build !linux
+
+package service
+
+import "net"
+
+// peerUID can't tell the user on the other end of a connection on this
+// platform, only the permissions of the socket keep other users out.
+func peerUID(conn net.Conn) (uid int, ok bool) {
+	return 0, false
+}
+
+// peerCred can't tell the process on the other end of a connection on this
+// platform either.
+func peerCred(conn net.Conn) (pid int, uid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"errors"
+	"jstarpl/jpm/service/executor"
+	"jstarpl/jpm/service/logsink"
+	"jstarpl/jpm/service/notifier"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alecthomas/kong"
+)
+
+const httpShutdownTimeout = 5 * time.Second
+
+var notifications *notifier.Notifier
+var reloadMu sync.Mutex
+
+// handleReloadSignal reloads the configuration whenever the service receives
+// SIGHUP. There is no such signal on Windows, use `jpm service reload` there.
+func handleReloadSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	go (func() {
+		for range ch {
+			log.Default().Printf("Reload requested with SIGHUP")
+			if err := reloadService(); err != nil {
+				log.Default().Printf("Could not reload configuration: %v", err)
+			}
+		}
+	})()
+}
+
+// serviceStartArgs returns the arguments the service was started with,
+// without the executable and any --handover flag.
+func serviceStartArgs() []string {
+	var args []string
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		if arg == "--handover" {
+			i++
+			continue
+		}
+		if strings.HasPrefix(arg, "--handover=") {
+			continue
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// parseServiceArgs parses the arguments the service was started with again,
+// so that the configuration file and env vars are re-read with the same
+// precedence as on start.
+func parseServiceArgs() (*Service, error) {
+	args := serviceStartArgs()
+	idx := slices.Index(args, "service")
+	if idx < 0 {
+		return nil, errors.New("could not find service arguments in command line")
+	}
+
+	var fresh struct {
+		Globals `embed:""`
+		Service Service `cmd:""`
+	}
+	parser, err := kong.New(&fresh,
+		kong.Configuration(ConfigLoader, ConfigPath()),
+		KongVars(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := parser.Parse(args); err != nil {
+		return nil, err
+	}
+
+	return &fresh.Service, nil
+}
+
+// reloadService re-reads the configuration and applies it to the HTTP
+// listener, authorization, process logging, redaction, log sinks and notifications. Managed
+// processes keep running.
+func reloadService() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	fresh, err := parseServiceArgs()
+	if err != nil {
+		return err
+	}
+
+	opts := fresh.Start.Options
+	if err := opts.resolvePaths(); err != nil {
+		return err
+	}
+
+	notifyConfig, err := loadNotifications(effectiveConfigPath(fresh), &opts)
+	if err != nil {
+		return err
+	}
+
+	redactPatterns, err := opts.redactPatterns()
+	if err != nil {
+		return err
+	}
+
+	sinks, err := newLogSinks(effectiveConfigPath(fresh))
+	if err != nil {
+		return err
+	}
+
+	prev := currentOptions()
+
+	// A random token is only generated on start, keep the one clients already use.
+	if opts.Token == "<random>" {
+		opts.Token = prev.Token
+	}
+
+	if opts.Listen != prev.Listen {
+		if err := relistenHTTP(opts.Listen); err != nil {
+			sinks.Close()
+			return err
+		}
+	}
+
+	configMu.Lock()
+	config.ConfigFile = fresh.ConfigFile
+	config.Start.Options = opts
+	configMu.Unlock()
+
+	if opts.NoSystray != prev.NoSystray {
+		log.Default().Printf("Warning: changing no-systray requires a service restart")
+	}
+	if opts.LegacyIPC != prev.LegacyIPC {
+		log.Default().Printf("Warning: changing legacy-ipc requires a service restart")
+	}
+
+	if opts.logConfig() != prev.logConfig() {
+		executor.ApplyLogConfig(opts.logConfig())
+	}
+
+	executor.SetLineFraming(int(opts.MaxLineLength), opts.LineFlushTimeout)
+	executor.SetScrollback(opts.ScrollbackLines, int(opts.ScrollbackSize))
+	executor.SetRedaction(redactPatterns, opts.SecretEnv)
+	executor.SetDefaults(executor.RestartPolicy(opts.RestartPolicy), opts.StopTimeout)
+	notifications.SetConfig(notifyConfig)
+	if previous := executor.SetLogSinks(sinks); previous != nil {
+		go previous.Close()
+	}
+
+	log.Default().Printf("Configuration reloaded")
+
+	return nil
+}
+
+// newLogSinks creates the log sinks listed in the configuration file.
+func newLogSinks(configPath string) (*logsink.Set, error) {
+	configs, err := loadLogSinks(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	sinks, err := logsink.NewSet(configs)
+	if err != nil {
+		return nil, err
+	}
+	if sinks.Len() > 0 {
+		log.Default().Printf("Sending process output to %d log sinks", sinks.Len())
+	}
+	return sinks, nil
+}
+
+// relistenHTTP moves the HTTP server to a new address. The new address is
+// bound before the old server is shut down, so a bad address leaves the
+// service reachable where it was.
+func relistenHTTP(addr string) error {
+	ln, err := listenTCP(addr)
+	if err != nil {
+		return err
+	}
+
+	previous := httpApp
+	httpApp = newHTTPApp()
+	serveHTTP(httpApp, ln)
+
+	if previous != nil {
+		if err := previous.ShutdownWithTimeout(httpShutdownTimeout); err != nil {
+			httpLog.Printf("Error shutting down previous listener: %v", err)
+		}
+	}
+
+	return nil
+}
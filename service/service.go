@@ -2,25 +2,35 @@ package service
 
 import (
 	"bufio"
+	"context"
 	"embed"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"jstarpl/jpm/api"
 	"jstarpl/jpm/service/executor"
+	"jstarpl/jpm/service/logger"
+	"jstarpl/jpm/service/notifier"
 	"log"
 	"math/rand"
+	"net"
 	"os"
+	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"fyne.io/systray"
+	"github.com/alecthomas/kong"
+	"github.com/fasthttp/websocket"
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/recover"
 	"github.com/gofiber/fiber/v3/middleware/static"
 	"github.com/pkg/browser"
 	"github.com/valyala/fasthttp"
-
-	ipc "github.com/james-barrow/golang-ipc"
 )
 
 const (
@@ -48,18 +58,132 @@ func init() {
 
 const randomTokenLength = 32
 
+const homeDirPlaceholder = "<homeDir>"
+
+// jpmHomePlaceholder is the home of the instance, see api.Home.
+const jpmHomePlaceholder = "<jpmHome>"
+
+const ipcFlushDelay = 500 * time.Millisecond
+
+// Options are the service settings. Each can be set with a flag, an env var
+// or a key in the configuration file, in that order of precedence.
+type Options struct {
+	NoSystray        bool          `name:"no-systray" env:"JPM_NO_SYSTRAY" help:"Do not show an icon in systray" default:"false" yaml:"no_systray"`
+	LegacyIPC        bool          `name:"legacy-ipc" env:"JPM_LEGACY_IPC" help:"Also serve clients of earlier versions on the jpm-ipc channel, which does not check the user of a client." yaml:"legacy_ipc"`
+	Listen           string        `name:"listen" env:"JPM_LISTEN" help:"Address to listen for API connections." default:"${defaultListen}" yaml:"listen"`
+	Token            string        `name:"token" env:"JPM_TOKEN" help:"Bearer Token to use to authorize API requests." default:"<random>" yaml:"token"`
+	Logs             string        `name:"logs" env:"JPM_LOGS" help:"Path where the output from processes should be put." default:"<jpmHome>/logs" yaml:"logs"`
+	LogRetentionDays int           `name:"log-retention-days" env:"JPM_LOG_RETENTION_DAYS" help:"Number of days to keep process log files." default:"30" yaml:"log_retention_days"`
+	LogFormat        string        `name:"log-format" env:"JPM_LOG_FORMAT" help:"Format of process log files (${enum})." enum:"text,raw,json" default:"text" yaml:"log_format"`
+	LogMaxSize       ByteSize      `name:"log-max-size" env:"JPM_LOG_MAX_SIZE" help:"Size at which a process log file is rotated, e.g. 100M. 0 rotates daily only." default:"100M" yaml:"log_max_size"`
+	LogMaxTotalSize  ByteSize      `name:"log-max-total-size" env:"JPM_LOG_MAX_TOTAL_SIZE" help:"Maximum size of all log files of a process, e.g. 1G. 0 means no limit." default:"0" yaml:"log_max_total_size"`
+	LogFlushInterval time.Duration `name:"log-flush-interval" env:"JPM_LOG_FLUSH_INTERVAL" help:"Longest time process output stays buffered before it is written to the log files." default:"1s" yaml:"log_flush_interval"`
+	LogFsync         bool          `name:"log-fsync" env:"JPM_LOG_FSYNC" help:"Sync process log files to disk on every flush, safer on power loss but slower." yaml:"log_fsync"`
+	MaxLineLength    ByteSize      `name:"max-line-length" env:"JPM_MAX_LINE_LENGTH" help:"Longer lines of process output are cut into several lines." default:"64K" yaml:"max_line_length"`
+	ScrollbackLines  int           `name:"scrollback-lines" env:"JPM_SCROLLBACK_LINES" help:"Number of recent output lines kept per process and replayed to new viewers." default:"1000" yaml:"scrollback_lines"`
+	ScrollbackSize   ByteSize      `name:"scrollback-size" env:"JPM_SCROLLBACK_SIZE" help:"Maximum size of the output kept per process for new viewers." default:"1M" yaml:"scrollback_size"`
+	Redact           []string      `name:"redact" env:"JPM_REDACT" help:"Regular expression for output to mask before it is logged or shown, can be repeated. With capture groups only the groups are masked." sep:"none" yaml:"redact"`
+	SecretEnv        []string      `name:"secret-env" env:"JPM_SECRET_ENV" help:"Environment variables whose values are masked in process output, wildcards allowed." default:"*TOKEN*,*SECRET*,*PASSWORD*" yaml:"secret_env"`
+	LineFlushTimeout time.Duration `name:"line-flush-timeout" env:"JPM_LINE_FLUSH_TIMEOUT" help:"Time after which process output without a newline, like a prompt, is passed on." default:"100ms" yaml:"line_flush_timeout"`
+	RestartPolicy    string        `name:"restart-policy" env:"JPM_RESTART_POLICY" help:"Default restart policy for processes that exit on their own (${enum})." enum:"never,on-failure,always" default:"never" yaml:"restart_policy"`
+	StopTimeout      time.Duration `name:"stop-timeout" env:"JPM_STOP_TIMEOUT" help:"Time to wait for a process to exit before killing it." default:"3s" yaml:"stop_timeout"`
+	StateFile        string        `name:"state-file" env:"JPM_STATE_FILE" help:"File tracking running processes, used to re-adopt them after a crash. Empty disables it." default:"<jpmHome>/state.json" yaml:"state_file"`
+	Notifications    string        `name:"notifications" env:"JPM_NOTIFICATIONS" help:"Path to a YAML file configuring webhook and command notifications." type:"path" yaml:"-"`
+}
+
+// Globals are the flags of all commands, of the service and its clients.
+type Globals struct {
+	Instance string `name:"instance" env:"JPM_INSTANCE" help:"Service instance to run or talk to. Each has its own configuration, state, logs, socket and port under JPM_HOME." default:"default" yaml:"-"`
+	Socket   string `name:"socket" env:"JPM_SOCKET" help:"Path of the socket of the service (default: ${socketPath})." type:"path" yaml:"socket"`
+}
+
+// KongVars are the variables used in the help of the flags.
+func KongVars() kong.Vars {
+	return kong.Vars{
+		"configPath":    ConfigPath(),
+		"socketPath":    api.DefaultSocketPath(),
+		"defaultListen": api.DefaultListen(),
+	}
+}
+
 type Service struct {
+	ConfigFile kong.ConfigFlag `name:"config" help:"Path to the service configuration file (default: ${configPath})." type:"path"`
+
 	Start struct {
-		NoSystray        bool   `name:"no-systray" help:"Do not show an icon in systray" default:"false"`
-		Listen           string `name:"listen" help:"Address to listen for API connections." default:"127.0.0.1:3000"`
-		Token            string `name:"token" help:"Bearer Token to use to authorize API requests." default:"<random>"`
-		Logs             string `name:"logs" help:"Path where the output from processes should be put." default:"<homeDir>/.jpm/logs"`
-		LogRetentionDays int    `name:"log-retention-days" help:"Number of days to keep process log files." default:"30"`
+		Options  `embed:""`
+		Handover string `name:"handover" hidden:"" help:"Take over processes from the service listening on this socket."`
 	} `cmd:"" help:"Start the service."`
-	Stop struct{} `cmd:"" help:"Stop the service."`
+	Stop    struct{} `cmd:"" help:"Stop the service."`
+	Reload  struct{} `cmd:"" help:"Reload the service configuration without restarting processes."`
+	Upgrade struct {
+		Exec string `name:"exec" help:"Path to the new jpm binary. Defaults to the binary of the running service." type:"path"`
+	} `cmd:"" help:"Replace the running service with a new binary without stopping processes."`
+	Config struct {
+		Options `embed:""`
+	} `cmd:"" help:"Print the effective service configuration."`
 }
 
 var config *Service
+var configMu sync.RWMutex
+
+// currentOptions returns a snapshot of the options the service runs with.
+func currentOptions() Options {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return config.Start.Options
+}
+
+// resolvePaths replaces placeholder defaults that depend on the environment.
+func (o *Options) resolvePaths() error {
+	for _, path := range []*string{&o.Logs, &o.StateFile} {
+		if strings.HasPrefix(*path, jpmHomePlaceholder) {
+			*path = api.Home() + strings.TrimPrefix(*path, jpmHomePlaceholder)
+			continue
+		}
+		if !strings.HasPrefix(*path, homeDirPlaceholder) {
+			continue
+		}
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("error getting user home directory: %w", err)
+		}
+		*path = homeDir + strings.TrimPrefix(*path, homeDirPlaceholder)
+	}
+	return nil
+}
+
+// logConfig returns the settings for process log files.
+func (o *Options) logConfig() executor.LogConfig {
+	return executor.LogConfig{
+		Dir: o.Logs,
+		Options: logger.Options{
+			RetentionDays: o.LogRetentionDays,
+			Format:        logger.Format(o.LogFormat),
+			MaxFileSize:   int64(o.LogMaxSize),
+			MaxTotalSize:  int64(o.LogMaxTotalSize),
+			FlushInterval: o.LogFlushInterval,
+			Fsync:         o.LogFsync,
+			Instance:      api.Instance(),
+		},
+	}
+}
+
+// redactPatterns compiles the Redact expressions.
+func (o *Options) redactPatterns() ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, expr := range o.Redact {
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact expression: %w", err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	if err := executor.ValidateSecretEnv(o.SecretEnv); err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
 
 func StartService(cli *Service) {
 	if cli.Start.Token == "<random>" {
@@ -68,20 +192,56 @@ func StartService(cli *Service) {
 
 	config = cli
 
-	if cli.Start.Logs == "<homeDir>/.jpm/logs" {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			log.Fatalf("Error getting user home directory: %v", err)
+	if err := cli.Start.resolvePaths(); err != nil {
+		log.Fatalf("Error resolving config: %v", err)
+	}
+
+	executor.SetLogConfig(cli.Start.logConfig())
+	executor.SetLineFraming(int(cli.Start.MaxLineLength), cli.Start.LineFlushTimeout)
+	executor.SetScrollback(cli.Start.ScrollbackLines, int(cli.Start.ScrollbackSize))
+
+	redactPatterns, err := cli.Start.redactPatterns()
+	if err != nil {
+		log.Fatalf("Error in redaction settings: %v", err)
+	}
+	executor.SetRedaction(redactPatterns, cli.Start.SecretEnv)
+
+	executor.SetDefaults(executor.RestartPolicy(cli.Start.RestartPolicy), cli.Start.StopTimeout)
+
+	notifyConfig, err := loadNotifications(effectiveConfigPath(cli), &cli.Start.Options)
+	if err != nil {
+		log.Fatalf("Error loading notifications: %v", err)
+	}
+	notifications = notifier.New(notifyConfig)
+	go notifications.Run()
+
+	sinks, err := newLogSinks(effectiveConfigPath(cli))
+	if err != nil {
+		log.Fatalf("Error setting up log sinks: %v", err)
+	}
+	executor.SetLogSinks(sinks)
+
+	if cli.Start.Handover != "" {
+		if err := receiveHandover(cli.Start.Handover); err != nil {
+			log.Fatalf("Error taking over processes: %v", err)
+		}
+	} else if cli.Start.StateFile != "" {
+		if err := executor.RecoverState(cli.Start.StateFile); err != nil {
+			log.Default().Printf("Warning: could not recover processes: %v", err)
 		}
-		cli.Start.Logs = fmt.Sprintf("%s/.jpm/logs", homeDir)
 	}
 
-	if cli.Start.Logs != "" {
-		executor.SetLogConfig(cli.Start.Logs, cli.Start.LogRetentionDays)
+	if cli.Start.StateFile != "" {
+		go executor.PersistState(cli.Start.StateFile)
 	}
+	go executor.RunLogJanitor(executor.LogJanitorInterval)
+
+	handleReloadSignal()
 
 	if cli.Start.NoSystray {
 		run()
+		// The servers run in their own goroutines, keep the service alive.
+		select {}
 	} else {
 		systray.Run(onReady, onExit)
 	}
@@ -114,15 +274,55 @@ func onReady() {
 	go (func() {
 		for {
 			<-mOpen.ClickedCh
-			browser.OpenURL(fmt.Sprintf("http://%s/#token=%s", config.Start.Listen, config.Start.Token))
+			opts := currentOptions()
+			browser.OpenURL(fmt.Sprintf("http://%s/#token=%s", opts.Listen, opts.Token))
 		}
 	})()
 
 	run()
 }
 
+var httpApp *fiber.App
+var httpLog = log.New(log.Default().Writer(), "http: ", logProps)
+
 func startHTTPServer() {
-	logger := log.New(log.Default().Writer(), "http: ", logProps)
+	ln, err := listenTCP(currentOptions().Listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	httpApp = newHTTPApp()
+	serveHTTP(httpApp, ln)
+}
+
+func listenTCP(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil && addr == api.DefaultListen() && !api.IsDefaultInstance() {
+		// The port is derived from the name of the instance, another
+		// instance may have got the same one.
+		return nil, fmt.Errorf("could not listen on %s, the port of instance %q: %w. If another instance uses it, set listen in %s or pass --listen", addr, api.Instance(), err, ConfigPath())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %s: %w", addr, err)
+	}
+	return ln, nil
+}
+
+func serveHTTP(app *fiber.App, ln net.Listener) {
+	httpLog.Printf("JPM Console at http://%s", ln.Addr())
+
+	go (func() {
+		err := app.Listener(ln, fiber.ListenConfig{
+			DisableStartupMessage: true,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	})()
+}
+
+func newHTTPApp() *fiber.App {
+	logger := httpLog
 
 	app := fiber.New(fiber.Config{
 		ServerHeader: "JPM/0.1",
@@ -141,21 +341,28 @@ func startHTTPServer() {
 		return c.Next()
 	})
 
+	// The specification is public, like the web GUI.
+	app.Get("/api/openapi.json", func(c fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(openAPISpec)
+	})
+
 	apiRouter := app.Group("/api")
 
 	apiRouter.Use(func(c fiber.Ctx) error {
-		if config.Start.Token != "" {
+		token := currentOptions().Token
+		if token != "" {
 			headers := c.GetReqHeaders()
 			authOk := false
 
 			if len(headers[fiber.HeaderAuthorization]) > 0 {
 				auth := headers[fiber.HeaderAuthorization][0]
-				authOk = auth == fmt.Sprintf("Bearer %s", config.Start.Token)
+				authOk = auth == fmt.Sprintf("Bearer %s", token)
 			}
 
 			if !authOk {
 				queryToken := c.Query("token")
-				authOk = queryToken != "" && queryToken == config.Start.Token
+				authOk = queryToken != "" && queryToken == token
 			}
 
 			if !authOk {
@@ -170,109 +377,155 @@ func startHTTPServer() {
 		return c.SendStatus(fiber.StatusNoContent)
 	})
 
+	apiRouter.Get("/info", func(c fiber.Ctx) error {
+		info := api.LocalServiceInfo()
+		return sendResult(c, fiber.StatusOK, &api.ResponseResult{ServiceInfo: &info})
+	})
+
 	apiRouter.Get("/processes", func(c fiber.Ctx) error {
 		list := executor.ListProcesses()
-		res := api.Response{Header: "2.0", Result: &api.ResponseResult{ProcessList: list}, MsgID: 0}
-
-		c.Set(fiber.HeaderCacheControl, "no-cache")
-		c.Status(fiber.StatusOK)
-
-		return c.JSON(res)
+		return sendResult(c, fiber.StatusOK, &api.ResponseResult{ProcessList: list})
 	})
 
 	apiRouter.Post("/processes/start", func(c fiber.Ctx) error {
-		return c.SendStatus(fiber.StatusNotImplemented)
+		var params api.RequestStartProcessParams
+		if err := decodeBody(c, &params); err != nil {
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
+
+		proc, err := executor.StartProcess(params.Name, params.Namespace, params.Exec, params.Arg, params.Dir, params.Env, params.LogOptions)
+		if err != nil {
+			return sendError(c, errorStatus(err), fmt.Sprintf("Could not start process: %v", err))
+		}
+
+		return sendResult(c, fiber.StatusCreated, &api.ResponseResult{Success: stringPtr("Process started"), ProcessId: &proc.Id})
 	})
 
 	apiRouter.Get("/processes/:id", func(c fiber.Ctx) error {
-		list := executor.ListProcesses()
+		id, err := processIdParam(c)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
 
-		c.Set(fiber.HeaderCacheControl, "no-cache")
+		for _, process := range *executor.ListProcesses() {
+			if process.Id == id {
+				return sendResult(c, fiber.StatusOK, &api.ResponseResult{Process: &process})
+			}
+		}
 
-		for _, process := range *list {
-			if process.Id == c.Params("id") {
-				res := api.Response{Header: "2.0", Result: &api.ResponseResult{Process: &process}, MsgID: 0}
-				c.Status(fiber.StatusOK)
+		return sendError(c, fiber.StatusNotFound, "Process not found")
+	})
 
-				return c.JSON(res)
-			}
+	apiRouter.Get("/processes/:id/describe", func(c fiber.Ctx) error {
+		id, err := processIdParam(c)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err.Error())
 		}
 
-		c.Status(fiber.StatusNotFound)
+		detail, err := executor.DescribeProcess(id)
+		if err != nil {
+			return sendError(c, errorStatus(err), fmt.Sprintf("Could not describe process: %v", err))
+		}
 
-		res, _ := api.NewErrorResponse(0, 404, "Process not found")
-		return c.Send(res)
+		return sendResult(c, fiber.StatusOK, &api.ResponseResult{ProcessDetail: &detail})
 	})
 
 	apiRouter.Post("/processes/:id/stop", func(c fiber.Ctx) error {
-		err := executor.StopProcess(c.Params("id"))
+		id, err := processIdParam(c)
 		if err != nil {
-			res, _ := api.NewErrorResponse(0, 404, fmt.Sprintf("Could not stop process: %v", err))
-			c.Status(fiber.StatusNotFound)
-			return c.Send(res)
+			return sendError(c, fiber.StatusBadRequest, err.Error())
 		}
 
-		res, _ := api.NewSuccessResponse(0, &api.ResponseResult{Success: stringPtr("Process stopped")})
-		c.Status(fiber.StatusOK)
-		return c.Send(res)
+		if err := executor.StopProcess(id); err != nil {
+			return sendError(c, errorStatus(err), fmt.Sprintf("Could not stop process: %v", err))
+		}
+
+		return sendResult(c, fiber.StatusOK, &api.ResponseResult{Success: stringPtr("Process stopped")})
 	})
 
 	apiRouter.Post("/processes/:id/restart", func(c fiber.Ctx) error {
-		err := executor.RestartProcess(c.Params("id"))
+		id, err := processIdParam(c)
 		if err != nil {
-			res, _ := api.NewErrorResponse(0, 404, fmt.Sprintf("Could not restart process: %v", err))
-			c.Status(fiber.StatusNotFound)
-			return c.Send(res)
+			return sendError(c, fiber.StatusBadRequest, err.Error())
 		}
 
-		res, _ := api.NewSuccessResponse(0, &api.ResponseResult{Success: stringPtr("Process restarted")})
-		c.Status(fiber.StatusOK)
-		return c.Send(res)
+		if err := executor.RestartProcess(id); err != nil {
+			return sendError(c, errorStatus(err), fmt.Sprintf("Could not restart process: %v", err))
+		}
+
+		return sendResult(c, fiber.StatusOK, &api.ResponseResult{Success: stringPtr("Process restarted")})
 	})
 
-	apiRouter.Delete("/processes/:id", func(c fiber.Ctx) error {
-		err := executor.DeleteProcess(c.Params("id"))
+	apiRouter.Post("/processes/:id/signal", func(c fiber.Ctx) error {
+		id, err := processIdParam(c)
 		if err != nil {
-			res, _ := api.NewErrorResponse(0, 404, fmt.Sprintf("Could not delete process: %v", err))
-			c.Status(fiber.StatusNotFound)
-			return c.Send(res)
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
+		var body struct {
+			Signal string `json:"signal"`
+			Group  bool   `json:"group"`
+		}
+		if err := decodeBody(c, &body); err != nil {
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
+
+		result, err := signalProcesses(api.RequestSignalProcessParams{Id: id, Signal: body.Signal, Group: body.Group})
+		if err != nil {
+			return sendError(c, errorStatus(err), fmt.Sprintf("Could not signal process: %v", err))
 		}
 
-		res, _ := api.NewSuccessResponse(0, &api.ResponseResult{Success: stringPtr("Process deleted")})
-		c.Status(fiber.StatusOK)
-		return c.Send(res)
+		return sendResult(c, fiber.StatusOK, result)
 	})
 
-	apiRouter.Patch("/processes/:id", func(c fiber.Ctx) error {
-		return c.SendStatus(fiber.StatusNotImplemented)
+	apiRouter.Delete("/processes/:id", func(c fiber.Ctx) error {
+		id, err := processIdParam(c)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
+
+		if err := executor.DeleteProcess(id); err != nil {
+			return sendError(c, errorStatus(err), fmt.Sprintf("Could not delete process: %v", err))
+		}
+
+		return sendResult(c, fiber.StatusOK, &api.ResponseResult{Success: stringPtr("Process deleted")})
 	})
 
 	apiRouter.Get("/processes/:id/stdouterr", func(c fiber.Ctx) error {
-		stdOutErrBroadcast, err := executor.GetProcessStdStreamRelay(c.Params("id"))
+		id, err := processIdParam(c)
 		if err != nil {
-			c.Set(fiber.HeaderContentType, "application/json")
-			c.Set(fiber.HeaderCacheControl, "no-cache")
-			c.Status(fiber.StatusNotFound)
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
+
+		since, err := lastEventIdParam(c)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
 
-			res, _ := api.NewErrorResponse(0, 404, "Process not found")
-			return c.Send(res)
+		replay, l, err := executor.SubscribeOutput(id, since)
+		if err != nil {
+			return sendError(c, errorStatus(err), err.Error())
 		}
 
 		c.Set(fiber.HeaderContentType, "text/event-stream")
 		c.Set(fiber.HeaderCacheControl, "no-cache")
 		c.Set(fiber.HeaderConnection, "keep-alive")
 
-		l := stdOutErrBroadcast.Listener(1)
-
 		c.Status(fiber.StatusOK).SendStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+			defer l.Close()
+
+			for _, n := range replay {
+				writeOutputEvent(w, n)
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+
 			for n := range l.Ch() {
-				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", n.StreamType, n.Data)
+				writeOutputEvent(w, n)
 
 				err := w.Flush()
 				if err != nil {
 					logger.Printf("Error while flushing: %v. Closing http connection.", err)
-
-					l.Close()
 					break
 				}
 			}
@@ -281,167 +534,114 @@ func startHTTPServer() {
 		return nil
 	})
 
-	apiRouter.Post("/processes/:id/stdin", func(c fiber.Ctx) error {
-		stdInBroadcast, err := executor.GetProcessStdStreamRelay(c.Params("id"))
+	apiRouter.Get("/processes/:id/terminal", func(c fiber.Ctx) error {
+		id, err := processIdParam(c)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
+		since, err := lastEventIdParam(c)
 		if err != nil {
-			c.Set(fiber.HeaderContentType, "application/json")
-			c.Set(fiber.HeaderCacheControl, "no-cache")
-			c.Status(fiber.StatusNotFound)
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
+		if !websocket.FastHTTPIsWebSocketUpgrade(c.RequestCtx()) {
+			return sendError(c, fiber.StatusBadRequest, "Expected a WebSocket upgrade")
+		}
 
-			res, _ := api.NewErrorResponse(0, 404, "Process not found")
-			return c.Send(res)
+		replay, l, err := executor.SubscribeOutput(id, since)
+		if err != nil {
+			return sendError(c, errorStatus(err), err.Error())
 		}
 
-		stdInBroadcast.Broadcast(api.StdStreamMessage{
-			Data: c.BodyRaw(),
+		err = terminalUpgrader.Upgrade(c.RequestCtx(), func(conn *websocket.Conn) {
+			serveTerminal(conn, id, since, replay, l)
 		})
-
-		c.Status(fiber.StatusOK)
-		res, _ := api.NewSuccessResponse(0, &api.ResponseResult{Success: stringPtr("Sent")})
-		return c.Send(res)
+		if err != nil {
+			// The upgrader already answered the request.
+			l.Close()
+		}
+		return nil
 	})
 
-	app.Use("/", static.New("", static.Config{
-		Browse: true,
-		FS:     *webgui,
-	}))
+	apiRouter.Get("/processes/:id/logs", func(c fiber.Ctx) error {
+		id, err := processIdParam(c)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
 
-	logger.Printf("JPM Console at http://%s", config.Start.Listen)
+		params := api.RequestQueryLogsParams{
+			Id:     id,
+			Since:  c.Query("since"),
+			Until:  c.Query("until"),
+			Grep:   c.Query("grep"),
+			Stream: api.StreamType(c.Query("stream")),
+			After:  c.Query("after"),
+		}
+		if limit := c.Query("limit"); limit != "" {
+			if params.Limit, err = strconv.Atoi(limit); err != nil {
+				return sendError(c, fiber.StatusBadRequest, fmt.Sprintf("Invalid query: limit: %q is not a number", limit))
+			}
+		}
+		query, err := logQuery(params)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Sprintf("Invalid query: %v", err))
+		}
 
-	go (func() {
-		log.Fatal(app.Listen(config.Start.Listen, fiber.ListenConfig{
-			DisableStartupMessage: true,
-		}))
-	})()
-}
+		result, err := queryLogsResult(params, query)
+		if err != nil {
+			return sendError(c, errorStatus(err), fmt.Sprintf("Could not query logs: %v", err))
+		}
 
-func startIPCServer() {
-	server, err := ipc.StartServer(api.IPCName, nil)
-	if err != nil {
-		panic("Could not open `jpm-ipc` IPC channel. Check if the service isn't already running.")
-	}
+		return sendResult(c, fiber.StatusOK, result)
+	})
 
-	logger := log.New(log.Default().Writer(), "ipc: ", logProps)
+	apiRouter.Post("/processes/:id/stdin", func(c fiber.Ctx) error {
+		id, err := processIdParam(c)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err.Error())
+		}
+		if len(c.BodyRaw()) == 0 {
+			return sendError(c, fiber.StatusBadRequest, "The request body is the input and must not be empty")
+		}
 
-	go (func() {
-		for {
-			data, err := server.Read()
-			if err != nil {
-				logger.Fatalf("Error reading from IPC: %v", err)
-			}
+		ctx, cancel := context.WithTimeout(context.Background(), stdinTimeout)
+		defer cancel()
+		if err := executor.WriteInput(ctx, id, c.BodyRaw()); errors.Is(err, context.DeadlineExceeded) {
+			return sendError(c, fiber.StatusConflict, "The process does not read its input")
+		} else if err != nil {
+			return sendError(c, errorStatus(err), err.Error())
+		}
 
-			// logger.Printf("Message received: %d %v Length %d %v", data.MsgType, data.Status, len(data.Data), string(data.Data[:]))
+		return sendResult(c, fiber.StatusOK, &api.ResponseResult{Success: stringPtr("Sent")})
+	})
 
-			if data.MsgType > 0 {
-				var e api.Request
-				err = json.Unmarshal(data.Data, &e)
+	app.Use("/", static.New("", static.Config{
+		Browse: true,
+		FS:     *webgui,
+	}))
 
-				if err != nil {
-					logger.Printf("Unknown message received: %v", data.Data)
-					errorMsg, _ := api.NewErrorResponse(e.MsgID, int(api.ParseError), "Parse error")
-					server.Write(api.MsgType, errorMsg)
-					continue
-				}
+	return app
+}
 
-				logger.Printf("Method requested %s", e.Method)
-				switch e.Method {
-				case api.ListProcesses:
-					list := executor.ListProcesses()
-					res, _ := api.NewSuccessResponse(e.MsgID, &api.ResponseResult{
-						ProcessList: list,
-					})
-					server.Write(api.MsgType, res)
-				case api.StartProcess:
-					var params api.RequestStartProcessParams
-					json.Unmarshal(e.Params, &params)
-					proc, err := executor.StartProcess(params.Name, params.Namespace, params.Exec, params.Arg, params.Dir, params.Env)
-					if err != nil {
-						res, _ := api.NewErrorResponse(e.MsgID, 501, fmt.Sprintf("Could not start process: %v", err))
-						server.Write(api.MsgType, res)
-						continue
-					}
-
-					res, _ := api.NewSuccessResponse(e.MsgID, &api.ResponseResult{Success: stringPtr("Process started"), ProcessId: &proc.Id})
-					server.Write(api.MsgType, res)
-				case api.StopProcess:
-					var params api.RequestStopProcessParams
-					json.Unmarshal(e.Params, &params)
-					err := executor.StopProcess(params.Id)
-					if err != nil {
-						res, _ := api.NewErrorResponse(e.MsgID, 501, fmt.Sprintf("Could not stop process: %v", err))
-						server.Write(api.MsgType, res)
-						continue
-					}
-
-					res, _ := api.NewSuccessResponse(e.MsgID, &api.ResponseResult{Success: stringPtr("Process stopped")})
-					server.Write(api.MsgType, res)
-				case api.RestartProcess:
-					var params api.RequestRestartProcessParams
-					json.Unmarshal(e.Params, &params)
-					err := executor.RestartProcess(params.Id)
-					if err != nil {
-						res, _ := api.NewErrorResponse(e.MsgID, 501, fmt.Sprintf("Could not restart process: %v", err))
-						server.Write(api.MsgType, res)
-						continue
-					}
-
-					res, _ := api.NewSuccessResponse(e.MsgID, &api.ResponseResult{Success: stringPtr("Process restarted")})
-					server.Write(api.MsgType, res)
-				case api.DeleteProcess:
-					var params api.RequestDeleteProcessParams
-					json.Unmarshal(e.Params, &params)
-					err := executor.DeleteProcess(params.Id)
-					if err != nil {
-						res, _ := api.NewErrorResponse(e.MsgID, 501, fmt.Sprintf("Could not delete process: %v", err))
-						server.Write(api.MsgType, res)
-						continue
-					}
-
-					res, _ := api.NewSuccessResponse(e.MsgID, &api.ResponseResult{Success: stringPtr("Process deleted")})
-					server.Write(api.MsgType, res)
-				case api.RequestStopService:
-					res, _ := api.NewSuccessResponse(e.MsgID, &api.ResponseResult{Success: stringPtr("Shutting down")})
-					server.Write(api.MsgType, res)
-
-					log.Default().Fatalf("Shutdown requested over IPC")
-				case api.SaveProcessList:
-					entries := saveProcessList()
-					res, _ := api.NewSuccessResponse(e.MsgID, &api.ResponseResult{SaveEntries: &entries})
-					server.Write(api.MsgType, res)
-				case api.RestoreProcessList:
-					var params api.RequestRestoreProcessListParams
-					if err := json.Unmarshal(e.Params, &params); err != nil {
-						res, _ := api.NewErrorResponse(e.MsgID, int(api.InvalidParams), fmt.Sprintf("Invalid params: %v", err))
-						server.Write(api.MsgType, res)
-						continue
-					}
-					if len(params.Entries) == 0 {
-						res, _ := api.NewErrorResponse(e.MsgID, int(api.InvalidParams), "entries must not be empty")
-						server.Write(api.MsgType, res)
-						continue
-					}
-					restoreProcessList(params.Entries)
-
-					res, _ := api.NewSuccessResponse(e.MsgID, &api.ResponseResult{Success: stringPtr("Process list restored")})
-					server.Write(api.MsgType, res)
-				default:
-					errorMsg, _ := api.NewErrorResponse(e.MsgID, int(api.MethodNotFound), "Method not found")
-					server.Write(api.MsgType, errorMsg)
-				}
-			}
-		}
-	})()
+// writeOutputEvent writes process output as a server-sent event, with its
+// sequence number as the event id for resuming.
+func writeOutputEvent(w io.Writer, msg api.StdStreamMessage) {
+	if msg.Seq != 0 {
+		fmt.Fprintf(w, "id: %d\n", msg.Seq)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.StreamType, msg.Data)
 }
 
 func onExit() {
-	// clean up here
+	executor.FlushLogs()
 }
 
+// saveProcessList returns the processes to save. Unlike the ones of the API,
+// their environments are not masked, so that they can be restored.
 func saveProcessList() []api.SaveEntry {
-	list := executor.ListProcesses()
+	states := executor.Snapshot()
 
-	entries := make([]api.SaveEntry, len(*list))
-	for i, proc := range *list {
+	entries := make([]api.SaveEntry, len(states))
+	for i, proc := range states {
 		entries[i] = api.SaveEntry{
 			Name:      proc.Name,
 			Namespace: proc.Namespace,
@@ -450,6 +650,8 @@ func saveProcessList() []api.SaveEntry {
 			Env:       proc.Env,
 			Dir:       proc.Dir,
 			Status:    proc.Status.String(),
+
+			LogOptions: proc.Log,
 		}
 	}
 
@@ -482,7 +684,7 @@ func restoreProcessList(entries []api.SaveEntry) {
 			continue
 		}
 
-		_, startErr := executor.StartProcess(entry.Name, entry.Namespace, entry.Exec, entry.Args, entry.Dir, entry.Env)
+		_, startErr := executor.StartProcess(entry.Name, entry.Namespace, entry.Exec, entry.Args, entry.Dir, entry.Env, entry.LogOptions)
 		if startErr != nil {
 			log.Default().Printf(
 				"Warning: could not restore process name=%q exec=%q dir=%q: %v",
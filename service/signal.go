@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+	"strings"
+)
+
+// signalProcesses sends the signal of params to the process with its id, or
+// to the running processes its query selects. When only some of them could
+// be signalled, the result lists those along with the error.
+func signalProcesses(params api.RequestSignalProcessParams) (*api.ResponseResult, error) {
+	sig, err := executor.ParseSignal(params.Signal)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	switch {
+	case params.Id != "":
+		ids = []string{params.Id}
+	case params.Query != "":
+		if ids = executor.SelectProcesses(params.Query); len(ids) == 0 {
+			return nil, fmt.Errorf("%w: %q selects no process", executor.ErrProcessNotFound, params.Query)
+		}
+	default:
+		return nil, fmt.Errorf("%w: id or query is missing", executor.ErrInvalidOptions)
+	}
+
+	var signalled []string
+	var errs []error
+	for _, id := range ids {
+		err := executor.SignalProcess(id, sig, params.Group)
+		switch {
+		case err == nil:
+			signalled = append(signalled, id)
+		case params.Id != "":
+			return nil, err
+		case errors.Is(err, executor.ErrNotRunning):
+		default:
+			errs = append(errs, fmt.Errorf("process %s: %w", id, err))
+		}
+	}
+	if len(errs) > 0 && len(signalled) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(signalled) == 0 {
+		return nil, fmt.Errorf("%w: no process %q selects is running", executor.ErrNotRunning, params.Query)
+	}
+
+	noun := "process"
+	if len(signalled) != 1 {
+		noun = "processes"
+	}
+	success := fmt.Sprintf("Sent %s to %s %s", executor.SignalName(sig), noun, strings.Join(signalled, ", "))
+	result := &api.ResponseResult{Success: &success, ProcessIds: &signalled}
+	if len(errs) > 0 {
+		return result, fmt.Errorf("%s, but not to all: %w", success, errors.Join(errs...))
+	}
+	return result, nil
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+
+package service
+
+import (
+	"encoding/json"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+	"os/exec"
+	"testing"
+)
+
+func TestSignalProcesses_PartialResult(t *testing.T) {
+	proc, err := executor.StartProcess("partial", "", "sleep", []string{"60"}, "", nil, api.LogOptions{})
+	if err != nil {
+		t.Fatalf("StartProcess: %v", err)
+	}
+	t.Cleanup(func() { executor.DeleteProcess(proc.Id) })
+
+	// Not started by the service, so it does not lead a process group.
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	adopted := executor.Adopt(executor.ProcessState{Id: "900", Name: "partial", Exec: "sleep", Status: api.Running, Pid: cmd.Process.Pid}, nil, nil, nil)
+	t.Cleanup(func() { executor.DeleteProcess(adopted.Id) })
+
+	params, _ := json.Marshal(api.RequestSignalProcessParams{Query: "partial", Signal: "SIGCONT", Group: true})
+	res := handleRequest(api.Request{Method: api.SignalProcess, Params: params})
+	if res.Error == nil || res.Error.Data == nil {
+		t.Fatalf("response = %+v, want an error with the signalled processes", res)
+	}
+	var result api.ResponseResult
+	if err := json.Unmarshal(*res.Error.Data, &result); err != nil {
+		t.Fatalf("Unmarshal data: %v", err)
+	}
+	if result.ProcessIds == nil || len(*result.ProcessIds) != 1 || (*result.ProcessIds)[0] != proc.Id {
+		t.Errorf("signalled = %v, want only %s", result.ProcessIds, proc.Id)
+	}
+}
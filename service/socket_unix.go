@@ -0,0 +1,29 @@
+//go:build !windows
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkSocketDir creates the directory of the socket, only accessible by the
+// user, or makes sure another user can't replace the socket in it.
+func checkSocketDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() && stat.Uid != 0 {
+		return fmt.Errorf("%s is owned by another user", dir)
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+package service
+
+import "os"
+
+func checkSocketDir(dir string) error {
+	return os.MkdirAll(dir, 0700)
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/teivah/broadcast"
+)
+
+const (
+	// terminalWriteWait is how long writing a message to a client may take.
+	terminalWriteWait = 10 * time.Second
+	// terminalPongWait is how long a client may take to answer a ping.
+	terminalPongWait   = 60 * time.Second
+	terminalPingPeriod = terminalPongWait * 9 / 10
+	// terminalInputQueue is how many input messages wait for the process to
+	// read them before more input is refused.
+	terminalInputQueue = 64
+)
+
+// terminalUpgrader only accepts browsers on the origin of the console.
+// Other clients, like jpm attach, send no origin.
+var terminalUpgrader = websocket.FastHTTPUpgrader{}
+
+// terminalSession is a WebSocket connection to the terminal of a process.
+// A connection takes one reader and one writer at a time: only the read loop
+// reads from it, and only the write loop writes to it, others pass their
+// messages through replies.
+type terminalSession struct {
+	id      string
+	conn    *websocket.Conn
+	replies chan api.TerminalMessage
+	input   chan []byte
+}
+
+// serveTerminal runs a terminal session until the client disconnects or the
+// process is deleted. replay is sent first, then the output of listener.
+func serveTerminal(conn *websocket.Conn, id string, since uint64, replay []api.StdStreamMessage, output *broadcast.Listener[api.StdStreamMessage]) {
+	defer conn.Close()
+	defer output.Close()
+
+	events := executor.SubscribeEvents()
+	defer events.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &terminalSession{
+		id:      id,
+		conn:    conn,
+		replies: make(chan api.TerminalMessage, 16),
+		input:   make(chan []byte, terminalInputQueue),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.writeLoop(ctx, since, replay, output, events)
+		cancel()
+		// Give the client time to answer the close message. The deadline is
+		// set on the network connection, the read loop owns the one of the
+		// WebSocket connection.
+		conn.NetConn().SetReadDeadline(time.Now().Add(terminalWriteWait))
+	}()
+	go s.inputLoop(ctx)
+
+	s.readLoop(ctx)
+	cancel()
+	<-done
+}
+
+func (s *terminalSession) write(msg api.TerminalMessage) error {
+	s.conn.SetWriteDeadline(time.Now().Add(terminalWriteWait))
+	return s.conn.WriteJSON(msg)
+}
+
+func (s *terminalSession) close(code int, reason string) {
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(terminalWriteWait))
+}
+
+// reply queues a message for the write loop.
+func (s *terminalSession) reply(ctx context.Context, msg api.TerminalMessage) {
+	select {
+	case s.replies <- msg:
+	case <-ctx.Done():
+	}
+}
+
+// writeLoop sends output, status changes, replies and pings. Output the
+// client did not keep up with is skipped, the next output tells how much.
+func (s *terminalSession) writeLoop(ctx context.Context, since uint64, replay []api.StdStreamMessage, output *broadcast.Listener[api.StdStreamMessage], events *broadcast.Listener[executor.Event]) {
+	ping := time.NewTicker(terminalPingPeriod)
+	defer ping.Stop()
+
+	lastSeq := since
+	writeOutput := func(msg api.StdStreamMessage) error {
+		frame := api.TerminalMessage{Type: api.TerminalMessageType(msg.StreamType), Data: string(msg.Data), Seq: msg.Seq}
+		if msg.Seq != 0 {
+			if lastSeq != 0 && msg.Seq > lastSeq+1 {
+				frame.Dropped = msg.Seq - lastSeq - 1
+			}
+			lastSeq = msg.Seq
+		}
+		return s.write(frame)
+	}
+
+	for _, msg := range replay {
+		if err := writeOutput(msg); err != nil {
+			return
+		}
+	}
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-output.Ch():
+			if !ok {
+				s.close(websocket.CloseNormalClosure, "Process deleted")
+				return
+			}
+			err = writeOutput(msg)
+		case e := <-events.Ch():
+			switch {
+			case e.ProcessStatusChangeEvent != nil && e.ProcessStatusChangeEvent.Process.Id == s.id:
+				proc := e.ProcessStatusChangeEvent.Process
+				err = s.write(api.TerminalMessage{Type: api.TerminalStatus, Status: proc.Status.String()})
+			case e.ProcessExitedEvent != nil && e.ProcessExitedEvent.Process.Id == s.id:
+				proc := e.ProcessExitedEvent.Process
+				err = s.write(api.TerminalMessage{Type: api.TerminalStatus, Status: proc.Status.String(), ExitCode: &proc.ExitCode})
+			case e.ProcessDeletedEvent != nil && e.ProcessDeletedEvent.DeletedProcessId == s.id:
+				s.close(websocket.CloseNormalClosure, "Process deleted")
+				return
+			}
+		case msg := <-s.replies:
+			err = s.write(msg)
+		case <-ping.C:
+			err = s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(terminalWriteWait))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readLoop handles the messages of the client until it disconnects.
+func (s *terminalSession) readLoop(ctx context.Context) {
+	s.conn.SetReadLimit(api.MaxMessageSize)
+	s.conn.SetReadDeadline(time.Now().Add(terminalPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(terminalPongWait))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && ctx.Err() == nil {
+				httpLog.Printf("Terminal of process %s closed: %v", s.id, err)
+			}
+			return
+		}
+
+		var msg api.TerminalMessage
+		if err = json.Unmarshal(data, &msg); err != nil {
+			err = fmt.Errorf("Invalid message: %v", err)
+		} else {
+			err = s.handle(ctx, msg)
+		}
+		if err != nil {
+			s.reply(ctx, api.TerminalMessage{Type: api.TerminalError, Message: err.Error()})
+		}
+	}
+}
+
+func (s *terminalSession) handle(ctx context.Context, msg api.TerminalMessage) error {
+	switch msg.Type {
+	case api.TerminalStdin:
+		if msg.Data == "" {
+			return nil
+		}
+		select {
+		case s.input <- []byte(msg.Data):
+		default:
+			return errors.New("The process does not keep up with the input, it was dropped")
+		}
+	case api.TerminalResize:
+		if msg.Cols <= 0 || msg.Rows <= 0 {
+			return fmt.Errorf("Invalid terminal size %dx%d", msg.Cols, msg.Rows)
+		}
+		// Processes do not run on a terminal of their own, so there is
+		// nothing to resize yet.
+	case api.TerminalSignal:
+		sig, err := executor.ParseSignal(msg.Signal)
+		if err != nil {
+			return err
+		}
+		if err := executor.SignalProcess(s.id, sig, false); err != nil {
+			return fmt.Errorf("Could not send %s: %w", msg.Signal, err)
+		}
+	case api.TerminalRestart:
+		if err := executor.RestartProcess(s.id); err != nil {
+			return fmt.Errorf("Could not restart process: %w", err)
+		}
+	default:
+		return fmt.Errorf("Unknown message type %q", msg.Type)
+	}
+	return nil
+}
+
+// inputLoop passes input to the process in order, waiting for the process
+// to read it.
+func (s *terminalSession) inputLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-s.input:
+			if err := executor.WriteInput(ctx, s.id, data); err != nil && ctx.Err() == nil {
+				s.reply(ctx, api.TerminalMessage{Type: api.TerminalError, Message: fmt.Sprintf("Could not send input: %v", err)})
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+	"jstarpl/jpm/api"
+	"jstarpl/jpm/service/executor"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v3"
+)
+
+// dialTerminal serves the app and opens the terminal of a process.
+func dialTerminal(t *testing.T, id, query string) *websocket.Conn {
+	t.Helper()
+
+	app := newTestApp(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go app.Listener(ln, fiber.ListenConfig{DisableStartupMessage: true})
+	t.Cleanup(func() { app.Shutdown() })
+
+	header := http.Header{}
+	header.Set(fiber.HeaderAuthorization, "Bearer "+testToken)
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s"+api.TerminalPath+query, ln.Addr(), id), header)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntil reads messages until one of the type, failing after a timeout.
+func readUntil(t *testing.T, conn *websocket.Conn, typ api.TerminalMessageType) api.TerminalMessage {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg api.TerminalMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for %s: %v", typ, err)
+		}
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+func TestTerminal_Session(t *testing.T) {
+	proc, err := executor.StartProcess("terminal", "", "cat", nil, "", nil, api.LogOptions{})
+	if err != nil {
+		t.Fatalf("StartProcess: %v", err)
+	}
+	t.Cleanup(func() { executor.DeleteProcess(proc.Id) })
+
+	conn := dialTerminal(t, proc.Id, "")
+
+	conn.WriteJSON(api.TerminalMessage{Type: api.TerminalStdin, Data: "hello\n"})
+	if msg := readUntil(t, conn, api.TerminalStdout); msg.Data != "hello\n" || msg.Seq == 0 {
+		t.Errorf("output = %+v", msg)
+	}
+
+	conn.WriteJSON(api.TerminalMessage{Type: api.TerminalResize, Cols: 0, Rows: 24})
+	if msg := readUntil(t, conn, api.TerminalError); msg.Message == "" {
+		t.Errorf("invalid resize answered with %+v", msg)
+	}
+	conn.WriteJSON(api.TerminalMessage{Type: "unknown"})
+	readUntil(t, conn, api.TerminalError)
+	conn.WriteJSON(api.TerminalMessage{Type: api.TerminalSignal, Signal: "NOSUCH"})
+	readUntil(t, conn, api.TerminalError)
+
+	conn.WriteJSON(api.TerminalMessage{Type: api.TerminalSignal, Signal: "SIGTERM"})
+	for {
+		if msg := readUntil(t, conn, api.TerminalStatus); msg.ExitCode != nil {
+			break
+		}
+	}
+}
+
+func TestTerminal_Resume(t *testing.T) {
+	proc, err := executor.StartProcess("terminal", "", "cat", nil, "", nil, api.LogOptions{})
+	if err != nil {
+		t.Fatalf("StartProcess: %v", err)
+	}
+	t.Cleanup(func() { executor.DeleteProcess(proc.Id) })
+
+	conn := dialTerminal(t, proc.Id, "")
+	for _, line := range []string{"one\n", "two\n"} {
+		conn.WriteJSON(api.TerminalMessage{Type: api.TerminalStdin, Data: line})
+	}
+	first := readUntil(t, conn, api.TerminalStdout)
+	readUntil(t, conn, api.TerminalStdout)
+	conn.Close()
+
+	conn = dialTerminal(t, proc.Id, fmt.Sprintf("?lastEventId=%d", first.Seq))
+	if msg := readUntil(t, conn, api.TerminalStdout); msg.Data != "two\n" {
+		t.Errorf("replay after %d = %+v", first.Seq, msg)
+	}
+
+	executor.DeleteProcess(proc.Id)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("close after delete = %v", err)
+			}
+			break
+		}
+	}
+}
@@ -1,10 +1,10 @@
-import { useLayoutEffect, useState } from "react"
+import { useLayoutEffect, useRef, useState } from "react"
 import * as DialogPrimitive from "@radix-ui/react-dialog"
 import { FitAddon } from "@xterm/addon-fit"
 import { Terminal } from "@xterm/xterm"
 import "@xterm/xterm/css/xterm.css"
 import { Button } from "@/components/ui/button"
-import { createProcessManagerApi } from "./api"
+import type { TerminalMessage } from "./types"
 
 type TerminalDialogProps = {
   processId: string | null
@@ -15,6 +15,7 @@ type TerminalDialogProps = {
 export function TerminalDialog({ processId, token, onOpenChange }: TerminalDialogProps) {
   const [containerRef, setContainerRef] = useState<HTMLDivElement | null>(null)
   const [connectionError, setConnectionError] = useState<string | null>(null)
+  const restartRef = useRef<(() => void) | null>(null)
 
   useLayoutEffect(() => {
     setConnectionError(null)
@@ -23,7 +24,6 @@ export function TerminalDialog({ processId, token, onOpenChange }: TerminalDialo
       return
     }
 
-    const api = createProcessManagerApi(token)
     const term = new Terminal({
       fontFamily: "Consolas, 'Courier New', monospace",
       fontSize: 14,
@@ -40,33 +40,53 @@ export function TerminalDialog({ processId, token, onOpenChange }: TerminalDialo
     term.open(containerRef)
     fitAddon.fit()
 
-    term.writeln(`Connected to process ${processId}. Type and press Enter to send stdin.`)
+    term.writeln(`Connected to process ${processId}. Type and press Enter to send stdin, Ctrl+C sends SIGINT.`)
     term.write("\r\n")
 
+    const scheme = window.location.protocol === "https:" ? "wss" : "ws"
     const queryToken = token ? `?token=${encodeURIComponent(token)}` : ""
-    const stream = new EventSource(`/api/processes/${processId}/stdouterr${queryToken}`)
+    const socket = new WebSocket(`${scheme}://${window.location.host}/api/processes/${processId}/terminal${queryToken}`)
 
-    const writeEvent = (event: MessageEvent<string>) => {
-      term.write(event.data + "\r\n")
+    const send = (message: TerminalMessage) => {
+      if (socket.readyState === WebSocket.OPEN) {
+        socket.send(JSON.stringify(message))
+      }
     }
-
-    stream.addEventListener("stdout", writeEvent as EventListener)
-    stream.addEventListener("stderr", writeEvent as EventListener)
-    stream.onerror = () => {
-      setConnectionError("Terminal stream disconnected")
+    const sendSize = () => send({ type: "resize", cols: term.cols, rows: term.rows })
+    restartRef.current = () => send({ type: "restart" })
+
+    socket.onopen = sendSize
+    socket.onmessage = (event: MessageEvent<string>) => {
+      const message = JSON.parse(event.data) as TerminalMessage
+      switch (message.type) {
+        case "stdout":
+        case "stderr":
+          if (message.dropped) {
+            term.writeln(`[${message.dropped} lines of output skipped]`)
+          }
+          term.write(message.data ?? "")
+          break
+        case "status":
+          term.writeln(
+            message.exitCode === undefined
+              ? `[process ${message.status}]`
+              : `[process exited with code ${message.exitCode}, ${message.status}]`,
+          )
+          break
+        case "error":
+          setConnectionError(message.message ?? "Terminal error")
+          break
+      }
+    }
+    socket.onclose = (event) => {
+      setConnectionError(event.reason || "Terminal disconnected")
     }
 
     let stdinBuffer = ""
 
-    const sendStdin = async (value: string) => {
-      if (!value) {
-        return
-      }
-
-      try {
-        await api.sendStdin(processId, value)
-      } catch {
-        setConnectionError("Failed to send stdin data")
+    const sendStdin = (value: string) => {
+      if (value) {
+        send({ type: "stdin", data: value })
       }
     }
 
@@ -76,11 +96,18 @@ export function TerminalDialog({ processId, token, onOpenChange }: TerminalDialo
           const payload = `${stdinBuffer}\n`
           stdinBuffer = ""
           term.write("\r\n")
-          void sendStdin(payload)
+          sendStdin(payload)
           term.write("$ ")
           continue
         }
 
+        if (char === "\u0003") {
+          stdinBuffer = ""
+          term.write("^C\r\n")
+          send({ type: "signal", signal: "SIGINT" })
+          continue
+        }
+
         if (char === "\u007F") {
           if (stdinBuffer.length > 0) {
             stdinBuffer = stdinBuffer.slice(0, -1)
@@ -94,13 +121,18 @@ export function TerminalDialog({ processId, token, onOpenChange }: TerminalDialo
       }
     })
 
-    const onResize = () => fitAddon.fit()
+    const onResize = () => {
+      fitAddon.fit()
+      sendSize()
+    }
     window.addEventListener("resize", onResize)
 
     return () => {
       window.removeEventListener("resize", onResize)
       disposable.dispose()
-      stream.close()
+      socket.onclose = null
+      socket.close()
+      restartRef.current = null
       term.dispose()
     }
   }, [processId, token, containerRef])
@@ -114,11 +146,16 @@ export function TerminalDialog({ processId, token, onOpenChange }: TerminalDialo
             <DialogPrimitive.Title className="text-base font-semibold text-slate-100">
               Interactive Terminal {processId ? `- ${processId}` : ""}
             </DialogPrimitive.Title>
-            <DialogPrimitive.Close asChild>
-              <Button variant="outline" className="border-slate-100/20 bg-slate-900/30">
-                Close
+            <div className="flex items-center gap-2">
+              <Button variant="outline" className="border-slate-100/20 bg-slate-900/30" onClick={() => restartRef.current?.()}>
+                Restart
               </Button>
-            </DialogPrimitive.Close>
+              <DialogPrimitive.Close asChild>
+                <Button variant="outline" className="border-slate-100/20 bg-slate-900/30">
+                  Close
+                </Button>
+              </DialogPrimitive.Close>
+            </div>
           </div>
 
           {connectionError && <p className="text-sm text-red-300">{connectionError}</p>}
@@ -19,7 +19,7 @@ export function createProcessManagerApi(token: string) {
       let details = `${response.status} ${response.statusText}`
       try {
         const data = (await response.json()) as ApiResponse
-        details = data.params?.message || details
+        details = data.error?.message || details
       } catch {
         // Ignore JSON parse errors for non-JSON responses.
       }
@@ -28,7 +28,21 @@ export type ApiResponse = {
   result?: {
     processList?: Process[]
   }
-  params?: {
+  error?: {
+    code: number
     message?: string
   }
 }
+
+export type TerminalMessage = {
+  type: "stdout" | "stderr" | "stdin" | "resize" | "signal" | "restart" | "status" | "error"
+  data?: string
+  seq?: number
+  dropped?: number
+  cols?: number
+  rows?: number
+  signal?: string
+  status?: ProcessStatus
+  exitCode?: number
+  message?: string
+}